@@ -0,0 +1,287 @@
+// Command snote-cli talks to a running snote server through its HTTP API.
+//
+// The server URL and credentials are read from a JSON config file
+// (by default snote/cli.json in the user config directory):
+//
+//	{"server": "http://localhost:8081", "username": "me", "password": "secret"}
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sbrki/snote/internal/client"
+)
+
+type cliConfig struct {
+	Server   string `json:"server"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+const usage = `usage: snote-cli [-config file] [-server url] <command> [args]
+
+commands:
+  new [-template t] [-tag t] <id>
+                    create a note, from template t or the default template of tag t
+  cat <id>          print the contents of a note
+  edit <id>         edit a note in $EDITOR and save it on exit
+  ls [-tag t]       list notes, optionally only the ones tagged t
+  tags              list all tags with their number of notes
+  rm <id>           delete a note
+  upload <file>     upload a file and print a markdown snippet linking to it
+  append <id>       append stdin to a note`
+
+func main() {
+	flags := flag.NewFlagSet("snote-cli", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	configPath := flags.String("config", defaultConfigPath(), "path to the config file")
+	serverURL := flags.String("server", "", "server URL (overrides the config file)")
+	flags.Parse(os.Args[1:])
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fail(err)
+	}
+	if *serverURL != "" {
+		cfg.Server = *serverURL
+	}
+	c := client.New(cfg.Server, cfg.Username, cfg.Password)
+
+	args := flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err := run(c, args[0], args[1:]); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "snote-cli:", err)
+	os.Exit(1)
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "snote-cli.json"
+	}
+	return filepath.Join(dir, "snote", "cli.json")
+}
+
+// loadConfig reads the config file. A missing file is not an error,
+// the defaults point at a local server without credentials.
+func loadConfig(path string) (*cliConfig, error) {
+	cfg := &cliConfig{Server: "http://localhost:8081"}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// noteID returns the single note ID argument of a command.
+func noteID(command string, args []string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", fmt.Errorf("usage: snote-cli %s <id>", command)
+	}
+	return args[0], nil
+}
+
+func run(c *client.Client, command string, args []string) error {
+	switch command {
+	case "new":
+		return newNote(c, args)
+	case "cat":
+		id, err := noteID(command, args)
+		if err != nil {
+			return err
+		}
+		note, err := c.GetNote(id)
+		if err != nil {
+			return err
+		}
+		fmt.Print(note.Contents)
+		if !strings.HasSuffix(note.Contents, "\n") {
+			fmt.Println()
+		}
+		return nil
+	case "edit":
+		id, err := noteID(command, args)
+		if err != nil {
+			return err
+		}
+		return edit(c, id)
+	case "ls":
+		return ls(c, args)
+	case "tags":
+		return tags(c)
+	case "rm":
+		id, err := noteID(command, args)
+		if err != nil {
+			return err
+		}
+		return c.DeleteNote(id)
+	case "upload":
+		if len(args) != 1 {
+			return errors.New("usage: snote-cli upload <file>")
+		}
+		return upload(c, args[0])
+	case "append":
+		id, err := noteID(command, args)
+		if err != nil {
+			return err
+		}
+		return appendStdin(c, id)
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, usage)
+	}
+}
+
+// edit opens the note in $EDITOR and saves it if it was changed.
+func edit(c *client.Client, id string) error {
+	note, err := c.GetNote(id)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "snote-*.md")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(note.Contents); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	// $EDITOR may contain arguments, e.g. "code --wait"
+	editorArgs := strings.Fields(editor)
+	cmd := exec.Command(editorArgs[0], append(editorArgs[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", editor, err)
+	}
+
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(b, []byte(note.Contents)) {
+		fmt.Fprintln(os.Stderr, "no changes")
+		return nil
+	}
+	note.Contents = string(b)
+	return c.PutNote(note)
+}
+
+func newNote(c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("snote-cli new", flag.ContinueOnError)
+	template := flags.String("template", "", "fill the note from this template note")
+	tag := flags.String("tag", "", "fill the note from the default template of this tag")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	id, err := noteID("new", flags.Args())
+	if err != nil {
+		return err
+	}
+	return c.CreateNote(id, *template, *tag)
+}
+
+func ls(c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("snote-cli ls", flag.ContinueOnError)
+	tag := flags.String("tag", "", "only list notes with this tag")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	notes, err := c.ListNotes(*tag)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		fmt.Printf("%-30s %s  %s\n", note.ID, note.LastEdit.Format("2006-01-02 15:04"), note.Title)
+	}
+	return nil
+}
+
+func tags(c *client.Client) error {
+	notes, err := c.ListNotes("")
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	for _, note := range notes {
+		for _, tag := range note.Tags {
+			counts[tag]++
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for tag := range counts {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+	for _, tag := range names {
+		fmt.Printf("%-30s %d\n", tag, counts[tag])
+	}
+	return nil
+}
+
+// upload uploads a file and prints the same markdown snippet
+// the editor inserts for files dropped into it.
+func upload(c *client.Client, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	filename := filepath.Base(path)
+	location, err := c.UploadBlob(filename, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("![%s (%.2fM)](%s)\n", filename, float64(info.Size())/1e6, location)
+	return nil
+}
+
+func appendStdin(c *client.Client, id string) error {
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	note, err := c.GetNote(id)
+	if err != nil {
+		return err
+	}
+	if note.Contents != "" && !strings.HasSuffix(note.Contents, "\n") {
+		note.Contents += "\n"
+	}
+	note.Contents += string(b)
+	return c.PutNote(note)
+}
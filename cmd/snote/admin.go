@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sbrki/snote/internal/archive"
+	"github.com/sbrki/snote/internal/fsck"
+	"github.com/sbrki/snote/internal/storage"
+)
+
+func reindexCommand(name string, args []string) error {
+	st, _, err := openStorage(newFlagSet(name), args)
+	if err != nil {
+		return err
+	}
+	n, err := storage.Reindex(st)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reindexed %d notes\n", n)
+	return nil
+}
+
+func gcBlobsCommand(name string, args []string) error {
+	flags := newFlagSet(name)
+	dryRun := flags.Bool("dry-run", false, "only list the blobs that would be deleted")
+	st, _, err := openStorage(flags, args)
+	if err != nil {
+		return err
+	}
+
+	unusedBlobIDs, err := storage.UnusedBlobIDs(st)
+	if err != nil {
+		return err
+	}
+	for _, blobID := range unusedBlobIDs {
+		if *dryRun {
+			fmt.Println("would delete blob:", blobID)
+			continue
+		}
+		if err := st.DeleteBlob(blobID); err != nil {
+			return err
+		}
+		fmt.Println("deleted blob:", blobID)
+	}
+	return nil
+}
+
+func exportCommand(name string, args []string) error {
+	flags := newFlagSet(name)
+	output := flags.String("o", "-", "file to write the archive to (- for stdout)")
+	st, _, err := openStorage(flags, args)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return archive.Export(st, w)
+}
+
+func importCommand(name string, args []string) error {
+	flags := newFlagSet(name)
+	modeName := flags.String("mode", string(archive.Merge), "merge into the storage, or replace its contents")
+	st, rest, err := openStorage(flags, args)
+	if err != nil {
+		return err
+	}
+	mode, err := archive.ParseMode(*modeName)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if len(rest) > 0 && rest[0] != "-" {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	stats, err := archive.Import(st, r, mode)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d notes, %d blobs and %d tags\n", stats.Notes, stats.Blobs, stats.Tags)
+	if stats.Skipped > 0 {
+		fmt.Printf("kept %d notes that are newer in storage than in the archive\n", stats.Skipped)
+	}
+	return nil
+}
+
+func fsckCommand(name string, args []string) error {
+	flags := newFlagSet(name)
+	repair := flags.Bool("repair", false, "repair the problems that can be fixed without losing data")
+	st, _, err := openStorage(flags, args)
+	if err != nil {
+		return err
+	}
+
+	report, err := fsck.Check(st, *repair)
+	if err != nil {
+		return err
+	}
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("checked %d notes and %d blobs\n", report.Notes, report.Blobs)
+	if unrepaired := report.Unrepaired(); unrepaired > 0 {
+		return fmt.Errorf("%d unrepaired problems found", unrepaired)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sbrki/snote/internal/config"
+)
+
+// configCommand implements `snote config print`, which shows the effective
+// configuration after applying the config file, environment and flags.
+func configCommand(name string, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("usage: %s print [flags]", name)
+	}
+	cfg, _, err := config.Load(newFlagSet(name+" print"), args[1:])
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(cfg)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sbrki/snote/internal/enex"
+	"github.com/sbrki/snote/internal/obsidian"
+)
+
+// importObsidianCommand imports the markdown files and attachments of an Obsidian vault.
+func importObsidianCommand(name string, args []string) error {
+	flags := newFlagSet(name)
+	overwrite := flags.Bool("overwrite", false, "replace notes that already exist")
+	st, rest, err := openStorage(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errors.New("usage: snote import-obsidian [flags] <vault directory>")
+	}
+
+	report, err := obsidian.Import(st, rest[0], obsidian.Options{Overwrite: *overwrite})
+	if err != nil {
+		return err
+	}
+	for _, file := range report.Skipped {
+		fmt.Println("skipped (note exists):", file)
+	}
+	for _, problem := range report.Problems {
+		fmt.Println("not converted:", problem)
+	}
+	fmt.Printf("imported %d notes and %d attachments, skipped %d notes, %d problems\n",
+		len(report.Notes), report.Blobs, len(report.Skipped), len(report.Problems))
+	return nil
+}
+
+// importEnexCommand imports notes and their attachments from Evernote export files.
+func importEnexCommand(name string, args []string) error {
+	st, rest, err := openStorage(newFlagSet(name), args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return errors.New("usage: snote import-enex [flags] <file.enex>...")
+	}
+
+	for _, path := range rest {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		report, err := enex.Import(st, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		for _, title := range report.Skipped {
+			fmt.Println("skipped (imported before):", title)
+		}
+		for _, problem := range report.Problems {
+			fmt.Println("not converted:", problem)
+		}
+		fmt.Printf("%s: imported %d notes and %d attachments, skipped %d notes, %d problems\n",
+			path, len(report.Notes), report.Blobs, len(report.Skipped), len(report.Problems))
+	}
+	return nil
+}
@@ -1,39 +1,139 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
-	"text/template"
+	"sort"
+	"strings"
 
-	"github.com/labstack/echo"
+	"github.com/sbrki/snote/internal/config"
 	"github.com/sbrki/snote/internal/server"
 	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/web"
 )
 
-type Template struct {
-	templates *template.Template
+// command is a snote subcommand. args are the arguments following the
+// command name, they are parsed by the command itself.
+type command struct {
+	usage string
+	run   func(name string, args []string) error
 }
 
-func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	return t.templates.ExecuteTemplate(w, name, data)
+var commands = map[string]command{
+	"serve":           {"start the snote server (default)", serveCommand},
+	"config":          {"print the effective configuration (config print)", configCommand},
+	"reindex":         {"rebuild the tag index from the tags of all notes", reindexCommand},
+	"gc-blobs":        {"delete blobs that are not referenced by any note", gcBlobsCommand},
+	"export":          {"export all notes, blobs and tags to an archive", exportCommand},
+	"import":          {"import an archive created by export", importCommand},
+	"fsck":            {"check the storage for inconsistencies", fsckCommand},
+	"user":            {"manage users (user add|passwd|rm|ls)", userCommand},
+	"migrate":         {"copy everything from one storage to another", migrateCommand},
+	"export-site":     {"render notes to a static website", exportSiteCommand},
+	"import-enex":     {"import notes from Evernote .enex files", importEnexCommand},
+	"import-obsidian": {"import the notes and attachments of an Obsidian vault", importObsidianCommand},
 }
 
-var t = &Template{
-	templates: template.Must(template.ParseGlob("web/templates/*.html")),
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	cmd, found := commands[name]
+	if !found {
+		fmt.Fprintf(os.Stderr, "snote: unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+	if err := cmd.run("snote "+name, args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "snote:", err)
+		os.Exit(1)
+	}
 }
 
-func main() {
-	// setup disk storage
-	diskStoragePath, isSet := os.LookupEnv("STORAGE_PATH")
-	if !isSet {
-		diskStoragePath = "/tmp"
-	}
-	fmt.Println("Using storage path:", diskStoragePath)
-	ds := storage.NewDiskStorage(diskStoragePath)
-	// setup templates
-	tr := server.NewTemplateRegistry("web/templates/*.html")
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: snote [command] [flags]\n\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nrun `snote <command> -h` to list the flags of a command.")
+}
+
+// newFlagSet returns an empty flag set for the command name.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
+
+// openStorage loads the configuration and opens the configured storage.
+// It is used by all commands working directly on the storage.
+func openStorage(flags *flag.FlagSet, args []string) (storage.Storage, []string, error) {
+	cfg, rest, err := config.Load(flags, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := cfg.OpenStorage()
+	if err != nil {
+		return nil, nil, err
+	}
+	return st, rest, nil
+}
+
+func serveCommand(name string, args []string) error {
+	cfg, _, err := config.Load(newFlagSet(name), args)
+	if err != nil {
+		return err
+	}
+
+	// setup storage
+	fmt.Println("Using storage path:", cfg.Storage.Path)
+	st, err := cfg.OpenStorage()
+	if err != nil {
+		return err
+	}
+	// setup templates and static assets
+	webFS, assets, err := loadWeb(cfg.Dev)
+	if err != nil {
+		return err
+	}
+	tr := server.NewTemplateRegistry(webFS, "templates/*.html", assets)
 	// create server
-	serv := server.NewServer(ds, tr)
+	serv := server.NewServer(cfg, st, tr, assets)
 	serv.Run()
+	return nil
+}
+
+// loadWeb returns the web files (templates and static) and the static
+// assets. In dev mode they are read from the web directory instead of
+// the ones embedded in the binary. Missing vendored assets are an error,
+// the UI does not work without them.
+func loadWeb(dev bool) (fs.FS, *web.Assets, error) {
+	webDir := ""
+	if dev {
+		webDir = "web"
+	}
+	webFS := web.FS(webDir)
+	staticFS, err := fs.Sub(webFS, "static")
+	if err != nil {
+		return nil, nil, err
+	}
+	assets, err := web.NewAssets(staticFS, dev)
+	if err != nil {
+		return nil, nil, err
+	}
+	if missing := assets.MissingVendored(); len(missing) > 0 {
+		return nil, nil, fmt.Errorf("vendored assets %s are missing, run `go generate ./web`", strings.Join(missing, ", "))
+	}
+	return webFS, assets, nil
 }
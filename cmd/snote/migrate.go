@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sbrki/snote/internal/migrate"
+	"github.com/sbrki/snote/internal/storage"
+)
+
+// parseSpec splits a storage described as <backend>:<path>, e.g.
+// disk:/data, and normalizes the path, so that disk:/data and disk:/data/
+// compare equal.
+func parseSpec(spec string) (backend string, path string, err error) {
+	i := strings.Index(spec, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid storage %q, expected <backend>:<path> (backends: %s)",
+			spec, strings.Join(storage.Backends(), ", "))
+	}
+	path, err = filepath.Abs(spec[i+1:])
+	if err != nil {
+		return "", "", err
+	}
+	return spec[:i], path, nil
+}
+
+// migrateCommand copies everything from one storage to another.
+// Running it again after an interruption resumes the migration.
+func migrateCommand(name string, args []string) error {
+	flags := newFlagSet(name)
+	from := flags.String("from", "", "source storage as <backend>:<path>")
+	to := flags.String("to", "", "destination storage as <backend>:<path>")
+	quiet := flags.Bool("q", false, "do not list every copied note and blob")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return errors.New("both -from and -to are required")
+	}
+	fromBackend, fromPath, err := parseSpec(*from)
+	if err != nil {
+		return err
+	}
+	toBackend, toPath, err := parseSpec(*to)
+	if err != nil {
+		return err
+	}
+	if fromBackend == toBackend && fromPath == toPath {
+		return errors.New("source and destination are the same storage")
+	}
+
+	// a typo in the source must not be migrated as an empty storage
+	src, err := storage.OpenExisting(fromBackend, fromPath)
+	if err != nil {
+		return err
+	}
+	dst, err := storage.Open(toBackend, toPath)
+	if err != nil {
+		return err
+	}
+
+	progress := func(msg string) { fmt.Println(msg) }
+	if *quiet {
+		progress = nil
+	}
+	result, err := migrate.Migrate(src, dst, progress)
+	if err != nil {
+		return fmt.Errorf("%v (run the migration again to resume)", err)
+	}
+	fmt.Printf("copied %d notes and %d blobs (%d and %d already present), %d tags\n",
+		result.Notes, result.Blobs, result.NotesSkipped, result.BlobsSkipped, result.Tags)
+
+	if err := migrate.Verify(src, dst); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	fmt.Println("verified notes, blob checksums and tags")
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sbrki/snote/internal/config"
+	"github.com/sbrki/snote/internal/site"
+)
+
+// exportSiteCommand renders notes to html files that can be browsed without a server.
+func exportSiteCommand(name string, args []string) error {
+	flags := newFlagSet(name)
+	tag := flags.String("tag", "", "only export notes with this tag (default: all notes)")
+	out := flags.String("out", "", "output directory")
+	cfg, _, err := config.Load(flags, args)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("-out is required")
+	}
+	st, err := cfg.OpenStorage()
+	if err != nil {
+		return err
+	}
+	webFS, assets, err := loadWeb(cfg.Dev)
+	if err != nil {
+		return err
+	}
+
+	result, err := site.Export(st, site.Options{
+		Tag:            *tag,
+		Out:            *out,
+		Templates:      webFS,
+		Assets:         assets,
+		HighlightStyle: cfg.Render.HighlightStyle,
+	})
+	if err != nil {
+		return err
+	}
+	for _, problem := range result.Problems {
+		fmt.Println("warning:", problem)
+	}
+	fmt.Printf("exported %d notes and %d blobs to %s\n", result.Notes, result.Blobs, *out)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sbrki/snote/internal/auth"
+	"github.com/sbrki/snote/internal/config"
+)
+
+const userUsage = "usage: snote user add|passwd|rm <name> | snote user ls"
+
+// userCommand manages the users allowed to access the server.
+// Passwords are read from stdin, so they can also be piped in.
+func userCommand(name string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(userUsage)
+	}
+	action := args[0]
+	cfg, rest, err := config.Load(newFlagSet(name+" "+action), args[1:])
+	if err != nil {
+		return err
+	}
+	users := auth.NewUserStore(cfg.UsersFile())
+
+	if action == "ls" {
+		names, err := users.Names()
+		if err != nil {
+			return err
+		}
+		for _, userName := range names {
+			fmt.Println(userName)
+		}
+		return nil
+	}
+
+	if len(rest) != 1 {
+		return errors.New(userUsage)
+	}
+	userName := rest[0]
+	switch action {
+	case "add":
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		return users.Add(userName, password)
+	case "passwd":
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		return users.SetPassword(userName, password)
+	case "rm":
+		return users.Remove(userName)
+	default:
+		return errors.New(userUsage)
+	}
+}
+
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("password must not be empty")
+	}
+	return password, nil
+}
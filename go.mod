@@ -1,14 +1,16 @@
 module github.com/sbrki/snote
 
-go 1.15
+go 1.16
 
 require (
+	github.com/alecthomas/chroma v0.10.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
 	github.com/gomarkdown/markdown v0.0.0-20201113031856-722100d81a8e
 	github.com/labstack/echo v3.3.10+incompatible
 	github.com/labstack/gommon v0.3.1
 	github.com/patrickmn/go-cache v2.1.0+incompatible
-	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5 // indirect
-	golang.org/x/net v0.0.0-20211015210444-4f30a5c0130f // indirect
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/net v0.0.0-20211015210444-4f30a5c0130f
 	golang.org/x/text v0.3.7 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,596 @@
+// Package archive exports the contents of a storage to a single
+// tar.gz archive and imports such archives back into any storage.
+//
+// The archive starts with manifest.json, which lists every other file
+// in the archive along with its size and SHA-256 checksum. It is followed
+// by one JSON file per note under notes/, one file per blob under blobs/,
+// the tag index as tags.json and the flashcards with their review state as
+// cards.json. Imports are verified against the manifest before anything is
+// written to the storage.
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sbrki/snote/internal/storage"
+)
+
+const (
+	manifestName = "manifest.json"
+	tagsName     = "tags.json"
+	cardsName    = "cards.json"
+	notesDir     = "notes/"
+	blobsDir     = "blobs/"
+
+	formatVersion = 1
+)
+
+type Manifest struct {
+	Version int         `json:"version"`
+	Created time.Time   `json:"created"`
+	Notes   int         `json:"notes"`
+	Blobs   int         `json:"blobs"`
+	Files   []FileEntry `json:"files"`
+}
+
+type FileEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Mode selects how an archive is imported into a storage that is not empty.
+type Mode string
+
+const (
+	// Merge keeps the notes in storage. A note present in both the storage
+	// and the archive is replaced only if the archived copy is newer.
+	Merge Mode = "merge"
+	// Replace deletes all notes, blobs and tags before importing. The
+	// storage is exported first and restored if the import fails.
+	Replace Mode = "replace"
+)
+
+// ParseMode parses the name of an import mode.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case Merge, Replace:
+		return Mode(s), nil
+	}
+	return "", fmt.Errorf("unknown import mode %q (use %s or %s)", s, Merge, Replace)
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Export writes all notes, blobs and tags of st to w.
+func Export(st storage.Storage, w io.Writer) error {
+	manifest := &Manifest{Version: formatVersion, Created: time.Now()}
+
+	// notes and the tag index are small, they are kept in memory so that
+	// the archive holds exactly what the manifest describes.
+	noteIDs, err := st.GetAllNoteIDs()
+	if err != nil {
+		return err
+	}
+	sort.Strings(noteIDs)
+	notes := make(map[string][]byte)
+	noteEdits := make(map[string]time.Time)
+	for _, noteID := range noteIDs {
+		note, err := st.LoadNote(noteID)
+		if err != nil {
+			return fmt.Errorf("note %s: %v", noteID, err)
+		}
+		b, err := json.Marshal(note)
+		if err != nil {
+			return err
+		}
+		name := notesDir + noteID + ".json"
+		notes[name] = b
+		noteEdits[name] = note.LastEdit
+		manifest.Files = append(manifest.Files, FileEntry{name, int64(len(b)), checksum(b)})
+	}
+	manifest.Notes = len(noteIDs)
+
+	// blobs are streamed from storage, only their checksums are computed upfront
+	blobIDs, err := st.GetAllBlobIDs()
+	if err != nil {
+		return err
+	}
+	sort.Strings(blobIDs)
+	blobPaths := make(map[string]string)
+	for _, blobID := range blobIDs {
+		blobPath, err := st.LoadBlobPath(blobID)
+		if err != nil {
+			return err
+		}
+		size, sum, err := hashFile(blobPath)
+		if err != nil {
+			return err
+		}
+		name := blobsDir + blobID
+		blobPaths[name] = blobPath
+		manifest.Files = append(manifest.Files, FileEntry{name, size, sum})
+	}
+	manifest.Blobs = len(blobIDs)
+
+	tags, err := st.GetAllNoteTags()
+	if err != nil {
+		return err
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, FileEntry{tagsName, int64(len(tagsJSON)), checksum(tagsJSON)})
+
+	cards, err := st.GetAllCards()
+	if err != nil {
+		return err
+	}
+	cardsJSON, err := json.Marshal(cards)
+	if err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, FileEntry{cardsName, int64(len(cardsJSON)), checksum(cardsJSON)})
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	if err := writeFile(tw, manifestName, int64(len(manifestJSON)), bytes.NewReader(manifestJSON), manifest.Created); err != nil {
+		return err
+	}
+	for _, entry := range manifest.Files {
+		switch {
+		case entry.Path == tagsName:
+			err = writeFile(tw, entry.Path, entry.Size, bytes.NewReader(tagsJSON), manifest.Created)
+		case entry.Path == cardsName:
+			err = writeFile(tw, entry.Path, entry.Size, bytes.NewReader(cardsJSON), manifest.Created)
+		case strings.HasPrefix(entry.Path, notesDir):
+			err = writeFile(tw, entry.Path, entry.Size, bytes.NewReader(notes[entry.Path]), noteEdits[entry.Path])
+		default:
+			err = writeBlob(tw, entry, blobPaths[entry.Path], manifest.Created)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func writeBlob(tw *tar.Writer, entry FileEntry, path string, modTime time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeFile(tw, entry.Path, entry.Size, f, modTime)
+}
+
+func writeFile(tw *tar.Writer, name string, size int64, r io.Reader, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// Stats counts what was imported.
+type Stats struct {
+	Notes int `json:"notes"`
+	// Skipped counts notes that were kept because the copy in
+	// storage was newer than the archived one (only when merging).
+	Skipped int `json:"skipped"`
+	Blobs   int `json:"blobs"`
+	Tags    int `json:"tags"`
+}
+
+// Import reads an archive written by Export from r and restores it into st.
+// The archive is spooled to a temporary file and verified against its
+// manifest first, and its notes are decoded before the storage is
+// touched, so a damaged archive leaves the storage as it was. Blobs are
+// only checked against the manifest, blobs whose IDs do not match their
+// contents are left to fsck.
+func Import(st storage.Storage, r io.Reader, mode Mode) (*Stats, error) {
+	spool, err := ioutil.TempFile("", "snote-import-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	if _, err := io.Copy(spool, r); err != nil {
+		return nil, err
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	manifest, err := Verify(spool)
+	if err != nil {
+		return nil, err
+	}
+
+	// notes, tags and cards are decoded before anything is written, and
+	// blobs were checked by Verify, so a bad entry cannot leave a replaced
+	// storage empty
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	notes := make([]*storage.Note, 0, manifest.Notes)
+	var tags map[string][]string
+	var cards map[string]storage.Card
+	err = walk(spool, func(name string, r io.Reader) error {
+		switch {
+		case name == tagsName:
+			return json.NewDecoder(r).Decode(&tags)
+		case name == cardsName:
+			return json.NewDecoder(r).Decode(&cards)
+		case strings.HasPrefix(name, notesDir):
+			note := new(storage.Note)
+			if err := json.NewDecoder(r).Decode(note); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			// the file name is authoritative, like in the storage itself
+			note.ID = strings.TrimSuffix(strings.TrimPrefix(name, notesDir), ".json")
+			notes = append(notes, note)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != Replace {
+		return restore(st, spool, notes, tags, cards, mode)
+	}
+
+	// writing can still fail once the storage is cleared, so it is
+	// exported first to put it back in that case
+	backup, err := ioutil.TempFile("", "snote-backup-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(backup.Name())
+	defer backup.Close()
+	if err := Export(st, backup); err != nil {
+		return nil, fmt.Errorf("exporting the storage before replacing it: %v", err)
+	}
+	stats, err := func() (*Stats, error) {
+		if err := clearStorage(st); err != nil {
+			return nil, err
+		}
+		return restore(st, spool, notes, tags, cards, mode)
+	}()
+	if err != nil {
+		if rollbackErr := rollback(st, backup); rollbackErr != nil {
+			return nil, fmt.Errorf("%v (restoring the storage failed: %v)", err, rollbackErr)
+		}
+		return nil, err
+	}
+	return stats, nil
+}
+
+// rollback puts back the storage exported to backup by a failed import
+// in Replace mode.
+func rollback(st storage.Storage, backup io.ReadSeeker) error {
+	if _, err := backup.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := clearStorage(st); err != nil {
+		return err
+	}
+	_, err := Import(st, backup, Merge)
+	return err
+}
+
+// restore writes the notes, tags and cards decoded from the archive in
+// spool, and its blobs, to st.
+func restore(st storage.Storage, spool io.ReadSeeker, notes []*storage.Note, tags map[string][]string, cards map[string]storage.Card, mode Mode) (*Stats, error) {
+	stats := new(Stats)
+	imported := make([]string, 0, len(notes))
+	for _, note := range notes {
+		if mode == Merge {
+			existing, err := st.LoadNote(note.ID)
+			if err == nil && existing.LastEdit.After(note.LastEdit) {
+				stats.Skipped++
+				continue
+			}
+		}
+		if err := st.SaveNote(note); err != nil {
+			return nil, err
+		}
+		imported = append(imported, note.ID)
+		stats.Notes++
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	err := walk(spool, func(name string, r io.Reader) error {
+		if !strings.HasPrefix(name, blobsDir) {
+			return nil
+		}
+		data := new(bytes.Buffer)
+		if _, err := io.Copy(data, r); err != nil {
+			return err
+		}
+		if err := st.SaveBlob(strings.TrimPrefix(name, blobsDir), *data); err != nil {
+			return err
+		}
+		stats.Blobs++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the tag index maps tags to notes, but storage sets tags per note
+	noteTags := make(map[string][]string)
+	for tag, noteIDs := range tags {
+		for _, noteID := range noteIDs {
+			noteTags[noteID] = append(noteTags[noteID], tag)
+		}
+		stats.Tags++
+	}
+	for _, noteID := range imported {
+		tags := noteTags[noteID]
+		if tags == nil {
+			tags = []string{}
+		}
+		if err := st.SetNoteTags(noteID, tags); err != nil {
+			return nil, err
+		}
+		// fields, blocks and links are not archived, they are parsed from the notes again
+		note, err := st.LoadNote(noteID)
+		if err != nil {
+			return nil, err
+		}
+		if err := st.SetNoteFields(noteID, note.ParseFields()); err != nil {
+			return nil, err
+		}
+		if err := st.SetNoteBlocks(noteID, note.ParseBlockIDs()); err != nil {
+			return nil, err
+		}
+		// cards are parsed as well, only their review state is archived
+		noteCards := note.IndexedCards()
+		for i := range noteCards {
+			if archived, found := cards[noteCards[i].ID]; found {
+				noteCards[i].Review = archived.Review
+			}
+		}
+		if err := st.SetNoteCards(noteID, noteCards); err != nil {
+			return nil, err
+		}
+		if err := st.SetNoteLinks(noteID, note.ParseNoteLinks()); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// clearStorage deletes all notes, blobs, tags, fields, blocks, cards and
+// links from st.
+func clearStorage(st storage.Storage) error {
+	noteIDs, err := st.GetAllNoteIDs()
+	if err != nil {
+		return err
+	}
+	for _, noteID := range noteIDs {
+		if err := st.DeleteNote(noteID); err != nil {
+			return err
+		}
+	}
+	blobIDs, err := st.GetAllBlobIDs()
+	if err != nil {
+		return err
+	}
+	for _, blobID := range blobIDs {
+		if err := st.DeleteBlob(blobID); err != nil {
+			return err
+		}
+	}
+	tags, err := st.GetAllNoteTags()
+	if err != nil {
+		return err
+	}
+	for _, noteIDs := range tags {
+		for _, noteID := range noteIDs {
+			if storage.IsAutogenerated(noteID) {
+				continue
+			}
+			if err := st.SetNoteTags(noteID, []string{}); err != nil {
+				return err
+			}
+		}
+	}
+	fields, err := st.GetAllNoteFields()
+	if err != nil {
+		return err
+	}
+	for _, values := range fields {
+		for _, noteIDs := range values {
+			for _, noteID := range noteIDs {
+				if err := st.SetNoteFields(noteID, map[string][]string{}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	blocks, err := st.GetAllNoteBlocks()
+	if err != nil {
+		return err
+	}
+	for _, noteIDs := range blocks {
+		for _, noteID := range noteIDs {
+			if err := st.SetNoteBlocks(noteID, []string{}); err != nil {
+				return err
+			}
+		}
+	}
+	cards, err := st.GetAllCards()
+	if err != nil {
+		return err
+	}
+	for _, card := range cards {
+		if err := st.SetNoteCards(card.NoteID, []storage.Card{}); err != nil {
+			return err
+		}
+	}
+	links, err := st.GetAllNoteLinks()
+	if err != nil {
+		return err
+	}
+	for noteID := range links {
+		if err := st.SetNoteLinks(noteID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walk calls fn for every file in the archive read from r.
+func walk(r io.Reader, fn func(name string, r io.Reader) error) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Verify reads an archive from r and checks that it contains exactly
+// the files listed in its manifest, with matching checksums.
+func Verify(r io.Reader) (*Manifest, error) {
+	var manifest *Manifest
+	expected := make(map[string]FileEntry)
+	err := walk(r, func(name string, r io.Reader) error {
+		if manifest == nil {
+			if name != manifestName {
+				return errors.New("archive does not start with " + manifestName)
+			}
+			manifest = new(Manifest)
+			if err := json.NewDecoder(r).Decode(manifest); err != nil {
+				return fmt.Errorf("%s: %v", manifestName, err)
+			}
+			if manifest.Version != formatVersion {
+				return fmt.Errorf("unsupported archive version %d", manifest.Version)
+			}
+			for _, entry := range manifest.Files {
+				expected[entry.Path] = entry
+			}
+			return nil
+		}
+
+		entry, found := expected[name]
+		if !found {
+			return fmt.Errorf("%s is not listed in the manifest", name)
+		}
+		delete(expected, name)
+		if name != tagsName && name != cardsName && !strings.HasPrefix(name, notesDir) && !strings.HasPrefix(name, blobsDir) {
+			return fmt.Errorf("unexpected file %s", name)
+		}
+		if err := checkName(name); err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		size, err := io.Copy(hasher, r)
+		if err != nil {
+			return err
+		}
+		sum := hex.EncodeToString(hasher.Sum(nil))
+		if size != entry.Size || sum != entry.SHA256 {
+			return fmt.Errorf("%s: checksum mismatch", name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, errors.New("archive is empty")
+	}
+	for name := range expected {
+		return nil, fmt.Errorf("%s is missing from the archive", name)
+	}
+	return manifest, nil
+}
+
+// checkName rejects the names of notes and blobs that would end up outside
+// of their directories in the storage. The manifest comes from the archive
+// itself, so it cannot vouch for them.
+func checkName(name string) error {
+	if !fs.ValidPath(name) {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	switch {
+	case strings.HasPrefix(name, notesDir):
+		id := strings.TrimPrefix(name, notesDir)
+		if !strings.HasSuffix(id, ".json") || !storage.ValidNoteID(strings.TrimSuffix(id, ".json")) {
+			return fmt.Errorf("invalid note file name %q", name)
+		}
+	case strings.HasPrefix(name, blobsDir):
+		id := strings.TrimPrefix(name, blobsDir)
+		if id == "" || strings.Contains(id, "/") || strings.Contains(id, "..") {
+			return fmt.Errorf("invalid blob file name %q", name)
+		}
+	}
+	return nil
+}
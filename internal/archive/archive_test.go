@@ -0,0 +1,185 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/storage/storagetest"
+)
+
+func TestExportImport(t *testing.T) {
+	src := storagetest.TempStorage(t)
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := &storage.Note{ID: "a", Title: "A", Contents: "# A\n`tags: x`\n\nterm :: definition", LastEdit: old}
+	src.SaveNote(a)
+	src.SaveNote(&storage.Note{ID: "b", Title: "B", Contents: "# B", LastEdit: old})
+	src.SetNoteTags("a", []string{"x"})
+	src.SaveBlob(checksum([]byte("data")), *bytes.NewBufferString("data"))
+	card := a.ParseCards()[0]
+	src.SetNoteCards("a", []storage.Card{card})
+	src.SetCardReview(card.ID, storage.Review{Repetitions: 2, Interval: 6, Ease: 2.5})
+
+	archived := new(bytes.Buffer)
+	if err := Export(src, archived); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := Verify(bytes.NewReader(archived.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Notes != 2 || manifest.Blobs != 1 || len(manifest.Files) != 5 {
+		t.Error("wrong manifest:", manifest)
+	}
+
+	// merging keeps notes that are newer in the destination
+	dst := storagetest.TempStorage(t)
+	dst.SaveNote(&storage.Note{ID: "b", Contents: "# newer B", LastEdit: time.Now()})
+	dst.SaveNote(&storage.Note{ID: "c", Contents: "# C", LastEdit: time.Now()})
+	stats, err := Import(dst, bytes.NewReader(archived.Bytes()), Merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Notes != 1 || stats.Skipped != 1 || stats.Blobs != 1 {
+		t.Error("wrong stats:", stats)
+	}
+	note, err := dst.LoadNote("b")
+	if err != nil || note.Contents != "# newer B" {
+		t.Error("newer note was overwritten:", note)
+	}
+	note, err = dst.LoadNote("a")
+	if err != nil || !note.LastEdit.Equal(old) {
+		t.Error("last edit time not preserved:", note)
+	}
+	tags, _ := dst.GetAllNoteTags()
+	if len(tags["x"]) != 1 || tags["x"][0] != "a" {
+		t.Error("tags not imported:", tags)
+	}
+	if _, err := dst.LoadNote("c"); err != nil {
+		t.Error("merge removed a note")
+	}
+	cards, _ := dst.GetAllCards()
+	if cards[card.ID].Answer != "definition" || cards[card.ID].Review.Interval != 6 {
+		t.Error("card review state not imported:", cards)
+	}
+
+	// replacing removes everything that is not in the archive
+	if _, err := Import(dst, bytes.NewReader(archived.Bytes()), Replace); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.LoadNote("c"); err == nil {
+		t.Error("replace kept a note")
+	}
+	note, _ = dst.LoadNote("b")
+	if note.Contents != "# B" {
+		t.Error("replace kept the newer note:", note)
+	}
+}
+
+func TestImportRejectsDamagedArchive(t *testing.T) {
+	src := storagetest.TempStorage(t)
+	src.SaveNote(&storage.Note{ID: "a", Contents: "# A"})
+	archived := new(bytes.Buffer)
+	if err := Export(src, archived); err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := archived.Bytes()[:archived.Len()/2]
+	dst := storagetest.TempStorage(t)
+	dst.SaveNote(&storage.Note{ID: "keep", Contents: "# keep"})
+	if _, err := Import(dst, bytes.NewReader(truncated), Replace); err == nil {
+		t.Error("damaged archive was imported")
+	}
+	if _, err := dst.LoadNote("keep"); err != nil {
+		t.Error("damaged archive modified the storage")
+	}
+}
+
+// archiveOf writes an archive of files, in order, with a manifest listing them.
+func archiveOf(t *testing.T, files ...[2]string) []byte {
+	manifest := &Manifest{Version: formatVersion}
+	for _, file := range files {
+		manifest.Files = append(manifest.Files, FileEntry{file[0], int64(len(file[1])), checksum([]byte(file[1]))})
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := new(bytes.Buffer)
+	gw := gzip.NewWriter(b)
+	tw := tar.NewWriter(gw)
+	for _, file := range append([][2]string{{manifestName, string(manifestJSON)}}, files...) {
+		if err := writeFile(tw, file[0], int64(len(file[1])), strings.NewReader(file[1]), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gw.Close()
+	return b.Bytes()
+}
+
+func TestImportRejectsUnsafeNames(t *testing.T) {
+	dst := storagetest.TempStorage(t)
+	dst.SaveNote(&storage.Note{ID: "keep", Contents: "# keep"})
+
+	note := `{"id":"a","contents":"# A"}`
+	for _, files := range [][][2]string{
+		{{"notes/a.json", note}, {"blobs/../../x", "data"}},
+		{{"notes/../x.json", note}},
+		{{"notes/.a.json", note}},
+		{{"notes/a.json", note}, {"notes/b.json", "not json"}},
+	} {
+		if _, err := Import(dst, bytes.NewReader(archiveOf(t, files...)), Replace); err == nil {
+			t.Error("unsafe archive was imported:", files)
+		}
+		if _, err := dst.LoadNote("keep"); err != nil {
+			t.Fatal("unsafe archive modified the storage:", files)
+		}
+	}
+}
+
+func TestImportKeepsBlobsWithMismatchedIDs(t *testing.T) {
+	dst := storagetest.TempStorage(t)
+	archived := archiveOf(t, [2]string{"notes/a.json", `{"id":"a","contents":"# A"}`}, [2]string{"blobs/" + checksum([]byte("other")), "data"})
+	if _, err := Import(dst, bytes.NewReader(archived), Replace); err != nil {
+		t.Fatal(err)
+	}
+	if blobIDs, err := dst.GetAllBlobIDs(); err != nil || len(blobIDs) != 1 {
+		t.Error("blob was not imported:", blobIDs, err)
+	}
+}
+
+// failingBlobs is a storage that cannot save blobs.
+type failingBlobs struct {
+	*storage.DiskStorage
+}
+
+func (st failingBlobs) SaveBlob(id string, data bytes.Buffer) error {
+	return errors.New("disk full")
+}
+
+func TestReplaceRollsBack(t *testing.T) {
+	dst := storagetest.TempStorage(t)
+	dst.SaveNote(&storage.Note{ID: "keep", Contents: "# keep"})
+	dst.SetNoteTags("keep", []string{"x"})
+
+	archived := archiveOf(t, [2]string{"notes/a.json", `{"id":"a","contents":"# A"}`}, [2]string{"blobs/" + checksum([]byte("data")), "data"})
+	if _, err := Import(failingBlobs{dst}, bytes.NewReader(archived), Replace); err == nil {
+		t.Fatal("failed import did not report an error")
+	}
+	if _, err := dst.LoadNote("keep"); err != nil {
+		t.Error("failed import did not restore the storage")
+	}
+	if _, err := dst.LoadNote("a"); err == nil {
+		t.Error("failed import left a note of the archive")
+	}
+	if tags, _ := dst.GetAllNoteTags(); len(tags["x"]) != 1 {
+		t.Error("failed import did not restore the tags:", tags)
+	}
+}
@@ -0,0 +1,207 @@
+// Package auth manages the users that may access a snote server.
+// Users are stored, together with their bcrypt password hashes,
+// in a JSON file next to the storage.
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+type User struct {
+	Name         string `json:"name"`
+	PasswordHash string `json:"password_hash"`
+}
+
+type userFile struct {
+	Users []User `json:"users"`
+}
+
+// UserStore is a set of users persisted to a JSON file.
+// A missing file is treated as an empty set of users.
+type UserStore struct {
+	path string
+	mu   sync.Mutex
+	// users is the content of the file as of info, it is read again
+	// once the file changes.
+	users map[string]User
+	info  os.FileInfo
+	// verified holds the SHA-256 sums of the passwords that were checked
+	// successfully by user, so that bcrypt runs once per user and password.
+	verified map[string][sha256.Size]byte
+}
+
+func NewUserStore(path string) *UserStore {
+	return &UserStore{path: path}
+}
+
+// load returns a copy of the users, the file is only read if it changed
+// since it was last read.
+func (us *UserStore) load() (map[string]User, error) {
+	info, err := os.Stat(us.path)
+	switch {
+	case os.IsNotExist(err):
+		us.users, us.info = make(map[string]User), nil
+		us.verified = make(map[string][sha256.Size]byte)
+	case err != nil:
+		return nil, err
+	case us.info == nil || !os.SameFile(info, us.info) || !info.ModTime().Equal(us.info.ModTime()) || info.Size() != us.info.Size():
+		b, err := ioutil.ReadFile(us.path)
+		if err != nil {
+			return nil, err
+		}
+		uf := new(userFile)
+		if err := json.Unmarshal(b, uf); err != nil {
+			return nil, err
+		}
+		us.users, us.info = make(map[string]User), info
+		for _, user := range uf.Users {
+			us.users[user.Name] = user
+		}
+		us.verified = make(map[string][sha256.Size]byte)
+	}
+
+	users := make(map[string]User, len(us.users))
+	for name, user := range us.users {
+		users[name] = user
+	}
+	return users, nil
+}
+
+func (us *UserStore) save(users map[string]User) error {
+	uf := new(userFile)
+	uf.Users = make([]User, 0, len(users))
+	for _, user := range users {
+		uf.Users = append(uf.Users, user)
+	}
+	sort.Slice(uf.Users, func(i, j int) bool { return uf.Users[i].Name < uf.Users[j].Name })
+	b, err := json.MarshalIndent(uf, "", "\t")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so that a crash never leaves
+	// a truncated user file behind.
+	tmpPath := us.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, us.path)
+}
+
+// Names returns the sorted names of all users.
+func (us *UserStore) Names() ([]string, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	users, err := us.load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Add creates a new user. Returns ErrUserExists if the name is taken.
+func (us *UserStore) Add(name string, password string) error {
+	if name == "" {
+		return errors.New("user name must not be empty")
+	}
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	users, err := us.load()
+	if err != nil {
+		return err
+	}
+	if _, found := users[name]; found {
+		return ErrUserExists
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	users[name] = User{name, string(hash)}
+	return us.save(users)
+}
+
+// SetPassword changes the password of an existing user.
+func (us *UserStore) SetPassword(name string, password string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	users, err := us.load()
+	if err != nil {
+		return err
+	}
+	if _, found := users[name]; !found {
+		return ErrUserNotFound
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	users[name] = User{name, string(hash)}
+	return us.save(users)
+}
+
+// Remove deletes a user.
+func (us *UserStore) Remove(name string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	users, err := us.load()
+	if err != nil {
+		return err
+	}
+	if _, found := users[name]; !found {
+		return ErrUserNotFound
+	}
+	delete(users, name)
+	return us.save(users)
+}
+
+// Authenticate checks the password of the user name.
+func (us *UserStore) Authenticate(name string, password string) (bool, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	users, err := us.load()
+	if err != nil {
+		return false, err
+	}
+	user, found := users[name]
+	if !found {
+		return false, nil
+	}
+	sum := sha256.Sum256([]byte(password))
+	if verified, found := us.verified[name]; found && subtle.ConstantTimeCompare(verified[:], sum[:]) == 1 {
+		return true, nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return false, nil
+	}
+	us.verified[name] = sum
+	return true, nil
+}
+
+// Enabled reports whether any users exist. Servers without users
+// do not require authentication.
+func (us *UserStore) Enabled() (bool, error) {
+	names, err := us.Names()
+	if err != nil {
+		return false, err
+	}
+	return len(names) > 0, nil
+}
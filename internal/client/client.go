@@ -0,0 +1,189 @@
+// Package client is a client for the HTTP API of a snote server.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sbrki/snote/internal/storage"
+)
+
+var (
+	ErrNotFound = errors.New("note not found")
+	ErrConflict = errors.New("note already exists")
+)
+
+type Client struct {
+	// ServerURL is the base URL of the server, e.g. http://localhost:8081.
+	ServerURL string
+	// Username and Password are sent as HTTP basic auth credentials
+	// if Username is not empty.
+	Username string
+	Password string
+
+	HTTPClient *http.Client
+}
+
+func New(serverURL string, username string, password string) *Client {
+	return &Client{
+		ServerURL:  strings.TrimRight(serverURL, "/"),
+		Username:   username,
+		Password:   password,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+func (c *Client) do(method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.ServerURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// notePath returns the API path of the note with the given id. Nested IDs
+// keep their slashes, the server does not unescape them.
+func notePath(id string) string {
+	segments := strings.Split(id, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/api/note/" + strings.Join(segments, "/")
+}
+
+// checkStatus turns unexpected responses into errors.
+func checkStatus(resp *http.Response, expected int) error {
+	switch resp.StatusCode {
+	case expected:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrConflict
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(b)))
+}
+
+// ListNotes lists all notes, or only the ones tagged with tag if it is not empty.
+func (c *Client) ListNotes(tag string) ([]storage.NoteInfo, error) {
+	path := "/api/note"
+	if tag != "" {
+		path += "?tag=" + url.QueryEscape(tag)
+	}
+	resp, err := c.do(http.MethodGet, path, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	notes := make([]storage.NoteInfo, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// GetNote fetches a note.
+func (c *Client) GetNote(id string) (*storage.Note, error) {
+	resp, err := c.do(http.MethodGet, notePath(id), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	note := new(storage.Note)
+	if err := json.NewDecoder(resp.Body).Decode(note); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// CreateNote creates a new note, filled from the template note if it is not
+// empty, or else from the default template of tag if that is not empty.
+// Returns ErrConflict if it already exists.
+func (c *Client) CreateNote(id string, template string, tag string) error {
+	form := url.Values{}
+	form.Set("suggested_id", id)
+	if template != "" {
+		form.Set("template", template)
+	}
+	if tag != "" {
+		form.Set("tag", tag)
+	}
+	resp, err := c.do(http.MethodPost, "/api/note", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, http.StatusCreated)
+}
+
+// PutNote saves a note. The server sets its title and last edit time.
+func (c *Client) PutNote(note *storage.Note) error {
+	b, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPut, notePath(note.ID), "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, http.StatusOK)
+}
+
+// DeleteNote deletes a note.
+func (c *Client) DeleteNote(id string) error {
+	resp, err := c.do(http.MethodDelete, notePath(id), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, http.StatusOK)
+}
+
+// UploadBlob uploads the contents of r as a blob called filename
+// and returns the URL it is served at.
+func (c *Client) UploadBlob(filename string, r io.Reader) (string, error) {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(http.MethodPost, "/api/blob", mw.FormDataContentType(), body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusCreated); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("Location"), nil
+}
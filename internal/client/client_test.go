@@ -0,0 +1,65 @@
+package client
+
+import (
+	"io/fs"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sbrki/snote/internal/config"
+	"github.com/sbrki/snote/internal/server"
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/storage/storagetest"
+	"github.com/sbrki/snote/web"
+)
+
+// testServer starts a snote server on a temporary storage.
+func testServer(t *testing.T) *httptest.Server {
+	cfg := config.Default()
+	cfg.Storage.Path = t.TempDir()
+	staticFS, _ := fs.Sub(web.FS(""), "static")
+	assets, err := web.NewAssets(staticFS, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := server.NewTemplateRegistry(web.FS(""), "templates/*.html", assets)
+	ts := httptest.NewServer(server.NewServer(cfg, storagetest.TempStorage(t), tr, assets))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestNestedNoteIDs(t *testing.T) {
+	c := New(testServer(t).URL, "", "")
+
+	if err := c.CreateNote("a/b", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if note, err := c.GetNote("a/b"); err != nil || note.ID != "a/b" {
+		t.Fatal("created note not found:", note, err)
+	}
+	if err := c.PutNote(&storage.Note{ID: "journal/2026-10-18", Contents: "# Sunday, 18 October 2026"}); err != nil {
+		t.Fatal(err)
+	}
+	note, err := c.GetNote("journal/2026-10-18")
+	if err != nil || note.Title != "Sunday, 18 October 2026" {
+		t.Fatal("saved note not found:", note, err)
+	}
+	if err := c.DeleteNote("journal/2026-10-18"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetNote("journal/2026-10-18"); err != ErrNotFound {
+		t.Error("deleted note found:", err)
+	}
+}
+
+func TestReservedNoteIDs(t *testing.T) {
+	c := New(testServer(t).URL, "", "")
+
+	for _, id := range storage.ReservedNoteIDs {
+		if err := c.CreateNote(id, "", ""); err != ErrConflict {
+			t.Errorf("note %s created: %v", id, err)
+		}
+		if err := c.PutNote(&storage.Note{ID: id, Contents: "# " + id}); err == nil {
+			t.Errorf("note %s saved", id)
+		}
+	}
+}
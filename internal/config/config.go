@@ -0,0 +1,275 @@
+// Package config implements the configuration of snote.
+//
+// Every setting has a built-in default and can be overridden, in order of
+// increasing precedence, by a JSON config file, by an environment variable and
+// by a command line flag. The flag of a setting is named after its key in the
+// config file (e.g. -storage.path), and the environment variable is the key
+// uppercased with dots replaced by underscores and prefixed with SNOTE_
+// (e.g. SNOTE_STORAGE_PATH).
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/util"
+)
+
+type Config struct {
+	// Listen is the address the HTTP server listens on.
+	Listen string `json:"listen"`
+	// LogLevel is one of debug, info, warn, error or off.
+	LogLevel string `json:"log_level"`
+	// Dev makes the server load templates and static assets from ./web
+	// on disk instead of the copy embedded into the binary.
+	Dev bool `json:"dev"`
+
+	Storage StorageConfig `json:"storage"`
+	Cache   CacheConfig   `json:"cache"`
+	Jobs    JobsConfig    `json:"jobs"`
+	Upload  UploadConfig  `json:"upload"`
+	Auth    AuthConfig    `json:"auth"`
+	Render  RenderConfig  `json:"render"`
+	Journal JournalConfig `json:"journal"`
+}
+
+type StorageConfig struct {
+	// Backend is the name of the storage implementation (see storage.Open).
+	Backend string `json:"backend"`
+	// Path is the location of the storage, its meaning depends on Backend.
+	Path string `json:"path"`
+}
+
+type CacheConfig struct {
+	// RenderTTL is how long rendered note HTML is kept in the render cache.
+	RenderTTL Duration `json:"render_ttl"`
+	// RenderCleanupInterval is how often expired entries are purged.
+	RenderCleanupInterval Duration `json:"render_cleanup_interval"`
+	// RenderMaxEntries limits the number of cached notes (0 means unlimited).
+	RenderMaxEntries int `json:"render_max_entries"`
+}
+
+type JobsConfig struct {
+	// BlobGCInterval is how often blobs not referenced by any note are deleted.
+	BlobGCInterval Duration `json:"blob_gc_interval"`
+	// FsckInterval is how often the storage is checked for
+	// inconsistencies (0 disables the check).
+	FsckInterval Duration `json:"fsck_interval"`
+	// FsckRepair makes the scheduled check repair what it can.
+	FsckRepair bool `json:"fsck_repair"`
+}
+
+type UploadConfig struct {
+	// MaxSize is the maximum size of a single uploaded blob.
+	MaxSize Size `json:"max_size"`
+}
+
+type AuthConfig struct {
+	// UsersFile is the JSON file holding the users (see auth.UserStore).
+	// Defaults to users.json inside the storage path.
+	UsersFile string `json:"users_file"`
+}
+
+type RenderConfig struct {
+	// HighlightStyle is the color theme of highlighted code blocks
+	// (see storage.HighlightStyles).
+	HighlightStyle string `json:"highlight_style"`
+}
+
+type JournalConfig struct {
+	// Template is the ID of the template note of new journal entries
+	// (see storage.TemplateField). Without one, entries start with their date.
+	Template string `json:"template"`
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		Listen:   ":8081",
+		LogLevel: "info",
+		Storage: StorageConfig{
+			Backend: "disk",
+			Path:    "/tmp",
+		},
+		Cache: CacheConfig{
+			RenderTTL:             Duration(1 * time.Hour),
+			RenderCleanupInterval: Duration(1 * time.Minute),
+			RenderMaxEntries:      1000,
+		},
+		Jobs: JobsConfig{
+			BlobGCInterval: Duration(1 * time.Hour),
+			FsckInterval:   Duration(24 * time.Hour),
+		},
+		Upload: UploadConfig{
+			MaxSize: 2 << 30,
+		},
+		Render: RenderConfig{
+			HighlightStyle: storage.DefaultHighlightStyle,
+		},
+	}
+}
+
+// bind registers a flag for every setting of c on fs.
+func (c *Config) bind(fs *flag.FlagSet) {
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address the HTTP server listens on")
+	fs.StringVar(&c.LogLevel, "log_level", c.LogLevel, "log level (debug, info, warn, error, off)")
+	fs.BoolVar(&c.Dev, "dev", c.Dev, "load templates and static assets from ./web on disk instead of the embedded copy")
+	fs.StringVar(&c.Storage.Backend, "storage.backend", c.Storage.Backend, "storage backend ("+strings.Join(storage.Backends(), ", ")+")")
+	fs.StringVar(&c.Storage.Path, "storage.path", c.Storage.Path, "storage location")
+	fs.Var(&c.Cache.RenderTTL, "cache.render_ttl", "how long rendered notes are cached")
+	fs.Var(&c.Cache.RenderCleanupInterval, "cache.render_cleanup_interval", "how often expired rendered notes are purged")
+	fs.IntVar(&c.Cache.RenderMaxEntries, "cache.render_max_entries", c.Cache.RenderMaxEntries, "maximum number of cached rendered notes (0 is unlimited)")
+	fs.Var(&c.Jobs.BlobGCInterval, "jobs.blob_gc_interval", "how often unused blobs are deleted")
+	fs.Var(&c.Jobs.FsckInterval, "jobs.fsck_interval", "how often the storage is checked for inconsistencies (0 disables)")
+	fs.BoolVar(&c.Jobs.FsckRepair, "jobs.fsck_repair", c.Jobs.FsckRepair, "repair inconsistencies found by the scheduled check")
+	fs.Var(&c.Upload.MaxSize, "upload.max_size", "maximum size of an uploaded blob")
+	fs.StringVar(&c.Auth.UsersFile, "auth.users_file", c.Auth.UsersFile, "file holding the users (default: users.json in the storage path)")
+	fs.StringVar(&c.Render.HighlightStyle, "render.highlight_style", c.Render.HighlightStyle, "color theme of highlighted code")
+	fs.StringVar(&c.Journal.Template, "journal.template", c.Journal.Template, "template note of new journal entries")
+}
+
+// envName returns the environment variable overriding the setting key.
+func envName(key string) string {
+	return "SNOTE_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// Load builds the effective configuration from the defaults, the config
+// file, the environment and the command line flags in args. The settings
+// are registered on flags, which may already hold command specific flags.
+// It returns the remaining non-flag arguments.
+//
+// The config file is given by the -config flag or the SNOTE_CONFIG
+// environment variable. For backward compatibility, the STORAGE_PATH
+// environment variable is honored if SNOTE_STORAGE_PATH is not set.
+func Load(flags *flag.FlagSet, args []string) (*Config, []string, error) {
+	// the flags are first parsed into a scratch config, as they have
+	// to be applied last but they also carry the config file path.
+	configPath := flags.String("config", os.Getenv("SNOTE_CONFIG"), "path to a JSON config file")
+	Default().bind(flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	c := Default()
+	if *configPath != "" {
+		if err := c.loadFile(*configPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	settings := flag.NewFlagSet(flags.Name(), flag.ContinueOnError)
+	c.bind(settings)
+
+	// apply environment variables
+	if path, isSet := os.LookupEnv("STORAGE_PATH"); isSet {
+		c.Storage.Path = path
+	}
+	var err error
+	settings.VisitAll(func(f *flag.Flag) {
+		value, isSet := os.LookupEnv(envName(f.Name))
+		if !isSet || err != nil {
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, envName(f.Name), setErr)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// apply flags that were explicitly set
+	flags.Visit(func(f *flag.Flag) {
+		if setting := settings.Lookup(f.Name); setting != nil && err == nil {
+			err = setting.Value.Set(f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return c, flags.Args(), nil
+}
+
+func (c *Config) loadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+var logLevels = []string{"debug", "info", "warn", "error", "off"}
+
+// Validate checks that all settings have sensible values.
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	if c.Listen == "" {
+		problems = append(problems, "listen must not be empty")
+	}
+	if !util.SliceContainsString(logLevels, c.LogLevel) {
+		problems = append(problems, "log_level must be one of "+strings.Join(logLevels, ", "))
+	}
+	if !util.SliceContainsString(storage.Backends(), c.Storage.Backend) {
+		problems = append(problems, "storage.backend must be one of "+strings.Join(storage.Backends(), ", "))
+	}
+	if c.Storage.Path == "" {
+		problems = append(problems, "storage.path must not be empty")
+	}
+	if c.Cache.RenderTTL <= 0 {
+		problems = append(problems, "cache.render_ttl must be positive")
+	}
+	if c.Cache.RenderCleanupInterval <= 0 {
+		problems = append(problems, "cache.render_cleanup_interval must be positive")
+	}
+	if c.Cache.RenderMaxEntries < 0 {
+		problems = append(problems, "cache.render_max_entries must not be negative")
+	}
+	if c.Jobs.BlobGCInterval <= 0 {
+		problems = append(problems, "jobs.blob_gc_interval must be positive")
+	}
+	if c.Jobs.FsckInterval < 0 {
+		problems = append(problems, "jobs.fsck_interval must not be negative")
+	}
+	if c.Upload.MaxSize <= 0 {
+		problems = append(problems, "upload.max_size must be positive")
+	}
+	if !util.SliceContainsString(storage.HighlightStyles(), c.Render.HighlightStyle) {
+		problems = append(problems, "render.highlight_style must be one of "+strings.Join(storage.HighlightStyles(), ", "))
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// UsersFile returns the path of the file holding the users.
+func (c *Config) UsersFile() string {
+	if c.Auth.UsersFile != "" {
+		return c.Auth.UsersFile
+	}
+	return filepath.Join(c.Storage.Path, "users.json")
+}
+
+// OpenStorage opens the storage described by the configuration.
+func (c *Config) OpenStorage() (storage.Storage, error) {
+	return storage.Open(c.Storage.Backend, c.Storage.Path)
+}
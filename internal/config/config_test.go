@@ -0,0 +1,103 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "snote.json")
+	err := ioutil.WriteFile(configPath, []byte(`{
+		"listen": ":9000",
+		"storage": {"path": "/from/file"},
+		"cache": {"render_ttl": "10m"},
+		"upload": {"max_size": "1MiB"}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("SNOTE_STORAGE_PATH", "/from/env")
+	os.Setenv("SNOTE_CACHE_RENDER_TTL", "20m")
+	defer os.Unsetenv("SNOTE_STORAGE_PATH")
+	defer os.Unsetenv("SNOTE_CACHE_RENDER_TTL")
+
+	c, rest, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", configPath, "-cache.render_ttl", "30m", "extra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != ":9000" {
+		t.Error("config file value not applied:", c.Listen)
+	}
+	if c.Storage.Path != "/from/env" {
+		t.Error("environment should override config file:", c.Storage.Path)
+	}
+	if time.Duration(c.Cache.RenderTTL) != 30*time.Minute {
+		t.Error("flag should override environment:", c.Cache.RenderTTL)
+	}
+	if c.Upload.MaxSize != 1<<20 {
+		t.Error("wrong upload size:", c.Upload.MaxSize)
+	}
+	if c.Jobs.BlobGCInterval != Default().Jobs.BlobGCInterval {
+		t.Error("default not kept:", c.Jobs.BlobGCInterval)
+	}
+	if len(rest) != 1 || rest[0] != "extra" {
+		t.Error("wrong remaining args:", rest)
+	}
+}
+
+func TestLoadLegacyStoragePath(t *testing.T) {
+	os.Setenv("STORAGE_PATH", "/legacy")
+	defer os.Unsetenv("STORAGE_PATH")
+
+	c, _, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Storage.Path != "/legacy" {
+		t.Error("STORAGE_PATH not honored:", c.Storage.Path)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	if err := c.Validate(); err != nil {
+		t.Error("default config should be valid:", err)
+	}
+
+	c.Storage.Backend = "floppy"
+	c.Cache.RenderTTL = 0
+	if err := c.Validate(); err == nil {
+		t.Error("invalid config passed validation")
+	}
+}
+
+func TestSize(t *testing.T) {
+	cases := map[string]Size{
+		"512":   512,
+		"512B":  512,
+		"4K":    4 << 10,
+		"4KiB":  4 << 10,
+		"10MB":  10 << 20,
+		"2GiB":  2 << 30,
+		" 3 M ": 3 << 20,
+	}
+	for in, expected := range cases {
+		var s Size
+		if err := s.Set(in); err != nil || s != expected {
+			t.Errorf("Set(%q) = %d, %v; expected %d", in, s, err, expected)
+		}
+	}
+	var s Size
+	if err := s.Set("2G"); err != nil || s.String() != "2GiB" {
+		t.Error("wrong string:", s.String(), err)
+	}
+	if err := s.Set("lots"); err == nil {
+		t.Error("invalid size accepted")
+	}
+}
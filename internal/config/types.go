@@ -0,0 +1,98 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that is written as a human readable string
+// (e.g. "1h30m") in config files, flags and environment variables.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set implements flag.Value.
+func (d *Duration) Set(s string) error {
+	parsed, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1h30m\": %s", b)
+	}
+	return d.Set(s)
+}
+
+// Size is a size in bytes that can be written with a binary unit suffix
+// (e.g. "512KiB", "2G") in config files, flags and environment variables.
+type Size int64
+
+var sizeUnits = []struct {
+	suffixes   []string
+	multiplier int64
+}{
+	{[]string{"GiB", "GB", "G"}, 1 << 30},
+	{[]string{"MiB", "MB", "M"}, 1 << 20},
+	{[]string{"KiB", "KB", "K"}, 1 << 10},
+	{[]string{"B", ""}, 1},
+}
+
+func (s Size) String() string {
+	for _, unit := range sizeUnits {
+		if s != 0 && int64(s)%unit.multiplier == 0 {
+			return strconv.FormatInt(int64(s)/unit.multiplier, 10) + unit.suffixes[0]
+		}
+	}
+	return strconv.FormatInt(int64(s), 10) + "B"
+}
+
+// Set implements flag.Value.
+func (s *Size) Set(str string) error {
+	str = strings.TrimSpace(str)
+	for _, unit := range sizeUnits {
+		for _, suffix := range unit.suffixes {
+			if !strings.HasSuffix(str, suffix) {
+				continue
+			}
+			n, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(str, suffix)), 10, 64)
+			if err != nil {
+				continue
+			}
+			*s = Size(n * unit.multiplier)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid size %q (use e.g. \"512KiB\", \"2GiB\")", str)
+}
+
+func (s Size) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *Size) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("size must be a number of bytes or a string such as \"2GiB\": %s", b)
+	}
+	return s.Set(str)
+}
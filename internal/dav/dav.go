@@ -0,0 +1,397 @@
+// Package dav exposes a storage as a WebDAV file system.
+//
+// Every note is presented as a markdown file called <id>.md (note IDs
+// containing slashes are shown in subdirectories), and every blob as a
+// read-only file under attachments/. Writing a note file saves the note
+// just like the editor does, its title and tags are parsed from the
+// new contents.
+package dav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sbrki/snote/internal/storage"
+	"golang.org/x/net/webdav"
+)
+
+const attachmentsDir = "attachments"
+
+// FileSystem implements webdav.FileSystem on top of a storage.
+type FileSystem struct {
+	storage storage.Storage
+	// onChange is called with the ID of every note that is
+	// changed or deleted through the file system.
+	onChange func(id string)
+}
+
+// NewFileSystem creates a FileSystem for st. onChange may be nil.
+func NewFileSystem(st storage.Storage, onChange func(id string)) *FileSystem {
+	if onChange == nil {
+		onChange = func(string) {}
+	}
+	return &FileSystem{st, onChange}
+}
+
+// NewHandler returns a WebDAV handler serving st under prefix.
+func NewHandler(prefix string, st storage.Storage, onChange func(id string)) *webdav.Handler {
+	return &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: NewFileSystem(st, onChange),
+		LockSystem: webdav.NewMemLS(),
+	}
+}
+
+// node is what a cleaned file system path refers to.
+type node struct {
+	// dir is set for directories. the root directory has an empty name.
+	dir bool
+	// blobID is set for files under attachments/.
+	blobID string
+	// noteID is set for note files.
+	noteID string
+	name   string
+}
+
+func parse(name string) node {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	switch {
+	case name == "" || name == attachmentsDir:
+		return node{dir: true, name: name}
+	case strings.HasPrefix(name, attachmentsDir+"/"):
+		return node{blobID: strings.TrimPrefix(name, attachmentsDir+"/"), name: name}
+	case strings.HasSuffix(name, ".md"):
+		return node{noteID: strings.TrimSuffix(name, ".md"), name: name}
+	default:
+		// anything else can only be a directory of notes
+		return node{dir: true, name: name}
+	}
+}
+
+func (fs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	// directories only exist implicitly through the IDs of the notes in them
+	return os.ErrPermission
+}
+
+func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	n := parse(name)
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+
+	switch {
+	case n.dir:
+		if writable {
+			return nil, os.ErrPermission
+		}
+		entries, err := fs.readDir(n.name)
+		if err != nil {
+			return nil, err
+		}
+		info := fileInfo{name: path.Base("/" + n.name), dir: true, modTime: time.Now()}
+		return &dirFile{info: info, entries: entries}, nil
+
+	case n.blobID != "":
+		if writable {
+			return nil, os.ErrPermission
+		}
+		blobPath, err := fs.storage.LoadBlobPath(n.blobID)
+		if err != nil {
+			return nil, os.ErrNotExist
+		}
+		return os.Open(blobPath)
+
+	default:
+		if !validNoteID(n.noteID) {
+			return nil, os.ErrPermission
+		}
+		note, err := fs.storage.LoadNote(n.noteID)
+		if err != nil {
+			if !writable || flag&os.O_CREATE == 0 {
+				return nil, os.ErrNotExist
+			}
+			note = &storage.Note{ID: n.noteID}
+		}
+		f := &noteFile{fs: fs, note: note, writable: writable}
+		if flag&os.O_TRUNC == 0 {
+			f.data = []byte(note.Contents)
+		} else {
+			f.dirty = true
+		}
+		return f, nil
+	}
+}
+
+func validNoteID(id string) bool {
+	return id != "" && !storage.IsReserved(id) &&
+		!strings.HasPrefix(id, attachmentsDir+"/") &&
+		!strings.HasPrefix(path.Base(id), ".")
+}
+
+func (fs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	n := parse(name)
+	switch {
+	case n.dir:
+		return os.ErrPermission
+	case n.blobID != "":
+		if _, err := fs.storage.LoadBlobPath(n.blobID); err != nil {
+			return os.ErrNotExist
+		}
+		return fs.storage.DeleteBlob(n.blobID)
+	default:
+		if _, err := fs.storage.LoadNote(n.noteID); err != nil {
+			return os.ErrNotExist
+		}
+		if err := fs.storage.DeleteNote(n.noteID); err != nil {
+			return err
+		}
+		fs.onChange(n.noteID)
+		return storage.UnindexNote(fs.storage, n.noteID)
+	}
+}
+
+func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldNode, newNode := parse(oldName), parse(newName)
+	if oldNode.noteID == "" || newNode.noteID == "" || !validNoteID(newNode.noteID) {
+		return os.ErrPermission
+	}
+	if _, err := fs.storage.LoadNote(oldNode.noteID); err != nil {
+		return os.ErrNotExist
+	}
+
+	err := storage.RenameNote(fs.storage, oldNode.noteID, newNode.noteID)
+	fs.onChange(oldNode.noteID)
+	fs.onChange(newNode.noteID)
+	return err
+}
+
+func (fs *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	n := parse(name)
+	switch {
+	case n.dir:
+		if n.name != "" && n.name != attachmentsDir {
+			// a directory exists if there are notes in it
+			entries, err := fs.readDir(n.name)
+			if err != nil {
+				return nil, err
+			}
+			if len(entries) == 0 {
+				return nil, os.ErrNotExist
+			}
+		}
+		return fileInfo{name: path.Base("/" + n.name), dir: true, modTime: time.Now()}, nil
+	case n.blobID != "":
+		blobPath, err := fs.storage.LoadBlobPath(n.blobID)
+		if err != nil {
+			return nil, os.ErrNotExist
+		}
+		return os.Stat(blobPath)
+	default:
+		note, err := fs.storage.LoadNote(n.noteID)
+		if err != nil {
+			return nil, os.ErrNotExist
+		}
+		return noteInfo(note), nil
+	}
+}
+
+// readDir lists the directory dir ("" is the root directory).
+func (fs *FileSystem) readDir(dir string) ([]os.FileInfo, error) {
+	entries := make([]os.FileInfo, 0)
+
+	if dir == attachmentsDir {
+		blobIDs, err := fs.storage.GetAllBlobIDs()
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(blobIDs)
+		for _, blobID := range blobIDs {
+			blobPath, err := fs.storage.LoadBlobPath(blobID)
+			if err != nil {
+				continue
+			}
+			info, err := os.Stat(blobPath)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, info)
+		}
+		return entries, nil
+	}
+
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	} else {
+		entries = append(entries, fileInfo{name: attachmentsDir, dir: true, modTime: time.Now()})
+	}
+
+	noteIDs, err := fs.storage.GetAllNoteIDs()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(noteIDs)
+	subdirs := make(map[string]bool)
+	for _, noteID := range noteIDs {
+		if !strings.HasPrefix(noteID, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(noteID, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			// the note is in a subdirectory
+			subdir := rest[:i]
+			if !subdirs[subdir] {
+				subdirs[subdir] = true
+				entries = append(entries, fileInfo{name: subdir, dir: true, modTime: time.Now()})
+			}
+			continue
+		}
+		note, err := fs.storage.LoadNote(noteID)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, noteInfo(note))
+	}
+	return entries, nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+}
+
+func noteInfo(note *storage.Note) fileInfo {
+	return fileInfo{
+		name:    path.Base(note.ID) + ".md",
+		size:    int64(len(note.Contents)),
+		modTime: note.LastEdit,
+	}
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.dir }
+func (fi fileInfo) Sys() interface{}   { return nil }
+func (fi fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ContentType implements webdav.ContentTyper.
+func (fi fileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.dir {
+		return "", webdav.ErrNotImplemented
+	}
+	return "text/markdown; charset=utf-8", nil
+}
+
+type dirFile struct {
+	info    fileInfo
+	entries []os.FileInfo
+	pos     int
+}
+
+func (f *dirFile) Close() error                                 { return nil }
+func (f *dirFile) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (f *dirFile) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (f *dirFile) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (f *dirFile) Stat() (os.FileInfo, error)                   { return f.info, nil }
+
+func (f *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	rest := f.entries[f.pos:]
+	if count <= 0 {
+		f.pos = len(f.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.pos += count
+	return rest[:count], nil
+}
+
+// noteFile holds the contents of a note in memory.
+// If it was written to, the note is saved when the file is closed.
+type noteFile struct {
+	fs       *FileSystem
+	note     *storage.Note
+	data     []byte
+	pos      int64
+	writable bool
+	dirty    bool
+}
+
+func (f *noteFile) Read(p []byte) (int, error) {
+	if f.pos >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *noteFile) Write(p []byte) (int, error) {
+	if !f.writable {
+		return 0, os.ErrPermission
+	}
+	end := f.pos + int64(len(p))
+	if end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	copy(f.data[f.pos:], p)
+	f.pos = end
+	f.dirty = true
+	return len(p), nil
+}
+
+func (f *noteFile) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = f.pos + offset
+	case io.SeekEnd:
+		pos = int64(len(f.data)) + offset
+	}
+	if pos < 0 {
+		return 0, errors.New("negative seek position")
+	}
+	f.pos = pos
+	return pos, nil
+}
+
+func (f *noteFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *noteFile) Stat() (os.FileInfo, error) {
+	info := noteInfo(f.note)
+	info.size = int64(len(f.data))
+	return info, nil
+}
+
+func (f *noteFile) Close() error {
+	if !f.dirty || bytes.Equal(f.data, []byte(f.note.Contents)) && f.note.LastEdit != (time.Time{}) {
+		return nil
+	}
+	f.note.Contents = string(f.data)
+	if err := storage.UpdateNote(f.fs.storage, f.note); err != nil {
+		return err
+	}
+	f.fs.onChange(f.note.ID)
+	return nil
+}
@@ -0,0 +1,105 @@
+package dav
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sbrki/snote/internal/storage/storagetest"
+	"github.com/sbrki/snote/internal/util"
+)
+
+type davClient struct {
+	t      *testing.T
+	server *httptest.Server
+}
+
+func (c davClient) do(method string, path string, body string, headers map[string]string) (int, string) {
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestFileSystem(t *testing.T) {
+	st := storagetest.TempStorage(t)
+	st.SaveBlob("blob1", *bytes.NewBufferString("attachment"))
+
+	changed := make([]string, 0)
+	handler := NewHandler("/dav", st, func(id string) { changed = append(changed, id) })
+	c := davClient{t, httptest.NewServer(handler)}
+	defer c.server.Close()
+
+	// writing a file creates a note with title and tags
+	status, _ := c.do("PUT", "/dav/hello.md", "# Hello\n`tags: greeting`\n", nil)
+	if status != http.StatusCreated {
+		t.Fatal("PUT failed:", status)
+	}
+	note, err := st.LoadNote("hello")
+	if err != nil || note.Title != "Hello" {
+		t.Fatal("note not saved:", note, err)
+	}
+	tags, _ := st.GetAllNoteTags()
+	if !util.SliceContainsString(tags["greeting"], "hello") {
+		t.Error("tags not saved:", tags)
+	}
+	if !util.SliceContainsString(changed, "hello") {
+		t.Error("change not reported")
+	}
+
+	// reading it back
+	status, body := c.do("GET", "/dav/hello.md", "", nil)
+	if status != http.StatusOK || body != note.Contents {
+		t.Error("GET returned", status, body)
+	}
+
+	// listing the root directory shows notes and the attachments directory
+	status, body = c.do("PROPFIND", "/dav/", "", map[string]string{"Depth": "1"})
+	if status != http.StatusMultiStatus || !strings.Contains(body, "hello.md") || !strings.Contains(body, "attachments") {
+		t.Error("PROPFIND returned", status, body)
+	}
+	status, body = c.do("GET", "/dav/attachments/blob1", "", nil)
+	if status != http.StatusOK || body != "attachment" {
+		t.Error("GET blob returned", status, body)
+	}
+	status, _ = c.do("PUT", "/dav/attachments/blob2", "nope", nil)
+	if status == http.StatusCreated {
+		t.Error("attachments should be read-only")
+	}
+
+	// renaming moves the note and its tags
+	status, _ = c.do("MOVE", "/dav/hello.md", "", map[string]string{"Destination": c.server.URL + "/dav/bye.md"})
+	if status != http.StatusCreated && status != http.StatusNoContent {
+		t.Fatal("MOVE failed:", status)
+	}
+	if _, err := st.LoadNote("hello"); err == nil {
+		t.Error("old note still exists")
+	}
+	tags, _ = st.GetAllNoteTags()
+	if !util.SliceContainsString(tags["greeting"], "bye") || util.SliceContainsString(tags["greeting"], "hello") {
+		t.Error("tags not moved:", tags)
+	}
+
+	// deleting removes the note from storage and the tag index
+	status, _ = c.do("DELETE", "/dav/bye.md", "", nil)
+	if status != http.StatusNoContent {
+		t.Error("DELETE failed:", status)
+	}
+	tags, _ = st.GetAllNoteTags()
+	if _, found := tags["greeting"]; found {
+		t.Error("tag index not updated:", tags)
+	}
+}
@@ -0,0 +1,203 @@
+// Package enex imports Evernote exports (.enex files) into a storage.
+//
+// The ENML contents of every note are converted to markdown. Attached
+// resources are decoded and saved as blobs keyed by their SHA-256 checksum,
+// like uploads through the API, and <en-media> elements are replaced by
+// links to them. Evernote tags are written in snote's `tags:` convention.
+package enex
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/util"
+)
+
+// enexNote is a <note> element of an export.
+type enexNote struct {
+	Title     string         `xml:"title"`
+	Content   string         `xml:"content"`
+	Created   string         `xml:"created"`
+	Updated   string         `xml:"updated"`
+	Tags      []string       `xml:"tag"`
+	Resources []enexResource `xml:"resource"`
+}
+
+type enexResource struct {
+	Data struct {
+		Encoding string `xml:"encoding,attr"`
+		Value    string `xml:",chardata"`
+	} `xml:"data"`
+	Mime       string `xml:"mime"`
+	Attributes struct {
+		FileName string `xml:"file-name"`
+	} `xml:"resource-attributes"`
+}
+
+// Problem is something that could not be converted faithfully.
+type Problem struct {
+	Note    string `json:"note"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return p.Note + ": " + p.Message
+}
+
+type Report struct {
+	// Notes are the IDs of the imported notes.
+	Notes []string `json:"notes"`
+	// Skipped are the titles of notes that were imported before.
+	Skipped  []string  `json:"skipped"`
+	Blobs    int       `json:"blobs"`
+	Problems []Problem `json:"problems"`
+}
+
+// timeLayout is the format of <created> and <updated>.
+const timeLayout = "20060102T150405Z"
+
+// Import reads an export from r and saves its notes to st.
+//
+// Note IDs are derived from the titles. A note whose ID is taken gets a
+// numbered suffix, unless the stored note has the same update time, in
+// which case it is considered imported already and skipped. Importing
+// the same file twice therefore does not duplicate notes.
+func Import(st storage.Storage, r io.Reader) (*Report, error) {
+	report := &Report{Notes: []string{}, Skipped: []string{}, Problems: []Problem{}}
+
+	decoder := xml.NewDecoder(r)
+	// exports contain a doctype and html entities
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+		n := new(enexNote)
+		if err := decoder.DecodeElement(n, &start); err != nil {
+			return nil, err
+		}
+		if err := importNote(st, n, report); err != nil {
+			return nil, fmt.Errorf("%s: %v", n.Title, err)
+		}
+	}
+	return report, nil
+}
+
+func importNote(st storage.Storage, n *enexNote, report *Report) error {
+	title := strings.TrimSpace(n.Title)
+	if title == "" {
+		title = "Untitled"
+	}
+	created, _ := time.Parse(timeLayout, n.Created)
+	updated, err := time.Parse(timeLayout, n.Updated)
+	if err != nil {
+		updated = created
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	// find a free ID
+	base := storage.SlugID(title)
+	id := base
+	for i := 2; ; i++ {
+		existing, err := st.LoadNote(id)
+		if err != nil && !storage.IsReserved(id) {
+			break
+		}
+		if err == nil && existing.LastEdit.Equal(updated) {
+			report.Skipped = append(report.Skipped, title)
+			return nil
+		}
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	// save the resources, en-media elements refer to them by their MD5 hash
+	resources := make(map[string]media)
+	for _, resource := range n.Resources {
+		if resource.Data.Encoding != "" && resource.Data.Encoding != "base64" {
+			report.Problems = append(report.Problems, Problem{title, "resource with unknown encoding " + resource.Data.Encoding})
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(resource.Data.Value), ""))
+		if err != nil {
+			report.Problems = append(report.Problems, Problem{title, "invalid resource data: " + err.Error()})
+			continue
+		}
+		sha := sha256.Sum256(data)
+		checksum := hex.EncodeToString(sha[:])
+		if err := st.SaveBlob(checksum, *bytes.NewBuffer(data)); err != nil {
+			return err
+		}
+		report.Blobs++
+
+		name := resource.Attributes.FileName
+		if name == "" {
+			name = "attachment"
+			if extensions, _ := mime.ExtensionsByType(resource.Mime); len(extensions) > 0 {
+				name += extensions[0]
+			}
+		}
+		sum := md5.Sum(data)
+		resources[hex.EncodeToString(sum[:])] = media{
+			// the blob url format is the one Note.ParseBlobIDs recognises
+			url:   "/api/blob/" + checksum + "/" + url.PathEscape(name),
+			name:  name,
+			image: strings.HasPrefix(resource.Mime, "image/"),
+		}
+	}
+
+	body, problems, err := convertENML(n.Content, resources)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		report.Problems = append(report.Problems, Problem{title, problem})
+	}
+
+	tags := make([]string, 0)
+	for _, tag := range n.Tags {
+		// commas separate tags in the tags line
+		tag = strings.ToLower(strings.TrimSpace(strings.Replace(tag, ",", " ", -1)))
+		if tag != "" && !util.SliceContainsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	contents := "# " + title + "\n"
+	if len(tags) > 0 {
+		contents += "`tags: " + strings.Join(tags, ", ") + "`\n"
+	}
+	contents += "\n" + body
+
+	note := &storage.Note{ID: id, Contents: contents, LastEdit: updated, Created: created}
+	note.Title = note.ParseTitle()
+	if err := st.SaveNote(note); err != nil {
+		return err
+	}
+	if err := storage.IndexNote(st, note); err != nil {
+		return err
+	}
+	report.Notes = append(report.Notes, id)
+	return nil
+}
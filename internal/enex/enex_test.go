@@ -0,0 +1,101 @@
+package enex
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbrki/snote/internal/storage/storagetest"
+)
+
+func TestImport(t *testing.T) {
+	image := []byte("not really a png")
+	sum := md5.Sum(image)
+	hash := hex.EncodeToString(sum[:])
+	export := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export export-date="20210101T000000Z" application="Evernote" version="10">
+<note>
+<title>Trip to Split</title>
+<content><![CDATA[<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><div>Pack <b>light</b>&nbsp;and <i>early</i>.</div>
+<h2>Checklist</h2>
+<div><en-todo checked="true"/>tickets</div>
+<div><en-todo checked="false"/>sunscreen</div>
+<ul><li>ferry<ul><li>morning</li></ul></li><li>bus</li></ul>
+<ol><li>one</li><li>two</li></ol>
+<table><tr><th>Day</th><th>Place</th></tr><tr><td>Mon</td><td>Hvar | Vis</td></tr></table>
+<div style="-en-codeblock:true;"><div>ls -la</div><div>cd *</div></div>
+<div>line one<br/>line two<br/></div>
+<div><en-media hash="` + hash + `" type="image/png"/></div>
+<div><en-media hash="0000" type="application/pdf"/></div>
+<div>see <a href="https://example.com">example</a> [1]</div>
+</en-note>]]></content>
+<created>20190301T101500Z</created>
+<updated>20200415T083000Z</updated>
+<tag>Travel</tag>
+<tag>croatia</tag>
+<resource>
+<data encoding="base64">` + base64.StdEncoding.EncodeToString(image) + `</data>
+<mime>image/png</mime>
+<resource-attributes><file-name>map.png</file-name></resource-attributes>
+</resource>
+</note>
+</en-export>`
+
+	st := storagetest.TempStorage(t)
+
+	report, err := Import(st, strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Notes) != 1 || report.Blobs != 1 || len(report.Problems) != 1 {
+		t.Fatal("wrong report:", report)
+	}
+	note, err := st.LoadNote("trip-to-split")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"# Trip to Split\n`tags: travel, croatia`\n",
+		"Pack **light** and *early*.",
+		"## Checklist",
+		"- [x] tickets",
+		"- [ ] sunscreen",
+		"- ferry\n  - morning\n- bus",
+		"1. one\n2. two",
+		"| Day | Place |\n| --- | --- |\n| Mon | Hvar \\| Vis |",
+		"```\nls -la\ncd *\n```",
+		"line one\\\nline two\n\n",
+		"![map.png](/api/blob/",
+		"see [example](https://example.com) \\[1\\]",
+	} {
+		if !strings.Contains(note.Contents, expected) {
+			t.Errorf("%q not found in:\n%s", expected, note.Contents)
+		}
+	}
+	if len(note.ParseBlobIDs()) != 1 {
+		t.Error("blob link is not recognised:", note.Contents)
+	}
+	if !note.Created.Equal(time.Date(2019, 3, 1, 10, 15, 0, 0, time.UTC)) ||
+		!note.LastEdit.Equal(time.Date(2020, 4, 15, 8, 30, 0, 0, time.UTC)) {
+		t.Error("timestamps not kept:", note.Created, note.LastEdit)
+	}
+	tags, _ := st.GetAllNoteTags()
+	if len(tags["travel"]) != 1 || len(tags["croatia"]) != 1 {
+		t.Error("tags not set:", tags)
+	}
+
+	// importing again skips the note
+	report, err = Import(st, strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Notes) != 0 || len(report.Skipped) != 1 {
+		t.Error("note imported twice:", report)
+	}
+}
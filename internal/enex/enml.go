@@ -0,0 +1,321 @@
+package enex
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// media is a saved resource that <en-media> elements link to.
+type media struct {
+	url   string
+	name  string
+	image bool
+}
+
+// hardBreak marks a <br>. It becomes a backslash line break, unless it
+// ends a block, where a line break is implied anyway.
+const hardBreak = "\x01\n"
+
+var (
+	whitespace    = regexp.MustCompile(`[\s\x{a0}]+`)
+	trailingSpace = regexp.MustCompile(`[ \t]+\n`)
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+	emptyLines    = regexp.MustCompile(`\n([ \t]*\n)+`)
+	endingBreaks  = regexp.MustCompile("\x01+(\n\\s*\n|\\s*$)")
+	markdownChars = strings.NewReplacer(`\`, `\\`, "*", `\*`, "`", "\\`", "[", `\[`, "]", `\]`)
+)
+
+type converter struct {
+	media    map[string]media
+	problems []string
+	// listDepth is the number of lists around the current node.
+	listDepth int
+}
+
+// convertENML converts the ENML document content to markdown. It returns
+// the markdown and descriptions of everything that was dropped.
+func convertENML(content string, resources map[string]media) (string, []string, error) {
+	root, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return "", nil, err
+	}
+	c := &converter{media: resources}
+	markdown := c.children(root)
+
+	markdown = endingBreaks.ReplaceAllString(markdown, "$1")
+	markdown = strings.Replace(markdown, "\x01", `\`, -1)
+	markdown = trailingSpace.ReplaceAllString(markdown, "\n")
+	markdown = blankLines.ReplaceAllString(markdown, "\n\n")
+	return strings.TrimSpace(markdown) + "\n", c.problems, nil
+}
+
+func (c *converter) problem(format string, a ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, a...))
+}
+
+func (c *converter) children(n *html.Node) string {
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(c.node(child))
+	}
+	return b.String()
+}
+
+func (c *converter) node(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return markdownChars.Replace(whitespace.ReplaceAllString(n.Data, " "))
+	case html.ElementNode:
+	case html.DocumentNode:
+		return c.children(n)
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "head", "title", "style", "script":
+		return ""
+	case "div":
+		if strings.Contains(attr(n, "style"), "-en-codeblock") {
+			return codeBlock(plainText(n))
+		}
+		return block(c.children(n))
+	case "p":
+		return block(c.children(n))
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.Data[1:])
+		heading := strings.Replace(trimBlock(c.children(n)), hardBreak, " ", -1)
+		return block(strings.Repeat("#", level) + " " + heading)
+	case "br":
+		return hardBreak
+	case "hr":
+		return block("---")
+	case "b", "strong":
+		return wrap(c.children(n), "**")
+	case "i", "em":
+		return wrap(c.children(n), "*")
+	case "s", "strike", "del":
+		return wrap(c.children(n), "~~")
+	case "code", "tt":
+		text := plainText(n)
+		if text == "" {
+			return ""
+		}
+		if strings.Contains(text, "`") {
+			return "`` " + text + " ``"
+		}
+		return "`" + text + "`"
+	case "pre":
+		return codeBlock(plainText(n))
+	case "a":
+		text := strings.TrimSpace(c.children(n))
+		href := attr(n, "href")
+		if href == "" {
+			return text
+		}
+		if text == "" {
+			text = href
+		}
+		return "[" + text + "](" + href + ")"
+	case "img":
+		return "![" + attr(n, "alt") + "](" + attr(n, "src") + ")"
+	case "blockquote":
+		lines := strings.Split(trimBlock(c.children(n)), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimSpace("> " + line)
+		}
+		return block(strings.Join(lines, "\n"))
+	case "ul", "ol":
+		return c.list(n)
+	case "table":
+		return c.table(n)
+	// html parses the self-closing ENML elements as start tags, so the
+	// content following them ends up as their children.
+	case "en-todo":
+		checkbox := "[ ] "
+		if attr(n, "checked") == "true" {
+			checkbox = "[x] "
+		}
+		if c.listDepth == 0 {
+			checkbox = "- " + checkbox
+		}
+		return checkbox + c.children(n)
+	case "en-media":
+		m, found := c.media[attr(n, "hash")]
+		if !found {
+			c.problem("missing resource %s (%s)", attr(n, "hash"), attr(n, "type"))
+			return c.children(n)
+		}
+		if m.image {
+			return "![" + m.name + "](" + m.url + ")" + c.children(n)
+		}
+		return "[" + m.name + "](" + m.url + ")" + c.children(n)
+	case "en-crypt":
+		c.problem("encrypted text dropped")
+		return ""
+	}
+	return c.children(n)
+}
+
+// list converts ul and ol elements, including Evernote's checklists,
+// which are lists styled with --en-todo.
+func (c *converter) list(n *html.Node) string {
+	todo := strings.Contains(attr(n, "style"), "--en-todo:true")
+	c.listDepth++
+	defer func() { c.listDepth-- }()
+
+	items := make([]string, 0)
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+		bullet := "-"
+		if n.Data == "ol" {
+			bullet = strconv.Itoa(len(items)+1) + "."
+		}
+		marker := bullet + " "
+		if todo {
+			if strings.Contains(attr(li, "style"), "--en-checked:true") {
+				marker += "[x] "
+			} else {
+				marker += "[ ] "
+			}
+		}
+		// items are kept tight, nested lists are indented below the item text
+		content := emptyLines.ReplaceAllString(trimBlock(c.children(li)), "\n")
+		lines := strings.Split(content, "\n")
+		indent := strings.Repeat(" ", len(bullet)+1)
+		for i := range lines {
+			if i == 0 {
+				lines[i] = marker + lines[i]
+			} else if strings.TrimSpace(lines[i]) != "" {
+				lines[i] = indent + lines[i]
+			}
+		}
+		items = append(items, strings.Join(lines, "\n"))
+	}
+	return block(strings.Join(items, "\n"))
+}
+
+// table converts a table to a markdown table, using its first row as the header.
+func (c *converter) table(n *html.Node) string {
+	rows := make([][]string, 0)
+	columns := 0
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "thead", "tbody", "tfoot":
+				collect(child)
+			case "tr":
+				row := make([]string, 0)
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type != html.ElementNode || (cell.Data != "td" && cell.Data != "th") {
+						continue
+					}
+					text := trimBlock(c.children(cell))
+					text = strings.Replace(text, hardBreak, "<br>", -1)
+					text = whitespace.ReplaceAllString(text, " ")
+					row = append(row, strings.Replace(text, "|", `\|`, -1))
+				}
+				if len(row) > columns {
+					columns = len(row)
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	collect(n)
+	if len(rows) == 0 || columns == 0 {
+		return ""
+	}
+
+	lines := make([]string, 0, len(rows)+1)
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return block(strings.Join(lines, "\n"))
+}
+
+// block surrounds content with blank lines.
+func block(content string) string {
+	content = trimBlock(content)
+	if content == "" {
+		return ""
+	}
+	return "\n\n" + content + "\n\n"
+}
+
+// trimBlock trims whitespace and line breaks around content.
+func trimBlock(content string) string {
+	return strings.Trim(content, " \t\n\x01")
+}
+
+// wrap surrounds content with an emphasis delimiter, keeping
+// surrounding spaces outside of it.
+func wrap(content string, delimiter string) string {
+	trimmed := strings.TrimSpace(content)
+	if trimmed == "" {
+		return content
+	}
+	start := content[:strings.Index(content, trimmed)]
+	end := content[len(start)+len(trimmed):]
+	return start + delimiter + trimmed + delimiter + end
+}
+
+func codeBlock(code string) string {
+	code = strings.Trim(code, "\n")
+	fence := "```"
+	if strings.Contains(code, fence) {
+		fence = "~~~"
+	}
+	return "\n\n" + fence + "\n" + code + "\n" + fence + "\n\n"
+}
+
+// plainText returns the text content of n, with block elements
+// and <br> on lines of their own.
+func plainText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			b.WriteString("\n")
+		default:
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				walk(child)
+			}
+			if n.Type == html.ElementNode && (n.Data == "div" || n.Data == "p") {
+				if !strings.HasSuffix(b.String(), "\n") {
+					b.WriteString("\n")
+				}
+			}
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sbrki/snote/internal/storage"
+)
+
+// ankiHeader tells Anki how to import the file: plain text fields
+// separated by commas, with the tags of each card in the third column.
+const ankiHeader = "#separator:comma\n#html:false\n#tags column:3\n"
+
+// AnkiCSV writes the flashcards (see Note.ParseCards) of the notes having
+// tag, or one of its descendant tags, to w as a CSV file Anki can import.
+// An empty tag selects every card. Each row holds the question, the
+// answer and the tags of the note of the card, hierarchical tags joined
+// with "::" like Anki does.
+func AnkiCSV(st storage.Storage, tag string, w io.Writer) error {
+	cards, err := st.GetAllCards()
+	if err != nil {
+		return err
+	}
+	tagIndex, err := st.GetAllNoteTags()
+	if err != nil {
+		return err
+	}
+	noteTags := make(map[string][]string)
+	for t, noteIDs := range tagIndex {
+		for _, noteID := range noteIDs {
+			noteTags[noteID] = append(noteTags[noteID], strings.ReplaceAll(t, "/", "::"))
+		}
+	}
+	var tagged map[string]bool
+	if tag != "" {
+		tagged = make(map[string]bool)
+		for _, noteID := range storage.TaggedNoteIDs(tagIndex, tag) {
+			tagged[noteID] = true
+		}
+	}
+
+	selected := make([]storage.Card, 0, len(cards))
+	for _, card := range cards {
+		if tagged == nil || tagged[card.NoteID] {
+			selected = append(selected, card)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].NoteID != selected[j].NoteID {
+			return selected[i].NoteID < selected[j].NoteID
+		}
+		return selected[i].Line < selected[j].Line
+	})
+
+	if _, err := io.WriteString(w, ankiHeader); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	for _, card := range selected {
+		tags := noteTags[card.NoteID]
+		sort.Strings(tags)
+		if err := cw.Write([]string{card.Question, card.Answer, strings.Join(tags, " ")}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
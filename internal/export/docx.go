@@ -0,0 +1,420 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	_ "image/gif" // register the formats of images embedded in documents
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/sbrki/snote/internal/storage"
+)
+
+const (
+	// emuPerPixel converts pixels at 96 dpi to the EMUs used by drawings.
+	emuPerPixel = 9525
+	// maxImageWidth is the text width of an A4 page with 1 inch margins, in EMUs.
+	maxImageWidth = 5760720
+)
+
+type docxMedia struct {
+	name string
+	data []byte
+}
+
+// docxWriter builds the document.xml of a DOCX file and collects the
+// relationships (hyperlinks and images) and numberings it refers to.
+type docxWriter struct {
+	storage storage.Storage
+	body    strings.Builder
+
+	relationships []string
+	// media are the embedded images
+	media []docxMedia
+	// orderedLists are the start numbers of the ordered lists, each gets its own numbering.
+	orderedLists []int
+	drawings     int
+
+	// state of the walk through the markdown AST
+	bold, italic, strike int
+	quote                int
+	hyperlink            bool
+	lists                []int // numbering IDs of the enclosing lists
+	firstInItem          bool  // whether the next paragraph is the first of a list item
+	headerCell           bool
+}
+
+// DOCX writes note to w as an Office Open XML (Word) document. It covers
+// headings, emphasis, lists, tables, code, links and images stored as blobs.
+func DOCX(st storage.Storage, note *storage.Note, w io.Writer) error {
+	d := &docxWriter{storage: st}
+
+	rootNode := storage.ParseMarkdown(note.Contents)
+	ast.WalkFunc(rootNode, d.walk)
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name     string
+		contents string
+	}{
+		{"[Content_Types].xml", docxContentTypes},
+		{"_rels/.rels", docxRootRelationships},
+		{"word/_rels/document.xml.rels", d.documentRelationships()},
+		{"word/styles.xml", docxStyles},
+		{"word/numbering.xml", d.numbering()},
+		{"word/document.xml", docxDocumentStart + d.body.String() + docxDocumentEnd},
+	}
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file.contents); err != nil {
+			return err
+		}
+	}
+	for _, m := range d.media {
+		f, err := zw.Create("word/" + m.name)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(m.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (d *docxWriter) walk(node ast.Node, entering bool) ast.WalkStatus {
+	switch n := node.(type) {
+	case *ast.Heading:
+		if entering {
+			d.startParagraph(fmt.Sprintf("Heading%d", n.Level))
+		} else {
+			d.body.WriteString("</w:p>")
+		}
+	case *ast.Paragraph:
+		if _, inCell := n.Parent.(*ast.TableCell); inCell {
+			break
+		}
+		if entering {
+			d.startParagraph("")
+		} else {
+			d.body.WriteString("</w:p>")
+		}
+	case *ast.List:
+		if entering {
+			numID := 1 // bullets share one numbering
+			if n.ListFlags&ast.ListTypeOrdered != 0 {
+				start := n.Start
+				if start < 1 {
+					start = 1
+				}
+				d.orderedLists = append(d.orderedLists, start)
+				numID = len(d.orderedLists) + 1
+			}
+			d.lists = append(d.lists, numID)
+		} else {
+			d.lists = d.lists[:len(d.lists)-1]
+		}
+	case *ast.ListItem:
+		d.firstInItem = entering
+	case *ast.BlockQuote:
+		if entering {
+			d.quote++
+		} else {
+			d.quote--
+		}
+	case *ast.HorizontalRule:
+		d.body.WriteString(`<w:p><w:pPr><w:pBdr><w:bottom w:val="single" w:sz="6" w:space="1" w:color="auto"/></w:pBdr></w:pPr></w:p>`)
+	case *ast.CodeBlock:
+		for _, line := range strings.Split(strings.TrimSuffix(string(n.Literal), "\n"), "\n") {
+			d.body.WriteString(`<w:p><w:pPr><w:pStyle w:val="Code"/></w:pPr>`)
+			d.run(line, "")
+			d.body.WriteString("</w:p>")
+		}
+	case *ast.MathBlock:
+		if entering {
+			d.body.WriteString(`<w:p><w:pPr><w:pStyle w:val="Code"/></w:pPr>`)
+			d.run(strings.TrimSpace(string(n.Literal)), "")
+			d.body.WriteString("</w:p>")
+		}
+		return ast.SkipChildren
+	case *ast.Table:
+		if entering {
+			d.body.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="0" w:type="auto"/></w:tblPr><w:tblGrid>`)
+			for i := 0; i < tableColumns(n); i++ {
+				d.body.WriteString(`<w:gridCol/>`)
+			}
+			d.body.WriteString(`</w:tblGrid>`)
+		} else {
+			// a table directly followed by another one would be merged with it
+			d.body.WriteString(`</w:tbl><w:p/>`)
+		}
+	case *ast.TableRow:
+		if entering {
+			d.body.WriteString("<w:tr>")
+		} else {
+			d.body.WriteString("</w:tr>")
+		}
+	case *ast.TableCell:
+		if entering {
+			d.headerCell = n.IsHeader
+			d.body.WriteString("<w:tc><w:p>")
+		} else {
+			d.headerCell = false
+			d.body.WriteString("</w:p></w:tc>")
+		}
+	case *ast.Strong:
+		d.bold += nesting(entering)
+	case *ast.Emph:
+		d.italic += nesting(entering)
+	case *ast.Del:
+		d.strike += nesting(entering)
+	case *ast.Link:
+		if entering {
+			id := d.relationship("hyperlink", string(n.Destination), true)
+			d.body.WriteString(`<w:hyperlink r:id="` + id + `">`)
+			d.hyperlink = true
+		} else {
+			d.body.WriteString("</w:hyperlink>")
+			d.hyperlink = false
+		}
+	case *ast.Image:
+		if entering && !d.image(n) {
+			// show the alt text in place of images that cannot be embedded
+			return ast.GoToNext
+		}
+		return ast.SkipChildren
+	case *ast.Text:
+		d.run(strings.Replace(string(n.Literal), "\n", " ", -1), "")
+	case *ast.Code:
+		d.run(string(n.Literal), "CodeChar")
+	case *ast.Math:
+		d.run(string(n.Literal), "CodeChar")
+	case *ast.Hardbreak:
+		d.body.WriteString("<w:r><w:br/></w:r>")
+	}
+	return ast.GoToNext
+}
+
+func nesting(entering bool) int {
+	if entering {
+		return 1
+	}
+	return -1
+}
+
+// startParagraph opens a paragraph with the given style, which is
+// overridden inside quotes and numbered inside lists.
+func (d *docxWriter) startParagraph(style string) {
+	d.body.WriteString("<w:p><w:pPr>")
+	if style == "" && d.quote > 0 {
+		style = "Quote"
+	}
+	if style != "" {
+		d.body.WriteString(`<w:pStyle w:val="` + style + `"/>`)
+	}
+	if len(d.lists) > 0 {
+		level := len(d.lists) - 1
+		if d.firstInItem {
+			fmt.Fprintf(&d.body, `<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, level, d.lists[level])
+			d.firstInItem = false
+		} else {
+			fmt.Fprintf(&d.body, `<w:ind w:left="%d"/>`, 720*(level+1))
+		}
+	}
+	d.body.WriteString("</w:pPr>")
+}
+
+// run writes text with the current formatting.
+func (d *docxWriter) run(text string, style string) {
+	if text == "" {
+		return
+	}
+	d.body.WriteString("<w:r><w:rPr>")
+	if d.hyperlink && style == "" {
+		style = "Hyperlink"
+	}
+	if style != "" {
+		d.body.WriteString(`<w:rStyle w:val="` + style + `"/>`)
+	}
+	if d.bold > 0 || d.headerCell {
+		d.body.WriteString("<w:b/>")
+	}
+	if d.italic > 0 {
+		d.body.WriteString("<w:i/>")
+	}
+	if d.strike > 0 {
+		d.body.WriteString("<w:strike/>")
+	}
+	d.body.WriteString(`</w:rPr><w:t xml:space="preserve">` + escapeXML(text) + "</w:t></w:r>")
+}
+
+// image embeds an image stored as a blob. It returns false if the
+// image is not a blob or not in a format Word can show.
+func (d *docxWriter) image(n *ast.Image) bool {
+	destination := strings.TrimSpace(string(n.Destination))
+	if !strings.HasPrefix(destination, "/api/blob/") {
+		return false
+	}
+	blobID := strings.Split(destination, "/")[3]
+	blobPath, err := d.storage.LoadBlobPath(blobID)
+	if err != nil {
+		return false
+	}
+	data, err := ioutil.ReadFile(blobPath)
+	if err != nil {
+		return false
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width == 0 || config.Height == 0 {
+		return false
+	}
+
+	name := fmt.Sprintf("media/image%d.%s", len(d.media)+1, format)
+	d.media = append(d.media, docxMedia{name, data})
+	id := d.relationship("image", name, false)
+
+	width, height := config.Width*emuPerPixel, config.Height*emuPerPixel
+	if width > maxImageWidth {
+		height = height * maxImageWidth / width
+		width = maxImageWidth
+	}
+	d.drawings++
+	fmt.Fprintf(&d.body, `<w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0">`+
+		`<wp:extent cx="%d" cy="%d"/><wp:docPr id="%d" name="Picture %d"/>`+
+		`<a:graphic xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main">`+
+		`<a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture">`+
+		`<pic:pic xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture">`+
+		`<pic:nvPicPr><pic:cNvPr id="%d" name="%s"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="%s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`+
+		`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r>`,
+		width, height, d.drawings, d.drawings, d.drawings, name, id, width, height)
+	return true
+}
+
+// relationship adds a relationship of the document to target and returns
+// its ID. The first two IDs are taken by the styles and the numbering.
+func (d *docxWriter) relationship(kind string, target string, external bool) string {
+	id := fmt.Sprintf("rId%d", len(d.relationships)+3)
+	mode := ""
+	if external {
+		mode = ` TargetMode="External"`
+	}
+	d.relationships = append(d.relationships, `<Relationship Id="`+id+`" `+
+		`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/`+kind+`" `+
+		`Target="`+escapeXML(target)+`"`+mode+`/>`)
+	return id
+}
+
+func tableColumns(table *ast.Table) int {
+	columns := 0
+	ast.WalkFunc(table, func(node ast.Node, entering bool) ast.WalkStatus {
+		if row, ok := node.(*ast.TableRow); ok && entering {
+			if len(row.Children) > columns {
+				columns = len(row.Children)
+			}
+			return ast.SkipChildren
+		}
+		return ast.GoToNext
+	})
+	return columns
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (d *docxWriter) documentRelationships() string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/>
+` + strings.Join(d.relationships, "\n") + `
+</Relationships>`
+}
+
+// numbering defines bullets (numbering 1) and one numbering for every
+// ordered list, so that each list starts counting on its own.
+func (d *docxWriter) numbering() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:abstractNum w:abstractNumId="0"><w:multiLevelType w:val="hybridMultilevel"/>`)
+	for level := 0; level < 9; level++ {
+		fmt.Fprintf(&b, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="bullet"/><w:lvlText w:val="%s"/><w:lvlJc w:val="left"/>`+
+			`<w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`, level, []string{"•", "◦", "▪"}[level%3], 720*(level+1))
+	}
+	b.WriteString(`</w:abstractNum>
+<w:abstractNum w:abstractNumId="1"><w:multiLevelType w:val="hybridMultilevel"/>`)
+	for level := 0; level < 9; level++ {
+		fmt.Fprintf(&b, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="decimal"/><w:lvlText w:val="%%%d."/><w:lvlJc w:val="left"/>`+
+			`<w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`, level, level+1, 720*(level+1))
+	}
+	b.WriteString("</w:abstractNum>\n")
+	b.WriteString(`<w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num>` + "\n")
+	for i, start := range d.orderedLists {
+		fmt.Fprintf(&b, `<w:num w:numId="%d"><w:abstractNumId w:val="1"/>`, i+2)
+		for level := 0; level < 9; level++ {
+			fmt.Fprintf(&b, `<w:lvlOverride w:ilvl="%d"><w:startOverride w:val="%d"/></w:lvlOverride>`, level, start)
+		}
+		b.WriteString("</w:num>\n")
+	}
+	b.WriteString("</w:numbering>")
+	return b.String()
+}
+
+const docxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Default Extension="png" ContentType="image/png"/>
+<Default Extension="jpeg" ContentType="image/jpeg"/>
+<Default Extension="gif" ContentType="image/gif"/>
+<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>
+<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>
+</Types>`
+
+const docxRootRelationships = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+</Relationships>`
+
+const docxDocumentStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing">
+<w:body>`
+
+// the section properties describe an A4 page with 1 inch margins
+const docxDocumentEnd = `<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr>
+</w:body>
+</w:document>`
+
+const docxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:cs="Calibri"/><w:sz w:val="22"/></w:rPr></w:rPrDefault>
+<w:pPrDefault><w:pPr><w:spacing w:after="120" w:line="264" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>
+<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>
+<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="360"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:sz w:val="36"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:sz w:val="30"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading3"><w:name w:val="heading 3"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="2"/></w:pPr><w:rPr><w:b/><w:sz w:val="26"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading4"><w:name w:val="heading 4"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="3"/></w:pPr><w:rPr><w:b/><w:i/><w:sz w:val="24"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading5"><w:name w:val="heading 5"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="4"/></w:pPr><w:rPr><w:b/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading6"><w:name w:val="heading 6"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="5"/></w:pPr><w:rPr><w:i/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Quote"><w:name w:val="Quote"/><w:basedOn w:val="Normal"/><w:pPr><w:ind w:left="720"/></w:pPr><w:rPr><w:i/><w:color w:val="555555"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Code"><w:name w:val="Code"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="0" w:line="240" w:lineRule="auto"/><w:shd w:val="clear" w:color="auto" w:fill="F6F8FA"/></w:pPr><w:rPr><w:rFonts w:ascii="Consolas" w:hAnsi="Consolas" w:cs="Consolas"/><w:sz w:val="20"/></w:rPr></w:style>
+<w:style w:type="character" w:styleId="CodeChar"><w:name w:val="Code Char"/><w:rPr><w:rFonts w:ascii="Consolas" w:hAnsi="Consolas" w:cs="Consolas"/><w:shd w:val="clear" w:color="auto" w:fill="F6F8FA"/></w:rPr></w:style>
+<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="0563C1"/><w:u w:val="single"/></w:rPr></w:style>
+<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:tblPr><w:tblBorders><w:top w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:left w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:bottom w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:right w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:insideH w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:insideV w:val="single" w:sz="4" w:space="0" w:color="auto"/></w:tblBorders></w:tblPr></w:style>
+</w:styles>`
@@ -0,0 +1,318 @@
+package export
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+	"github.com/sbrki/snote/internal/storage"
+)
+
+type chapter struct {
+	note     *storage.Note
+	file     string
+	body     string
+	headings []heading
+}
+
+type epubImage struct {
+	file      string
+	mediaType string
+	data      []byte
+}
+
+// EPUB writes an EPUB 3 ebook titled title to w, with one chapter per
+// note. The table of contents lists the chapters and their headings.
+// Links between the notes are turned into links between the chapters
+// and the images shown by the notes are included in the book.
+func EPUB(st storage.Storage, title string, notes []*storage.Note, w io.Writer) error {
+	chapterFiles := make(map[string]string)
+	for i, note := range notes {
+		chapterFiles[note.ID] = fmt.Sprintf("chapter-%d.xhtml", i+1)
+	}
+	rewriteLink := func(href string) string {
+		target, fragment := href, ""
+		if i := strings.Index(href, "#"); i >= 0 {
+			target, fragment = href[:i], href[i:]
+		}
+		if file, found := chapterFiles[strings.TrimPrefix(target, "/")]; found && strings.HasPrefix(target, "/") {
+			return file + fragment
+		}
+		return href
+	}
+
+	chapters := make([]*chapter, 0, len(notes))
+	images := make(map[string]*epubImage)
+	modified := time.Time{}
+	for _, note := range notes {
+		rendered, embedded := note.RenderHTMLEmbedding(st, nil)
+		blobs := loadBlobs(st, note, embedded)
+		rendered = replaceBlobSources(rendered, blobs, func(b *blob) string {
+			if img, found := images[b.id]; found {
+				return img.file
+			}
+			file := "images/" + b.id
+			if extensions, _ := mime.ExtensionsByType(b.mediaType); len(extensions) > 0 {
+				file += extensions[0]
+			}
+			images[b.id] = &epubImage{file, b.mediaType, b.data}
+			return file
+		})
+		body, headings, err := toXHTML(rendered, rewriteLink)
+		if err != nil {
+			return fmt.Errorf("note %s: %v", note.ID, err)
+		}
+		chapters = append(chapters, &chapter{note, chapterFiles[note.ID], body, headings})
+		if note.LastEdit.After(modified) {
+			modified = note.LastEdit
+		}
+	}
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+
+	css, err := stylesheet()
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	// the mimetype file comes first and is not compressed
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	type zipFile struct {
+		name     string
+		contents string
+	}
+	files := []zipFile{
+		{"META-INF/container.xml", containerXML},
+		{"OEBPS/style.css", css},
+		{"OEBPS/nav.xhtml", navXHTML(title, chapters)},
+		{"OEBPS/content.opf", contentOPF(title, notes, chapters, images, modified)},
+	}
+	for _, c := range chapters {
+		files = append(files, zipFile{"OEBPS/" + c.file, xhtmlDocument(chapterTitle(c.note), c.body)})
+	}
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file.contents); err != nil {
+			return err
+		}
+	}
+	for _, blobID := range sortedKeys(images) {
+		f, err := zw.Create("OEBPS/" + images[blobID].file)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(images[blobID].data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+const containerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles>
+		<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+	</rootfiles>
+</container>
+`
+
+func chapterTitle(note *storage.Note) string {
+	if note.Title != "" {
+		return note.Title
+	}
+	return note.ID
+}
+
+func xhtmlDocument(title string, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+<title>` + html.EscapeString(title) + `</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+` + body + `
+</body>
+</html>
+`
+}
+
+func contentOPF(title string, notes []*storage.Note, chapters []*chapter, images map[string]*epubImage, modified time.Time) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	identifier := "snote"
+	for _, note := range notes {
+		identifier += ":" + note.ID
+	}
+	b.WriteString(`<dc:identifier id="book-id">` + html.EscapeString(identifier) + "</dc:identifier>\n")
+	b.WriteString("<dc:title>" + html.EscapeString(title) + "</dc:title>\n")
+	b.WriteString("<dc:language>en</dc:language>\n")
+	b.WriteString(`<meta property="dcterms:modified">` + modified.UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	b.WriteString("</metadata>\n<manifest>\n")
+	b.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	b.WriteString(`<item id="style" href="style.css" media-type="text/css"/>` + "\n")
+	for i, c := range chapters {
+		// reading systems need to know which chapters contain MathML
+		properties := ""
+		if strings.Contains(c.body, "<math") {
+			properties = ` properties="mathml"`
+		}
+		fmt.Fprintf(&b, `<item id="chapter-%d" href="%s" media-type="application/xhtml+xml"%s/>`+"\n", i+1, c.file, properties)
+	}
+	for i, blobID := range sortedKeys(images) {
+		fmt.Fprintf(&b, `<item id="image-%d" href="%s" media-type="%s"/>`+"\n",
+			i+1, images[blobID].file, html.EscapeString(images[blobID].mediaType))
+	}
+	b.WriteString("</manifest>\n<spine>\n")
+	for i := range chapters {
+		fmt.Fprintf(&b, `<itemref idref="chapter-%d"/>`+"\n", i+1)
+	}
+	b.WriteString("</spine>\n</package>\n")
+	return b.String()
+}
+
+// navXHTML builds the table of contents: every chapter, with the headings
+// of the chapter (except its title) nested below it.
+func navXHTML(title string, chapters []*chapter) string {
+	type entry struct {
+		level int
+		href  string
+		text  string
+	}
+	entries := make([]entry, 0)
+	for _, c := range chapters {
+		entries = append(entries, entry{1, c.file, chapterTitle(c.note)})
+		for i, h := range c.headings {
+			if i == 0 && h.text == chapterTitle(c.note) {
+				continue
+			}
+			level := h.level
+			if level < 2 {
+				level = 2
+			}
+			entries = append(entries, entry{level, c.file + "#" + h.id, h.text})
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(`<nav epub:type="toc" id="toc">` + "\n<h1>" + html.EscapeString(title) + "</h1>\n")
+	depth := 0
+	for _, e := range entries {
+		level := e.level
+		if level > depth+1 {
+			level = depth + 1
+		}
+		if level > depth {
+			b.WriteString("<ol>")
+			depth++
+		} else {
+			for depth > level {
+				b.WriteString("</li></ol>")
+				depth--
+			}
+			b.WriteString("</li>")
+		}
+		b.WriteString(`<li><a href="` + html.EscapeString(e.href) + `">` + html.EscapeString(e.text) + "</a>")
+	}
+	for depth > 0 {
+		b.WriteString("</li></ol>")
+		depth--
+	}
+	b.WriteString("\n</nav>")
+	return xhtmlDocument(title, b.String())
+}
+
+func sortedKeys(images map[string]*epubImage) []string {
+	keys := make([]string, 0, len(images))
+	for key := range images {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TagNotes returns the notes for a book about tag: the index note first,
+// then the notes with the tag (or one of its descendant tags) in the order
+// the index note links to them, then the remaining ones, ordered by ID.
+func TagNotes(st storage.Storage, index *storage.Note, tag string) ([]*storage.Note, error) {
+	tagIndex, err := st.GetAllNoteTags()
+	if err != nil {
+		return nil, err
+	}
+	tagged := make(map[string]bool)
+	for _, noteID := range storage.TaggedNoteIDs(tagIndex, tag) {
+		tagged[noteID] = true
+	}
+	delete(tagged, index.ID)
+
+	ordered := make([]string, 0, len(tagged))
+	for _, noteID := range linkedNoteIDs(index) {
+		if tagged[noteID] {
+			ordered = append(ordered, noteID)
+			delete(tagged, noteID)
+		}
+	}
+	rest := make([]string, 0, len(tagged))
+	for noteID := range tagged {
+		rest = append(rest, noteID)
+	}
+	sort.Strings(rest)
+
+	notes := []*storage.Note{index}
+	for _, noteID := range append(ordered, rest...) {
+		if storage.IsAutogenerated(noteID) {
+			continue
+		}
+		note, err := st.LoadNote(noteID)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, nil
+}
+
+// linkedNoteIDs returns the IDs of the notes that note links to, in order.
+func linkedNoteIDs(note *storage.Note) []string {
+	parser := parser.NewWithExtensions(parser.CommonExtensions)
+	rootNode := parser.Parse([]byte(note.Contents))
+
+	noteIDs := make([]string, 0)
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		if link, ok := node.(*ast.Link); ok && entering {
+			destination := strings.TrimSpace(string(link.Destination))
+			if strings.HasPrefix(destination, "/") && !strings.HasPrefix(destination, "/api/") {
+				noteID := strings.TrimPrefix(destination, "/")
+				if i := strings.IndexAny(noteID, "#?"); i >= 0 {
+					noteID = noteID[:i]
+				}
+				noteIDs = append(noteIDs, noteID)
+			}
+		}
+		return ast.GoToNext
+	})
+	return noteIDs
+}
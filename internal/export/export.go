@@ -0,0 +1,99 @@
+// Package export converts notes to documents that can be read without
+// snote: single-file HTML pages and EPUB ebooks. It also exports
+// flashcards to Anki.
+//
+// Notes are rendered with Note.RenderHTMLEmbedding like they are in the
+// browser, and the blobs they show (found with Note.ParseBlobIDs in the
+// note and in the notes embedded in it) are embedded.
+package export
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/web"
+)
+
+// pageStylesheet lays out exported documents, which lack the stylesheets
+// of the web interface.
+const pageStylesheet = `body { font-family: Georgia, serif; line-height: 1.5; max-width: 46em; margin: 0 auto; padding: 1em; color: #222; }
+h1, h2, h3, h4, h5, h6 { font-family: Helvetica, Arial, sans-serif; line-height: 1.2; }
+img { max-width: 100%; }
+pre { background: #f6f8fa; padding: 0.8em; overflow-x: auto; }
+code { font-family: Menlo, Consolas, monospace; font-size: 0.9em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; }
+blockquote { border-left: 4px solid #ddd; margin-left: 0; padding-left: 1em; color: #555; }
+`
+
+// stylesheet returns the stylesheet used by all exported documents: the
+// page layout, the stylesheet of rendered notes of the web interface and
+// the code highlighted with the default theme.
+func stylesheet() (string, error) {
+	css, err := storage.HighlightCSS(storage.DefaultHighlightStyle)
+	if err != nil {
+		return "", err
+	}
+	return pageStylesheet + web.RenderCSS + css, nil
+}
+
+// blob is the content of a blob shown by a note.
+type blob struct {
+	id        string
+	data      []byte
+	mediaType string
+}
+
+// loadBlobs reads the blobs linked by note and by the notes with the IDs
+// embedded. Blobs missing from storage are skipped.
+func loadBlobs(st storage.Storage, note *storage.Note, embedded []string) map[string]*blob {
+	blobIDs := note.ParseBlobIDs()
+	for _, noteID := range embedded {
+		if embeddedNote, err := st.LoadNote(noteID); err == nil {
+			blobIDs = append(blobIDs, embeddedNote.ParseBlobIDs()...)
+		}
+	}
+	blobs := make(map[string]*blob)
+	for _, blobID := range blobIDs {
+		if _, found := blobs[blobID]; found {
+			continue
+		}
+		blobPath, err := st.LoadBlobPath(blobID)
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(blobPath)
+		if err != nil {
+			continue
+		}
+		blobs[blobID] = &blob{id: blobID, data: data, mediaType: http.DetectContentType(data)}
+	}
+	return blobs
+}
+
+// blobSource matches the src attributes of images pointing at blobs.
+var blobSource = regexp.MustCompile(`src="/api/blob/([^/"]+)(/[^"]*)?"`)
+
+// replaceBlobSources replaces the blob URLs in src attributes of html
+// with the result of replace. If the content of a blob does not look
+// like an image (e.g. SVG), its media type is guessed from the file name.
+func replaceBlobSources(html string, blobs map[string]*blob, replace func(b *blob) string) string {
+	return blobSource.ReplaceAllStringFunc(html, func(m string) string {
+		parts := blobSource.FindStringSubmatch(m)
+		b, found := blobs[parts[1]]
+		if !found {
+			return m
+		}
+		if !strings.HasPrefix(b.mediaType, "image/") {
+			if byName := mime.TypeByExtension(path.Ext(parts[2])); byName != "" {
+				b.mediaType = byName
+			}
+		}
+		return `src="` + replace(b) + `"`
+	})
+}
@@ -0,0 +1,204 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/storage/storagetest"
+)
+
+// png is a 1x1 transparent PNG.
+var png, _ = base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII=")
+
+// imageStorage returns a temporary storage holding png as the blob "img".
+func imageStorage(t *testing.T) *storage.DiskStorage {
+	st := storagetest.TempStorage(t)
+	if err := st.SaveBlob("img", *bytes.NewBuffer(png)); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestHTML(t *testing.T) {
+	st := imageStorage(t)
+	note := &storage.Note{ID: "a", Title: "A & B", Contents: "# A & B\n\n![dot](/api/blob/img/dot.png)"}
+
+	b := new(bytes.Buffer)
+	if err := HTML(st, note, b); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	if !strings.Contains(page, `src="data:image/png;base64,`+base64.StdEncoding.EncodeToString(png)+`"`) {
+		t.Error("image not inlined:", page)
+	}
+	if !strings.Contains(page, "<title>A &amp; B</title>") || !strings.Contains(page, "div.embed {") {
+		t.Error("missing title or stylesheet:", page)
+	}
+}
+
+func TestEPUB(t *testing.T) {
+	st := imageStorage(t)
+	index := &storage.Note{ID: "book", Title: "Book", Contents: "# Book\n`tags: b`\n\nRead [two](/two) then [one](/one).<br>"}
+	st.SaveNote(&storage.Note{ID: "one", Title: "One", Contents: "# One\n`tags: b`\n## Part\n![dot](/api/blob/img/dot.png)"})
+	st.SaveNote(&storage.Note{ID: "two", Title: "Two", Contents: "# Two\n`tags: b`\n\nsee [one](/one#part)\n\n$$\\sum_{i=1}^n i$$\n"})
+	st.SaveNote(&storage.Note{ID: "three", Title: "Three", Contents: "# Three\n`tags: b`"})
+	for _, id := range []string{"one", "two", "three"} {
+		st.SetNoteTags(id, []string{"b"})
+	}
+
+	notes, err := TagNotes(st, index, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := make([]string, 0)
+	for _, note := range notes {
+		order = append(order, note.ID)
+	}
+	if strings.Join(order, ",") != "book,two,one,three" {
+		t.Fatal("wrong order:", order)
+	}
+
+	b := new(bytes.Buffer)
+	if err := EPUB(st, "Book", notes, b); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.File[0].Name != "mimetype" || zr.File[0].Method != zip.Store {
+		t.Error("mimetype is not the first, uncompressed file")
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, _ := f.Open()
+		data, _ := ioutil.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(data)
+		// every xml file must be well-formed
+		if strings.HasSuffix(f.Name, ".xhtml") || strings.HasSuffix(f.Name, ".opf") || strings.HasSuffix(f.Name, ".xml") {
+			decoder := xml.NewDecoder(bytes.NewReader(data))
+			for {
+				if _, err := decoder.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Errorf("%s is not well-formed: %v", f.Name, err)
+					break
+				}
+			}
+		}
+	}
+	if _, found := files["OEBPS/images/img.png"]; !found {
+		t.Error("image not included")
+	}
+	if !strings.Contains(files["OEBPS/chapter-3.xhtml"], `src="images/img.png"`) {
+		t.Error("image not linked:", files["OEBPS/chapter-3.xhtml"])
+	}
+	if !strings.Contains(files["OEBPS/chapter-2.xhtml"], `href="chapter-3.xhtml#part"`) {
+		t.Error("link between notes not rewritten:", files["OEBPS/chapter-2.xhtml"])
+	}
+	if !strings.Contains(files["OEBPS/content.opf"], `href="chapter-2.xhtml" media-type="application/xhtml+xml" properties="mathml"/>`) {
+		t.Error("chapter with math not marked:", files["OEBPS/content.opf"])
+	}
+	nav := files["OEBPS/nav.xhtml"]
+	if !strings.Contains(nav, `<li><a href="chapter-3.xhtml">One</a><ol><li><a href="chapter-3.xhtml#`) {
+		t.Error("headings missing from the table of contents:", nav)
+	}
+}
+
+func TestDOCX(t *testing.T) {
+	st := imageStorage(t)
+	note := &storage.Note{ID: "d", Contents: "# Title\n\nSome **bold**, *italic* and [a link](https://example.com).\n\n" +
+		"- one\n- two\n  1. nested\n\n" +
+		"| a | b |\n| --- | --- |\n| 1 | 2 |\n\n" +
+		"```\ncode <here>\n```\n\n![dot](/api/blob/img/dot.png) ![gone](/api/blob/missing/x.png)\n"}
+
+	b := new(bytes.Buffer)
+	if err := DOCX(st, note, b); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, _ := f.Open()
+		data, _ := ioutil.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(data)
+		if strings.HasSuffix(f.Name, ".xml") || strings.HasSuffix(f.Name, ".rels") {
+			decoder := xml.NewDecoder(bytes.NewReader(data))
+			for {
+				if _, err := decoder.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Errorf("%s is not well-formed: %v", f.Name, err)
+					break
+				}
+			}
+		}
+	}
+
+	document := files["word/document.xml"]
+	for _, expected := range []string{
+		`<w:pStyle w:val="Heading1"/>`,
+		`<w:b/></w:rPr><w:t xml:space="preserve">bold</w:t>`,
+		`<w:i/></w:rPr><w:t xml:space="preserve">italic</w:t>`,
+		`<w:hyperlink r:id="rId3">`,
+		`<w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr>`,
+		`<w:numPr><w:ilvl w:val="1"/><w:numId w:val="2"/></w:numPr>`,
+		`<w:tbl>`,
+		`code &lt;here&gt;`,
+		`<a:blip r:embed="rId4"/>`,
+		`gone`,
+	} {
+		if !strings.Contains(document, expected) {
+			t.Errorf("%s not found in document.xml", expected)
+		}
+	}
+	if _, found := files["word/media/image1.png"]; !found {
+		t.Error("image not embedded")
+	}
+	if !strings.Contains(files["word/_rels/document.xml.rels"], `Target="https://example.com" TargetMode="External"`) {
+		t.Error("hyperlink relationship missing")
+	}
+}
+
+func TestAnkiCSV(t *testing.T) {
+	st := imageStorage(t)
+	for id, contents := range map[string]string{
+		"go":    "# Go\n`tags: lang/go`\n\nQ: Who designed Go?\nA: Griesemer, Pike\nand Thompson\n\ngoroutine :: a lightweight thread\n",
+		"other": "# Other\n\nterm :: definition\n",
+	} {
+		if err := storage.UpdateNote(st, &storage.Note{ID: id, Contents: contents}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := new(bytes.Buffer)
+	if err := AnkiCSV(st, "lang", b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "#separator:comma\n#html:false\n#tags column:3\n" +
+		"Who designed Go?,\"Griesemer, Pike\nand Thompson\",lang::go\n" +
+		"goroutine,a lightweight thread,lang::go\n"
+	if b.String() != expected {
+		t.Errorf("wrong csv: %q", b.String())
+	}
+
+	b.Reset()
+	if err := AnkiCSV(st, "", b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(b.String(), "term,definition,\n") {
+		t.Errorf("cards without tag missing: %q", b.String())
+	}
+}
@@ -0,0 +1,34 @@
+package export
+
+import (
+	"encoding/base64"
+	"html"
+	"io"
+
+	"github.com/sbrki/snote/internal/storage"
+)
+
+// HTML writes note to w as a single HTML file. The stylesheet and the
+// images shown by the note are inlined, the latter as data URIs.
+func HTML(st storage.Storage, note *storage.Note, w io.Writer) error {
+	rendered, embedded := note.RenderHTMLEmbedding(st, nil)
+	blobs := loadBlobs(st, note, embedded)
+	body := replaceBlobSources(rendered, blobs, func(b *blob) string {
+		return "data:" + b.mediaType + ";base64," + base64.StdEncoding.EncodeToString(b.data)
+	})
+
+	css, err := stylesheet()
+	if err != nil {
+		return err
+	}
+	title := note.Title
+	if title == "" {
+		title = note.ID
+	}
+	_, err = io.WriteString(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n"+
+		"<title>"+html.EscapeString(title)+"</title>\n"+
+		"<style>\n"+css+"</style>\n</head>\n<body>\n"+
+		body+
+		"</body>\n</html>\n")
+	return err
+}
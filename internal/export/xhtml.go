@@ -0,0 +1,116 @@
+package export
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// heading is a heading of a chapter, it is listed in the table of contents.
+type heading struct {
+	level int
+	id    string
+	text  string
+}
+
+// voidElements are written as empty elements in XHTML.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// xhtmlWriter re-serializes rendered html as XHTML, which EPUB requires.
+// Notes may contain raw html that is not well-formed XML, so the html
+// is parsed and written out again rather than used as is.
+type xhtmlWriter struct {
+	b strings.Builder
+	// rewriteLink is applied to every href attribute.
+	rewriteLink func(href string) string
+	headings    []heading
+}
+
+// toXHTML converts an html fragment to XHTML. Headings without an id get one.
+func toXHTML(fragment string, rewriteLink func(href string) string) (string, []heading, error) {
+	body := &nethtml.Node{Type: nethtml.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := nethtml.ParseFragment(strings.NewReader(fragment), body)
+	if err != nil {
+		return "", nil, err
+	}
+	x := &xhtmlWriter{rewriteLink: rewriteLink}
+	for _, n := range nodes {
+		x.node(n)
+	}
+	return x.b.String(), x.headings, nil
+}
+
+func (x *xhtmlWriter) node(n *nethtml.Node) {
+	switch n.Type {
+	case nethtml.TextNode:
+		x.b.WriteString(html.EscapeString(n.Data))
+		return
+	case nethtml.ElementNode:
+	default:
+		return
+	}
+	// permalinks next to headings are of no use in a book
+	if n.Data == "script" || (n.Data == "a" && attr(n, "class") == "permalink") {
+		return
+	}
+
+	attrs := n.Attr
+	if len(n.Data) == 2 && n.Data[0] == 'h' && n.Data[1] >= '1' && n.Data[1] <= '6' {
+		id := attr(n, "id")
+		if id == "" {
+			id = fmt.Sprintf("heading-%d", len(x.headings)+1)
+			attrs = append(attrs, nethtml.Attribute{Key: "id", Val: id})
+		}
+		x.headings = append(x.headings, heading{int(n.Data[1] - '0'), id, textContent(n)})
+	}
+
+	x.b.WriteString("<" + n.Data)
+	for _, a := range attrs {
+		if a.Namespace != "" || strings.ContainsAny(a.Key, `"'<>/=`) {
+			continue
+		}
+		value := a.Val
+		if a.Key == "href" && x.rewriteLink != nil {
+			value = x.rewriteLink(value)
+		}
+		x.b.WriteString(" " + a.Key + `="` + html.EscapeString(value) + `"`)
+	}
+	if voidElements[n.Data] {
+		x.b.WriteString("/>")
+		return
+	}
+	x.b.WriteString(">")
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		x.node(child)
+	}
+	x.b.WriteString("</" + n.Data + ">")
+}
+
+func attr(n *nethtml.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *nethtml.Node) string {
+	if n.Type == nethtml.TextNode {
+		return n.Data
+	}
+	if n.Data == "a" && attr(n, "class") == "permalink" {
+		return ""
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,357 @@
+// Package fsck checks a storage for inconsistencies between notes,
+// the tag, field, block and card indexes and blobs, and optionally
+// repairs them.
+package fsck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/util"
+)
+
+// Kinds of problems found by Check.
+const (
+	// the note could not be loaded, e.g. because its JSON is corrupt.
+	UnreadableNote = "unreadable_note"
+	// the ID stored inside the note differs from the ID it is stored under.
+	IDMismatch = "id_mismatch"
+	// the tag index lists a note under a tag the note does not have,
+	// or a note that does not exist.
+	StaleTagEntry = "stale_tag_entry"
+	// the note has a tag that the tag index does not list it under.
+	MissingTagEntry = "missing_tag_entry"
+	// like StaleTagEntry and MissingTagEntry, for the front matter fields
+	// of the field index, the blocks of the block index, the
+	// flashcards of the card index and the link targets of the link index.
+	StaleFieldEntry   = "stale_field_entry"
+	MissingFieldEntry = "missing_field_entry"
+	StaleBlockEntry   = "stale_block_entry"
+	MissingBlockEntry = "missing_block_entry"
+	StaleCardEntry    = "stale_card_entry"
+	MissingCardEntry  = "missing_card_entry"
+	StaleLinkEntry    = "stale_link_entry"
+	MissingLinkEntry  = "missing_link_entry"
+	// the SHA-256 checksum of the blob does not match its ID.
+	CorruptBlob = "corrupt_blob"
+	// a note links to a blob that does not exist.
+	MissingBlob = "missing_blob"
+)
+
+type Problem struct {
+	Kind string `json:"kind"`
+	// Subject is the ID of the affected note or blob.
+	Subject string `json:"subject"`
+	Detail  string `json:"detail"`
+	// Repaired is set if the problem was fixed by a repairing check.
+	Repaired bool `json:"repaired"`
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("%s %s: %s", p.Kind, p.Subject, p.Detail)
+	if p.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+type Report struct {
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	Notes    int       `json:"notes"`
+	Blobs    int       `json:"blobs"`
+	Problems []Problem `json:"problems"`
+}
+
+// Unrepaired returns the number of problems that were not repaired.
+func (r *Report) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+type checker struct {
+	storage storage.Storage
+	repair  bool
+	report  *Report
+}
+
+// Check walks st and reports all problems it finds. If repair is set,
+// problems that can be fixed without losing data are repaired: IDs are
+// set to the ID the note is stored under and the indexes are rebuilt
+// for the affected notes. Corrupt notes and blobs are only reported.
+func Check(st storage.Storage, repair bool) (*Report, error) {
+	c := &checker{st, repair, new(Report)}
+	c.report.Started = time.Now()
+	c.report.Problems = make([]Problem, 0)
+
+	noteIDs, err := st.GetAllNoteIDs()
+	if err != nil {
+		return nil, err
+	}
+	blobIDs, err := st.GetAllBlobIDs()
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := loadIndexes(st)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(noteIDs)
+	sort.Strings(blobIDs)
+	c.report.Notes = len(noteIDs)
+	c.report.Blobs = len(blobIDs)
+
+	notes := make(map[string]*storage.Note)
+	for _, noteID := range noteIDs {
+		note, err := c.checkNote(noteID)
+		if err != nil {
+			return nil, err
+		}
+		if note == nil {
+			continue
+		}
+		notes[noteID] = note
+		for _, blobID := range note.ParseBlobIDs() {
+			if !util.SliceContainsString(blobIDs, blobID) {
+				if err := c.add(Problem{Kind: MissingBlob, Subject: noteID, Detail: "links to missing blob " + blobID}, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if err := c.checkIndexes(noteIDs, notes, indexes); err != nil {
+		return nil, err
+	}
+
+	for _, blobID := range blobIDs {
+		if err := c.checkBlob(blobID); err != nil {
+			return nil, err
+		}
+	}
+
+	c.report.Duration = time.Since(c.report.Started).String()
+	return c.report, nil
+}
+
+// add records a problem. If the check is repairing and fix is not nil,
+// fix is called to repair the problem.
+func (c *checker) add(p Problem, fix func() error) error {
+	if c.repair && fix != nil {
+		if err := fix(); err != nil {
+			return err
+		}
+		p.Repaired = true
+	}
+	c.report.Problems = append(c.report.Problems, p)
+	return nil
+}
+
+// checkNote loads a note and checks its ID. Returns nil if the note
+// can not be loaded.
+func (c *checker) checkNote(noteID string) (*storage.Note, error) {
+	note, err := c.storage.LoadNote(noteID)
+	if err != nil {
+		return nil, c.add(Problem{Kind: UnreadableNote, Subject: noteID, Detail: err.Error()}, nil)
+	}
+	if note.ID != noteID {
+		detail := fmt.Sprintf("note is stored as %q but has ID %q", noteID, note.ID)
+		err := c.add(Problem{Kind: IDMismatch, Subject: noteID, Detail: detail}, func() error {
+			note.ID = noteID
+			return c.storage.SaveNote(note)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return note, nil
+}
+
+// index is an index of the storage as the note IDs listed under each of
+// its entries, along with the entries each note should be listed under.
+type index struct {
+	name           string
+	stale, missing string
+	entries        map[string][]string
+	parse          func(note *storage.Note) []string
+}
+
+// loadIndexes returns the tag, field, block, card and link indexes of st.
+// Fields are keyed by "field: value", cards by their IDs and links by
+// their targets.
+func loadIndexes(st storage.Storage) ([]*index, error) {
+	tagIndex, err := st.GetAllNoteTags()
+	if err != nil {
+		return nil, err
+	}
+	fieldIndex, err := st.GetAllNoteFields()
+	if err != nil {
+		return nil, err
+	}
+	blockIndex, err := st.GetAllNoteBlocks()
+	if err != nil {
+		return nil, err
+	}
+	cards, err := st.GetAllCards()
+	if err != nil {
+		return nil, err
+	}
+	linkIndex, err := st.GetAllNoteLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	fieldEntries := make(map[string][]string)
+	for field, values := range fieldIndex {
+		for value, noteIDs := range values {
+			fieldEntries[field+": "+value] = noteIDs
+		}
+	}
+	cardEntries := make(map[string][]string)
+	for cardID, card := range cards {
+		cardEntries[cardID] = []string{card.NoteID}
+	}
+	linkEntries := make(map[string][]string)
+	for noteID, links := range linkIndex {
+		for _, target := range links.Targets {
+			linkEntries[target] = append(linkEntries[target], noteID)
+		}
+	}
+	return []*index{
+		{"tag", StaleTagEntry, MissingTagEntry, tagIndex, (*storage.Note).ParseTags},
+		{"field", StaleFieldEntry, MissingFieldEntry, fieldEntries, func(note *storage.Note) []string {
+			entries := make([]string, 0)
+			for field, values := range note.ParseFields() {
+				for _, value := range values {
+					entries = append(entries, field+": "+value)
+				}
+			}
+			return entries
+		}},
+		{"block", StaleBlockEntry, MissingBlockEntry, blockIndex, (*storage.Note).ParseBlockIDs},
+		{"card", StaleCardEntry, MissingCardEntry, cardEntries, func(note *storage.Note) []string {
+			entries := make([]string, 0)
+			for _, card := range note.IndexedCards() {
+				entries = append(entries, card.ID)
+			}
+			return entries
+		}},
+		{"link", StaleLinkEntry, MissingLinkEntry, linkEntries, func(note *storage.Note) []string {
+			if links := note.ParseNoteLinks(); links != nil {
+				return links.Targets
+			}
+			return []string{}
+		}},
+	}, nil
+}
+
+func (c *checker) checkIndexes(noteIDs []string, notes map[string]*storage.Note, indexes []*index) error {
+	// indexes are set per note, so every note with a problem is reindexed
+	// once after all problems have been recorded.
+	reindex := make([]string, 0)
+	fix := func(noteID string) func() error {
+		return func() error {
+			if !util.SliceContainsString(reindex, noteID) {
+				reindex = append(reindex, noteID)
+			}
+			return nil
+		}
+	}
+
+	for _, idx := range indexes {
+		if err := c.checkIndex(idx, noteIDs, notes, fix); err != nil {
+			return err
+		}
+	}
+
+	for _, noteID := range reindex {
+		var err error
+		if note, loaded := notes[noteID]; loaded {
+			err = storage.IndexNote(c.storage, note)
+		} else {
+			err = storage.UnindexNote(c.storage, noteID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkIndex(idx *index, noteIDs []string, notes map[string]*storage.Note, fix func(string) func() error) error {
+	parsed := make(map[string][]string)
+	for noteID, note := range notes {
+		parsed[noteID] = idx.parse(note)
+	}
+
+	entries := make([]string, 0, len(idx.entries))
+	for entry := range idx.entries {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	for _, entry := range entries {
+		for _, noteID := range idx.entries[entry] {
+			if storage.IsAutogenerated(noteID) {
+				continue
+			}
+			if !util.SliceContainsString(noteIDs, noteID) {
+				detail := fmt.Sprintf("%s %q lists missing note", idx.name, entry)
+				if err := c.add(Problem{Kind: idx.stale, Subject: noteID, Detail: detail}, fix(noteID)); err != nil {
+					return err
+				}
+				continue
+			}
+			parsedEntries, loaded := parsed[noteID]
+			if loaded && !util.SliceContainsString(parsedEntries, entry) {
+				detail := fmt.Sprintf("%s %q lists note without that %s", idx.name, entry, idx.name)
+				if err := c.add(Problem{Kind: idx.stale, Subject: noteID, Detail: detail}, fix(noteID)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, noteID := range noteIDs {
+		for _, entry := range parsed[noteID] {
+			if !util.SliceContainsString(idx.entries[entry], noteID) {
+				detail := fmt.Sprintf("%s %q does not list note", idx.name, entry)
+				if err := c.add(Problem{Kind: idx.missing, Subject: noteID, Detail: detail}, fix(noteID)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkBlob(blobID string) error {
+	path, err := c.storage.LoadBlobPath(blobID)
+	if err != nil {
+		return c.add(Problem{Kind: CorruptBlob, Subject: blobID, Detail: err.Error()}, nil)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return c.add(Problem{Kind: CorruptBlob, Subject: blobID, Detail: err.Error()}, nil)
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return c.add(Problem{Kind: CorruptBlob, Subject: blobID, Detail: err.Error()}, nil)
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	if checksum != blobID {
+		return c.add(Problem{Kind: CorruptBlob, Subject: blobID, Detail: "content has checksum " + checksum}, nil)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package fsck
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/storage/storagetest"
+	"github.com/sbrki/snote/internal/util"
+)
+
+func kinds(report *Report) []string {
+	result := make([]string, 0)
+	for _, p := range report.Problems {
+		result = append(result, p.Kind+":"+p.Subject)
+	}
+	return result
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	st := storage.NewDiskStorage(dir)
+
+	// a healthy note with a tag and a blob
+	blob := []byte("hello")
+	blobID := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	st.SaveBlob(blobID, *bytes.NewBuffer(blob))
+	st.SaveNote(&storage.Note{ID: "ok", Contents: "# ok\n`tags: a`\n![x](/api/blob/" + blobID + "/x.txt)"})
+	st.SetNoteTags("ok", []string{"a"})
+	// a note stored under the wrong ID, not in the tag index, linking to a missing blob
+	st.SaveNote(&storage.Note{ID: "other", Contents: "# renamed\n`tags: b`\n[y](/api/blob/missing/y.txt)"})
+	os.Rename(filepath.Join(dir, "notes", "other.json"), filepath.Join(dir, "notes", "renamed.json"))
+	// a corrupt note and a corrupt blob
+	ioutil.WriteFile(filepath.Join(dir, "notes", "broken.json"), []byte("{not json"), 0600)
+	st.SaveBlob("deadbeef", *bytes.NewBuffer(blob))
+	// a tag index entry for a note that does not exist
+	st.SetNoteTags("gone", []string{"a"})
+
+	expected := []string{
+		UnreadableNote + ":broken",
+		IDMismatch + ":renamed",
+		MissingBlob + ":renamed",
+		StaleTagEntry + ":gone",
+		MissingTagEntry + ":renamed",
+		CorruptBlob + ":deadbeef",
+	}
+	report, err := Check(st, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := kinds(report)
+	for _, e := range expected {
+		if !util.SliceContainsString(found, e) {
+			t.Errorf("expected problem %s, got %v", e, found)
+		}
+	}
+	if len(found) != len(expected) {
+		t.Errorf("expected %d problems, got %v", len(expected), found)
+	}
+
+	// repair and check again: only unrepairable problems remain
+	report, err = Check(st, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unrepaired() != 3 {
+		t.Errorf("expected 3 unrepaired problems, got %v", kinds(report))
+	}
+	report, err = Check(st, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found = kinds(report)
+	if len(found) != 3 {
+		t.Errorf("repair left problems behind: %v", found)
+	}
+	note, err := st.LoadNote("renamed")
+	if err != nil || note.ID != "renamed" {
+		t.Error("note ID was not repaired")
+	}
+}
+
+func TestCheckIndexes(t *testing.T) {
+	st := storagetest.TempStorage(t)
+
+	// a note with a field, a block, a card and a link that none of the indexes list
+	st.SaveNote(&storage.Note{ID: "plan", Contents: "---\nstatus: draft\n---\n# Plan\n\nWater the roses ^water\n\nroses :: red\n\nSee [[garden]]\n"})
+	// index entries of a note that does not exist
+	st.SetNoteFields("gone", map[string][]string{"status": {"done"}})
+	st.SetNoteBlocks("gone", []string{"seeds"})
+	st.SetNoteCards("gone", (&storage.Note{ID: "gone", Contents: "tulips :: yellow\n"}).ParseCards())
+	st.SetNoteLinks("gone", &storage.NoteLinks{Title: "Gone", Targets: []string{"plan"}})
+
+	expected := []string{
+		StaleFieldEntry + ":gone",
+		MissingFieldEntry + ":plan",
+		StaleBlockEntry + ":gone",
+		MissingBlockEntry + ":plan",
+		StaleCardEntry + ":gone",
+		MissingCardEntry + ":plan",
+		StaleLinkEntry + ":gone",
+		MissingLinkEntry + ":plan",
+	}
+	report, err := Check(st, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := kinds(report)
+	for _, e := range expected {
+		if !util.SliceContainsString(found, e) {
+			t.Errorf("expected problem %s, got %v", e, found)
+		}
+	}
+	if len(found) != len(expected) || report.Unrepaired() != 0 {
+		t.Errorf("expected %d repaired problems, got %v", len(expected), report.Problems)
+	}
+
+	report, err = Check(st, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := kinds(report); len(found) != 0 {
+		t.Errorf("repair left problems behind: %v", found)
+	}
+	if cards, err := st.GetAllCards(); err != nil || len(cards) != 1 {
+		t.Error("wrong cards after repair:", cards, err)
+	}
+}
@@ -0,0 +1,654 @@
+// Package mathml converts LaTeX formulas to MathML, which browsers and
+// ebook readers show without any JavaScript.
+//
+// It covers the LaTeX used in everyday notes: sub- and superscripts,
+// fractions, roots, Greek letters and symbols, functions, accents, font
+// commands, \left/\right delimiters and the matrix, cases, array,
+// aligned and gathered environments.
+package mathml
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Convert returns the <math> element of the LaTeX formula tex. Display
+// formulas are blocks and the scripts of operators like \sum go above
+// and below them. The formula is kept as an annotation.
+func Convert(tex string, display bool) (string, error) {
+	p := &parser{src: tex, display: display}
+	rows, err := p.parseRows()
+	if err != nil {
+		return "", err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return "", p.errorf(t, "unexpected %s", t)
+	}
+
+	body := ""
+	if len(rows) == 1 && len(rows[0]) == 1 {
+		body = rows[0][0]
+	} else {
+		// lines separated by \\ outside of an environment are centered
+		body = table(rows, "center", "")
+	}
+
+	mode := "inline"
+	if display {
+		mode = "block"
+	}
+	return `<math xmlns="http://www.w3.org/1998/Math/MathML" display="` + mode + `"><semantics>` + body +
+		`<annotation encoding="application/x-tex">` + html.EscapeString(strings.TrimSpace(tex)) + "</annotation></semantics></math>", nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	// tokChar is a single character, including the special ones ({}^_&~).
+	tokChar
+	// tokCommand is a control sequence, its text is the name without the backslash.
+	tokCommand
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// pos and end delimit the token in the source.
+	pos, end int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of formula"
+	case tokCommand:
+		return `\` + t.text
+	}
+	return `"` + t.text + `"`
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+type parser struct {
+	src     string
+	pos     int
+	display bool
+}
+
+func (p *parser) errorf(t token, format string, a ...interface{}) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, a...), t.pos+1)
+}
+
+// peek returns the next token, skipping whitespace, without consuming it.
+func (p *parser) peek() token {
+	pos := p.pos
+	for pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[pos]) >= 0 {
+		pos++
+	}
+	if pos >= len(p.src) {
+		return token{kind: tokEOF, pos: pos, end: pos}
+	}
+	if p.src[pos] != '\\' {
+		_, size := utf8.DecodeRuneInString(p.src[pos:])
+		return token{kind: tokChar, text: p.src[pos : pos+size], pos: pos, end: pos + size}
+	}
+	end := pos + 1
+	for end < len(p.src) && isLetter(p.src[end]) {
+		end++
+	}
+	if end == pos+1 && end < len(p.src) {
+		// a single non-letter character: \, \{ \\ ...
+		_, size := utf8.DecodeRuneInString(p.src[end:])
+		end += size
+	}
+	return token{kind: tokCommand, text: p.src[pos+1 : end], pos: pos, end: end}
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos = t.end
+	return t
+}
+
+func (p *parser) expect(text string) error {
+	t := p.next()
+	if !t.is(tokChar, text) {
+		return p.errorf(t, "expected %q, found %s", text, t)
+	}
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// ends reports whether t ends the current row: the end of a group,
+// cell, line or environment.
+func ends(t token) bool {
+	switch t.kind {
+	case tokEOF:
+		return true
+	case tokChar:
+		return t.text == "}" || t.text == "&"
+	}
+	return t.text == `\` || t.text == "end" || t.text == "right" || t.text == "middle"
+}
+
+// parseRows parses lines separated by \\ made of cells separated by &.
+func (p *parser) parseRows() ([][]string, error) {
+	rows := make([][]string, 0)
+	row := make([]string, 0)
+	for {
+		cell, err := p.parseRow()
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, cell)
+		t := p.peek()
+		switch {
+		case t.is(tokChar, "&"):
+			p.next()
+		case t.is(tokCommand, `\`):
+			p.next()
+			p.skipOptional()
+			rows = append(rows, row)
+			row = make([]string, 0)
+		default:
+			return append(rows, row), nil
+		}
+	}
+}
+
+// skipOptional skips an optional [...] argument, like the spacing of \\[2pt].
+func (p *parser) skipOptional() {
+	if t := p.peek(); t.is(tokChar, "[") {
+		if end := strings.IndexByte(p.src[t.pos:], ']'); end >= 0 {
+			p.pos = t.pos + end + 1
+		}
+	}
+}
+
+// parseRow parses atoms with their scripts until the end of the row.
+func (p *parser) parseRow() (string, error) {
+	items := make([]string, 0)
+	for !ends(p.peek()) {
+		item, err := p.parseScripted()
+		if err != nil {
+			return "", err
+		}
+		items = append(items, item)
+	}
+	return mrow(items), nil
+}
+
+// node is a parsed atom.
+type node struct {
+	xml string
+	// limits puts the scripts above and below in display mode.
+	limits bool
+	// after is written after the scripts, e.g. the function application of sin.
+	after string
+}
+
+// parseScripted parses an atom and the sub- and superscripts following it.
+func (p *parser) parseScripted() (string, error) {
+	var base node
+	if t := p.peek(); t.is(tokChar, "^") || t.is(tokChar, "_") {
+		base = node{xml: "<mrow></mrow>"}
+	} else {
+		var err error
+		if base, err = p.parseAtom(false); err != nil {
+			return "", err
+		}
+	}
+
+	var sub, sup *string
+	primes := ""
+	for {
+		t := p.peek()
+		if t.is(tokChar, "'") {
+			p.next()
+			primes += "′"
+			continue
+		}
+		if !t.is(tokChar, "^") && !t.is(tokChar, "_") {
+			break
+		}
+		p.next()
+		arg, err := p.parseAtom(true)
+		if err != nil {
+			return "", err
+		}
+		if t.text == "^" {
+			if sup != nil {
+				return "", p.errorf(t, "double superscript")
+			}
+			sup = &arg.xml
+		} else {
+			if sub != nil {
+				return "", p.errorf(t, "double subscript")
+			}
+			sub = &arg.xml
+		}
+	}
+	if primes != "" {
+		prime := "<mo>" + primes + "</mo>"
+		if sup != nil {
+			prime = mrow([]string{prime, *sup})
+		}
+		sup = &prime
+	}
+
+	xml := base.xml
+	under, over, both := "msub", "msup", "msubsup"
+	if base.limits && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sub != nil && sup != nil:
+		xml = "<" + both + ">" + base.xml + *sub + *sup + "</" + both + ">"
+	case sub != nil:
+		xml = "<" + under + ">" + base.xml + *sub + "</" + under + ">"
+	case sup != nil:
+		xml = "<" + over + ">" + base.xml + *sup + "</" + over + ">"
+	}
+	if base.after != "" {
+		return "<mrow>" + xml + base.after + "</mrow>", nil
+	}
+	return xml, nil
+}
+
+// parseAtom parses a single character, group or command. Script
+// arguments take a single digit, like in LaTeX (x^12 is x² followed by 2).
+func (p *parser) parseAtom(script bool) (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokEOF:
+		return node{}, p.errorf(t, "missing argument")
+	case tokCommand:
+		return p.parseCommand(t)
+	}
+
+	switch t.text {
+	case "{":
+		row, err := p.parseRow()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: row}, p.expect("}")
+	case "}", "&", "^", "_":
+		return node{}, p.errorf(t, "unexpected %s", t)
+	case "~":
+		return node{xml: `<mspace width="0.25em"></mspace>`}, nil
+	}
+
+	r, _ := utf8.DecodeRuneInString(t.text)
+	switch {
+	case unicode.IsDigit(r):
+		number := t.text
+		if !script {
+			for {
+				c := p.src[p.pos:]
+				if len(c) > 0 && c[0] >= '0' && c[0] <= '9' {
+					number += c[:1]
+				} else if len(c) > 1 && c[0] == '.' && c[1] >= '0' && c[1] <= '9' {
+					number += c[:2]
+					p.pos++
+				} else {
+					break
+				}
+				p.pos++
+			}
+		}
+		return node{xml: "<mn>" + number + "</mn>"}, nil
+	case unicode.IsLetter(r):
+		return node{xml: "<mi>" + html.EscapeString(t.text) + "</mi>"}, nil
+	case t.text == "-":
+		return node{xml: "<mo>−</mo>"}, nil
+	}
+	return node{xml: mo(t.text)}, nil
+}
+
+// parseArgument parses a mandatory argument of a command.
+func (p *parser) parseArgument() (string, error) {
+	arg, err := p.parseAtom(true)
+	return arg.xml, err
+}
+
+// parseText returns the raw text of a braced argument, as used by \text.
+func (p *parser) parseText() (string, error) {
+	if err := p.expect("{"); err != nil {
+		return "", err
+	}
+	start, depth := p.pos, 0
+	for i := p.pos; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				p.pos = i + 1
+				return p.src[start:i], nil
+			}
+			depth--
+		}
+	}
+	return "", p.errorf(token{pos: start - 1}, "unclosed {")
+}
+
+func (p *parser) parseCommand(t token) (node, error) {
+	name := t.text
+	if char, found := greek[name]; found {
+		if unicode.IsUpper([]rune(char)[0]) {
+			return node{xml: `<mi mathvariant="normal">` + char + "</mi>"}, nil
+		}
+		return node{xml: "<mi>" + char + "</mi>"}, nil
+	}
+	if char, found := identifiers[name]; found {
+		return node{xml: "<mi>" + char + "</mi>"}, nil
+	}
+	if char, found := operators[name]; found {
+		return node{xml: mo(char)}, nil
+	}
+	if op, found := bigOperators[name]; found {
+		attrs := ""
+		if op.limits {
+			attrs = ` movablelimits="true"`
+		}
+		return node{xml: "<mo" + attrs + ">" + op.char + "</mo>", limits: op.limits}, nil
+	}
+	if limits, found := functions[name]; found {
+		return node{xml: "<mi>" + name + "</mi>", limits: limits, after: "<mo>\u2061</mo>"}, nil
+	}
+	if width, found := spaces[name]; found {
+		return node{xml: `<mspace width="` + width + `"></mspace>`}, nil
+	}
+	if a, found := accents[name]; found {
+		arg, err := p.parseArgument()
+		if err != nil {
+			return node{}, err
+		}
+		stretchy := "false"
+		if a.stretchy {
+			stretchy = "true"
+		}
+		accent := `<mo stretchy="` + stretchy + `">` + html.EscapeString(a.char) + "</mo>"
+		if a.under {
+			return node{xml: `<munder accentunder="true">` + arg + accent + "</munder>"}, nil
+		}
+		return node{xml: `<mover accent="true">` + arg + accent + "</mover>"}, nil
+	}
+	if variant, found := fonts[name]; found {
+		arg, err := p.parseArgument()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: `<mstyle mathvariant="` + variant + `">` + arg + "</mstyle>"}, nil
+	}
+	if ignored[name] {
+		return node{xml: "<mrow></mrow>"}, nil
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac", "binom", "dbinom", "tbinom":
+		numerator, err := p.parseArgument()
+		if err != nil {
+			return node{}, err
+		}
+		denominator, err := p.parseArgument()
+		if err != nil {
+			return node{}, err
+		}
+		if strings.HasSuffix(name, "binom") {
+			return node{xml: `<mrow><mo>(</mo><mfrac linethickness="0">` + numerator + denominator + "</mfrac><mo>)</mo></mrow>"}, nil
+		}
+		return node{xml: "<mfrac>" + numerator + denominator + "</mfrac>"}, nil
+	case "sqrt":
+		index := ""
+		if t := p.peek(); t.is(tokChar, "[") {
+			p.next()
+			items := make([]string, 0)
+			for t := p.peek(); !t.is(tokChar, "]"); t = p.peek() {
+				if ends(t) {
+					return node{}, p.errorf(t, "unclosed [")
+				}
+				item, err := p.parseScripted()
+				if err != nil {
+					return node{}, err
+				}
+				items = append(items, item)
+			}
+			p.next()
+			index = mrow(items)
+		}
+		arg, err := p.parseArgument()
+		if err != nil {
+			return node{}, err
+		}
+		if index != "" {
+			return node{xml: "<mroot>" + arg + index + "</mroot>"}, nil
+		}
+		return node{xml: "<msqrt>" + arg + "</msqrt>"}, nil
+	case "text", "textrm", "textit", "textbf", "mbox":
+		text, err := p.parseText()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: "<mtext>" + textContent(text) + "</mtext>"}, nil
+	case "operatorname":
+		text, err := p.parseText()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: "<mi>" + html.EscapeString(strings.TrimSpace(text)) + "</mi>", after: "<mo>\u2061</mo>"}, nil
+	case "bmod", "mod":
+		return node{xml: `<mo lspace="0.2222em" rspace="0.2222em">mod</mo>`}, nil
+	case "pmod":
+		arg, err := p.parseArgument()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: `<mrow><mspace width="0.4444em"></mspace><mo>(</mo><mi>mod</mi><mspace width="0.3333em"></mspace>` + arg + "<mo>)</mo></mrow>"}, nil
+	case "not":
+		arg, err := p.parseAtom(true)
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: `<mrow><menclose notation="updiagonalstrike">` + arg.xml + "</menclose></mrow>"}, nil
+	case "left":
+		return p.parseLeftRight(t)
+	case "begin":
+		return p.parseEnvironment(t)
+	}
+	return node{}, p.errorf(t, `unknown command \%s`, name)
+}
+
+// parseDelimiter parses the delimiter after \left, \middle or \right.
+// The empty delimiter "." is returned as "".
+func (p *parser) parseDelimiter() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokChar:
+		if t.text == "." {
+			return "", nil
+		}
+		if strings.Contains("()[]|/<>", t.text) {
+			return strings.NewReplacer("<", "⟨", ">", "⟩").Replace(t.text), nil
+		}
+	case tokCommand:
+		if char, found := operators[t.text]; found {
+			return char, nil
+		}
+	}
+	return "", p.errorf(t, "missing delimiter, found %s", t)
+}
+
+func fence(char string) string {
+	if char == "" {
+		return ""
+	}
+	return `<mo fence="true" stretchy="true">` + html.EscapeString(char) + "</mo>"
+}
+
+// parseLeftRight parses \left( ... \middle| ... \right).
+func (p *parser) parseLeftRight(left token) (node, error) {
+	open, err := p.parseDelimiter()
+	if err != nil {
+		return node{}, err
+	}
+	items := []string{fence(open)}
+	for {
+		row, err := p.parseRow()
+		if err != nil {
+			return node{}, err
+		}
+		items = append(items, row)
+		t := p.next()
+		switch {
+		case t.is(tokCommand, "middle"):
+			middle, err := p.parseDelimiter()
+			if err != nil {
+				return node{}, err
+			}
+			items = append(items, fence(middle))
+		case t.is(tokCommand, "right"):
+			close, err := p.parseDelimiter()
+			if err != nil {
+				return node{}, err
+			}
+			items = append(items, fence(close))
+			return node{xml: "<mrow>" + strings.Join(items, "") + "</mrow>"}, nil
+		default:
+			return node{}, p.errorf(left, `\left without \right`)
+		}
+	}
+}
+
+// parseEnvironment parses \begin{name} ... \end{name}.
+func (p *parser) parseEnvironment(begin token) (node, error) {
+	name, err := p.parseText()
+	if err != nil {
+		return node{}, err
+	}
+	name = strings.TrimSpace(name)
+	columnAlign := ""
+	switch strings.TrimSuffix(name, "*") {
+	case "matrix", "smallmatrix", "pmatrix", "bmatrix", "Bmatrix", "vmatrix", "Vmatrix", "gathered", "gather", "equation":
+	case "cases":
+		columnAlign = "left left"
+	case "aligned", "align", "split", "alignat", "alignedat":
+		columnAlign = "right left right left right left"
+		if strings.HasPrefix(name, "alignat") || strings.HasPrefix(name, "alignedat") {
+			// the number of columns
+			if _, err := p.parseText(); err != nil {
+				return node{}, err
+			}
+		}
+	case "array":
+		spec, err := p.parseText()
+		if err != nil {
+			return node{}, err
+		}
+		aligns := make([]string, 0)
+		for _, c := range spec {
+			switch c {
+			case 'l':
+				aligns = append(aligns, "left")
+			case 'c':
+				aligns = append(aligns, "center")
+			case 'r':
+				aligns = append(aligns, "right")
+			}
+		}
+		columnAlign = strings.Join(aligns, " ")
+	default:
+		return node{}, p.errorf(begin, "unknown environment %s", name)
+	}
+
+	rows, err := p.parseRows()
+	if err != nil {
+		return node{}, err
+	}
+	end := p.next()
+	if !end.is(tokCommand, "end") {
+		return node{}, p.errorf(begin, "missing \\end{%s}", name)
+	}
+	endName, err := p.parseText()
+	if err != nil {
+		return node{}, err
+	}
+	if strings.TrimSpace(endName) != name {
+		return node{}, p.errorf(end, "\\begin{%s} ended by \\end{%s}", name, endName)
+	}
+	// a trailing \\ leaves an empty last row
+	if last := rows[len(rows)-1]; len(rows) > 1 && len(last) == 1 && last[0] == "<mrow></mrow>" {
+		rows = rows[:len(rows)-1]
+	}
+
+	base := strings.TrimSuffix(name, "*")
+	if base == "equation" {
+		return node{xml: mrow(rows[0])}, nil
+	}
+	attrs := ""
+	if base == "aligned" || base == "align" || base == "split" || base == "alignat" || base == "alignedat" {
+		attrs = ` displaystyle="true" columnspacing="0em 2em 0em 2em 0em"`
+	}
+	xml := table(rows, columnAlign, attrs)
+	if base == "cases" {
+		xml = "<mrow>" + fence("{") + xml + "</mrow>"
+	}
+	if d, found := delimiters[base]; found && d[0] != "" {
+		xml = "<mrow>" + fence(d[0]) + xml + fence(d[1]) + "</mrow>"
+	}
+	return node{xml: xml}, nil
+}
+
+func table(rows [][]string, columnAlign string, attrs string) string {
+	var b strings.Builder
+	b.WriteString("<mtable")
+	if columnAlign != "" {
+		b.WriteString(` columnalign="` + columnAlign + `"`)
+	}
+	b.WriteString(attrs + ">")
+	for _, row := range rows {
+		b.WriteString("<mtr>")
+		for _, cell := range row {
+			b.WriteString("<mtd>" + cell + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+	return b.String()
+}
+
+func mrow(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "<mrow>" + strings.Join(items, "") + "</mrow>"
+}
+
+func mo(char string) string {
+	return "<mo>" + html.EscapeString(char) + "</mo>"
+}
+
+// textContent escapes text for <mtext>, keeping the spaces at its ends,
+// which MathML would otherwise drop.
+func textContent(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return strings.Repeat(" ", len(text))
+	}
+	start := strings.Index(text, trimmed)
+	leading := strings.Repeat(" ", start)
+	trailing := strings.Repeat(" ", len(text)-start-len(trimmed))
+	return leading + html.EscapeString(trimmed) + trailing
+}
@@ -0,0 +1,78 @@
+package mathml
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		tex      string
+		display  bool
+		expected []string
+	}{
+		{`x^2 + y_1`, false, []string{
+			"<msup><mi>x</mi><mn>2</mn></msup><mo>+</mo><msub><mi>y</mi><mn>1</mn></msub>",
+		}},
+		{`\frac{a}{b} - 3.14`, false, []string{"<mfrac><mi>a</mi><mi>b</mi></mfrac><mo>−</mo><mn>3.14</mn>"}},
+		{`\frac12`, false, []string{"<mfrac><mn>1</mn><mn>2</mn></mfrac>"}},
+		{`x^12`, false, []string{"<msup><mi>x</mi><mn>1</mn></msup><mn>2</mn>"}},
+		{`\alpha + \Omega`, false, []string{"<mi>α</mi>", `<mi mathvariant="normal">Ω</mi>`}},
+		{`\sum_{i=1}^n i`, true, []string{`<munderover><mo movablelimits="true">∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover>`}},
+		{`\sum_{i=1}^n i`, false, []string{`<msubsup><mo movablelimits="true">∑</mo>`}},
+		{`\int_0^1 f(x)\,dx`, true, []string{"<msubsup><mo>∫</mo><mn>0</mn><mn>1</mn></msubsup>", `<mspace width="0.1667em"></mspace>`}},
+		{`\sin^2 x`, false, []string{"<mrow><msup><mi>sin</mi><mn>2</mn></msup><mo>⁡</mo></mrow><mi>x</mi>"}},
+		{`\lim_{x \to 0}`, true, []string{"<munder><mi>lim</mi><mrow><mi>x</mi><mo>→</mo><mn>0</mn></mrow></munder>"}},
+		{`\sqrt[3]{x}`, false, []string{"<mroot><mi>x</mi><mn>3</mn></mroot>"}},
+		{`f'(x)`, false, []string{"<msup><mi>f</mi><mo>′</mo></msup>"}},
+		{`\vec{v} \cdot \hat n`, false, []string{`<mover accent="true"><mi>v</mi><mo stretchy="false">→</mo></mover>`, "<mo>⋅</mo>"}},
+		{`\text{if } x < 0`, false, []string{"<mtext>if </mtext>", "<mo>&lt;</mo>"}},
+		{`\mathbb{R}`, false, []string{`<mstyle mathvariant="double-struck"><mi>R</mi></mstyle>`}},
+		{`\left( \frac{1}{2} \right]`, false, []string{`<mrow><mo fence="true" stretchy="true">(</mo><mfrac>`, `<mo fence="true" stretchy="true">]</mo></mrow>`}},
+		{`\begin{pmatrix} a & b \\ c & d \end{pmatrix}`, true, []string{
+			`<mo fence="true" stretchy="true">(</mo><mtable><mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr><mtr><mtd><mi>c</mi></mtd><mtd><mi>d</mi></mtd></mtr></mtable>`,
+		}},
+		{`|x| = \begin{cases} x & x \ge 0 \\ -x & \text{otherwise} \end{cases}`, true, []string{
+			`<mo fence="true" stretchy="true">{</mo><mtable columnalign="left left">`,
+		}},
+		{"\\begin{aligned} a &= b + c \\\\\n &= d \\\\ \\end{aligned}", true, []string{
+			`<mtable columnalign="right left right left right left" displaystyle="true"`,
+			"<mtr><mtd><mi>a</mi></mtd><mtd><mrow><mo>=</mo><mi>b</mi><mo>+</mo><mi>c</mi></mrow></mtd></mtr><mtr><mtd><mrow></mrow></mtd>",
+		}},
+		{`a \\ b`, true, []string{`<mtable columnalign="center"><mtr><mtd><mi>a</mi></mtd></mtr><mtr><mtd><mi>b</mi></mtd></mtr></mtable>`}},
+	}
+	for _, c := range cases {
+		mathML, err := Convert(c.tex, c.display)
+		if err != nil {
+			t.Errorf("%s: %v", c.tex, err)
+			continue
+		}
+		for _, expected := range c.expected {
+			if !strings.Contains(mathML, expected) {
+				t.Errorf("%s: %s not found in:\n%s", c.tex, expected, mathML)
+			}
+		}
+		if err := xml.Unmarshal([]byte(mathML), new(interface{})); err != nil {
+			t.Errorf("%s: not well-formed: %v", c.tex, err)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	cases := map[string]string{
+		`\frac{a}`:                     "missing argument at position 9",
+		`x^2^3`:                        "double superscript at position 4",
+		`{x`:                           `expected "}", found end of formula at position 3`,
+		`x}`:                           `unexpected "}" at position 2`,
+		`\foo`:                         `unknown command \foo at position 1`,
+		`\left( x`:                     `\left without \right at position 1`,
+		`\begin{matrix} a \end{cases}`: `\begin{matrix} ended by \end{cases} at position 18`,
+		`\begin{tabular}`:              "unknown environment tabular at position 1",
+	}
+	for tex, expected := range cases {
+		if _, err := Convert(tex, false); err == nil || err.Error() != expected {
+			t.Errorf("%s: expected %q, got %v", tex, expected, err)
+		}
+	}
+}
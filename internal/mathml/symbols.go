@@ -0,0 +1,118 @@
+package mathml
+
+// greek maps the commands of Greek letters to their characters.
+// Uppercase letters are set upright, lowercase ones in italics.
+var greek = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "omicron": "ο", "pi": "π", "varpi": "ϖ",
+	"rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ",
+	"phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+}
+
+// identifiers are symbols that are set like variables.
+var identifiers = map[string]string{
+	"infty": "∞", "partial": "∂", "nabla": "∇", "emptyset": "∅", "varnothing": "∅",
+	"hbar": "ℏ", "ell": "ℓ", "Re": "ℜ", "Im": "ℑ", "aleph": "ℵ", "wp": "℘",
+	"top": "⊤", "bot": "⊥", "angle": "∠", "triangle": "△", "prime": "′",
+}
+
+// operators are symbols that are set as operators: relations, binary
+// operators, arrows, dots and delimiters.
+var operators = map[string]string{
+	// binary operators
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗", "star": "⋆",
+	"circ": "∘", "bullet": "∙", "oplus": "⊕", "ominus": "⊖", "otimes": "⊗", "odot": "⊙",
+	"cup": "∪", "cap": "∩", "setminus": "∖", "wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨",
+	"neg": "¬", "lnot": "¬",
+	// relations
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "ll": "≪", "gg": "≫",
+	"approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃",
+	"supseteq": "⊇", "perp": "⊥", "parallel": "∥", "mid": "∣", "forall": "∀", "exists": "∃",
+	"nexists": "∄", "vdash": "⊢", "models": "⊨", "coloneqq": "≔",
+	// arrows
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "impliedby": "⟸",
+	"iff": "⟺", "mapsto": "↦", "uparrow": "↑", "downarrow": "↓", "longrightarrow": "⟶",
+	"longleftarrow": "⟵", "longmapsto": "⟼", "hookrightarrow": "↪",
+	// dots
+	"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	// delimiters
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+	"lvert": "|", "rvert": "|", "vert": "|", "lVert": "‖", "rVert": "‖", "Vert": "‖",
+	"|": "‖", "{": "{", "}": "}", "lbrace": "{", "rbrace": "}",
+	// escaped characters
+	"%": "%", "$": "$", "#": "#", "&": "&", "_": "_",
+}
+
+// bigOperator is an operator like ∑ whose scripts may go above and below it.
+type bigOperator struct {
+	char   string
+	limits bool
+}
+
+var bigOperators = map[string]bigOperator{
+	"sum": {"∑", true}, "prod": {"∏", true}, "coprod": {"∐", true},
+	"bigcup": {"⋃", true}, "bigcap": {"⋂", true}, "bigoplus": {"⨁", true}, "bigotimes": {"⨂", true},
+	"bigvee": {"⋁", true}, "bigwedge": {"⋀", true},
+	"int": {"∫", false}, "iint": {"∬", false}, "iiint": {"∭", false}, "oint": {"∮", false},
+}
+
+// functions are set upright, those with limits put their scripts
+// below them in display mode (\lim_{x \to 0}).
+var functions = map[string]bool{
+	"sin": false, "cos": false, "tan": false, "cot": false, "sec": false, "csc": false,
+	"arcsin": false, "arccos": false, "arctan": false, "sinh": false, "cosh": false, "tanh": false,
+	"coth": false, "log": false, "ln": false, "lg": false, "exp": false, "deg": false, "dim": false,
+	"arg": false, "ker": false, "hom": false,
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true, "sup": true, "inf": true,
+	"det": true, "gcd": true, "Pr": true, "argmax": true, "argmin": true,
+}
+
+// spaces are the widths of the spacing commands.
+var spaces = map[string]string{
+	",": "0.1667em", "thinspace": "0.1667em", ":": "0.2222em", ">": "0.2222em", "medspace": "0.2222em",
+	";": "0.2778em", "thickspace": "0.2778em", " ": "0.25em", "quad": "1em", "qquad": "2em",
+	"!": "-0.1667em", "negthinspace": "-0.1667em",
+}
+
+// accents are placed above (or below) their argument.
+type accent struct {
+	char  string
+	under bool
+	// stretchy accents cover the whole argument
+	stretchy bool
+}
+
+var accents = map[string]accent{
+	"hat": {"^", false, false}, "widehat": {"^", false, true}, "check": {"ˇ", false, false},
+	"tilde": {"~", false, false}, "widetilde": {"~", false, true}, "bar": {"¯", false, false},
+	"overline": {"¯", false, true}, "vec": {"→", false, false}, "overrightarrow": {"→", false, true},
+	"overleftarrow": {"←", false, true}, "dot": {"˙", false, false}, "ddot": {"¨", false, false},
+	"acute": {"´", false, false}, "grave": {"`", false, false}, "breve": {"˘", false, false},
+	"overbrace": {"⏞", false, true}, "underline": {"_", true, true}, "underbrace": {"⏟", true, true},
+}
+
+// fonts are the mathvariant values of the font commands.
+var fonts = map[string]string{
+	"mathrm": "normal", "mathbf": "bold", "mathit": "italic", "mathbb": "double-struck",
+	"mathcal": "script", "mathscr": "script", "mathfrak": "fraktur", "mathsf": "sans-serif",
+	"mathtt": "monospace", "boldsymbol": "bold-italic", "bm": "bold-italic",
+}
+
+// delimiters are the fences of the matrix environments.
+var delimiters = map[string][2]string{
+	"matrix": {"", ""}, "smallmatrix": {"", ""}, "pmatrix": {"(", ")"}, "bmatrix": {"[", "]"},
+	"Bmatrix": {"{", "}"}, "vmatrix": {"|", "|"}, "Vmatrix": {"‖", "‖"},
+}
+
+// ignored commands only change the spacing or style in ways that do not
+// matter for MathML.
+var ignored = map[string]bool{
+	"displaystyle": true, "textstyle": true, "limits": true, "nolimits": true,
+	"nonumber": true, "notag": true, "big": true, "Big": true, "bigg": true, "Bigg": true,
+	"bigl": true, "bigr": true, "Bigl": true, "Bigr": true,
+}
@@ -0,0 +1,327 @@
+// Package migrate copies the contents of one storage into another.
+//
+// Only the storage.Storage interface is used, so any pair of backends
+// is supported. A migration can be resumed after an interruption by
+// simply running it again: notes and blobs that were already copied
+// are detected and skipped.
+package migrate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/sbrki/snote/internal/storage"
+)
+
+type Result struct {
+	Notes        int `json:"notes"`
+	NotesSkipped int `json:"notes_skipped"`
+	Blobs        int `json:"blobs"`
+	BlobsSkipped int `json:"blobs_skipped"`
+	Tags         int `json:"tags"`
+}
+
+// Migrate copies all notes (keeping their last edit time), blobs, tags
+// and flashcards (keeping their review state) from src to dst. progress,
+// if not nil, is called with a short message for every copied item.
+func Migrate(src storage.Storage, dst storage.Storage, progress func(msg string)) (*Result, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	result := new(Result)
+
+	// blobs first, so that notes never link to blobs that are not there yet
+	blobIDs, err := src.GetAllBlobIDs()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(blobIDs)
+	for _, blobID := range blobIDs {
+		srcSum, err := blobChecksum(src, blobID)
+		if err != nil {
+			return nil, fmt.Errorf("blob %s: %v", blobID, err)
+		}
+		if dstSum, err := blobChecksum(dst, blobID); err == nil && dstSum == srcSum {
+			result.BlobsSkipped++
+			continue
+		}
+		if err := copyBlob(src, dst, blobID); err != nil {
+			return nil, fmt.Errorf("blob %s: %v", blobID, err)
+		}
+		result.Blobs++
+		progress("copied blob " + blobID)
+	}
+
+	noteIDs, err := src.GetAllNoteIDs()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(noteIDs)
+	for _, noteID := range noteIDs {
+		note, err := src.LoadNote(noteID)
+		if err != nil {
+			return nil, fmt.Errorf("note %s: %v", noteID, err)
+		}
+		if existing, err := dst.LoadNote(noteID); err == nil && sameNote(note, existing) {
+			result.NotesSkipped++
+			continue
+		}
+		if err := dst.SaveNote(note); err != nil {
+			return nil, fmt.Errorf("note %s: %v", noteID, err)
+		}
+		result.Notes++
+		progress("copied note " + noteID)
+	}
+
+	// tags, fields, blocks, cards and links are set per note and setting them is idempotent,
+	// so they are always copied in full.
+	noteTags, err := tagsByNote(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, noteID := range noteIDs {
+		tags := noteTags[noteID]
+		if tags == nil {
+			tags = []string{}
+		}
+		if err := dst.SetNoteTags(noteID, tags); err != nil {
+			return nil, err
+		}
+	}
+	noteFields, err := fieldsByNote(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, noteID := range noteIDs {
+		fields := noteFields[noteID]
+		if fields == nil {
+			fields = map[string][]string{}
+		}
+		if err := dst.SetNoteFields(noteID, fields); err != nil {
+			return nil, err
+		}
+	}
+	blockIndex, err := src.GetAllNoteBlocks()
+	if err != nil {
+		return nil, err
+	}
+	noteBlocks := make(map[string][]string)
+	for blockID, blockNoteIDs := range blockIndex {
+		for _, noteID := range blockNoteIDs {
+			noteBlocks[noteID] = append(noteBlocks[noteID], blockID)
+		}
+	}
+	for _, noteID := range noteIDs {
+		blockIDs := noteBlocks[noteID]
+		if blockIDs == nil {
+			blockIDs = []string{}
+		}
+		if err := dst.SetNoteBlocks(noteID, blockIDs); err != nil {
+			return nil, err
+		}
+	}
+	cards, err := src.GetAllCards()
+	if err != nil {
+		return nil, err
+	}
+	noteCards := make(map[string][]storage.Card)
+	for _, card := range cards {
+		noteCards[card.NoteID] = append(noteCards[card.NoteID], card)
+	}
+	for _, noteID := range noteIDs {
+		cards := noteCards[noteID]
+		if cards == nil {
+			cards = []storage.Card{}
+		}
+		if err := dst.SetNoteCards(noteID, cards); err != nil {
+			return nil, err
+		}
+	}
+	linkIndex, err := src.GetAllNoteLinks()
+	if err != nil {
+		return nil, err
+	}
+	for _, noteID := range noteIDs {
+		links, found := linkIndex[noteID]
+		if !found {
+			// left to BuildGraph, which indexes missing notes on the way
+			if err := dst.SetNoteLinks(noteID, nil); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := dst.SetNoteLinks(noteID, &links); err != nil {
+			return nil, err
+		}
+	}
+	allTags, err := src.GetAllNoteTags()
+	if err != nil {
+		return nil, err
+	}
+	result.Tags = len(allTags)
+
+	return result, nil
+}
+
+// Verify checks that dst holds every note, blob and tag of src,
+// comparing note contents and blob checksums.
+func Verify(src storage.Storage, dst storage.Storage) error {
+	noteIDs, err := src.GetAllNoteIDs()
+	if err != nil {
+		return err
+	}
+	dstNoteIDs, err := dst.GetAllNoteIDs()
+	if err != nil {
+		return err
+	}
+	if len(dstNoteIDs) < len(noteIDs) {
+		return fmt.Errorf("destination has %d notes, source has %d", len(dstNoteIDs), len(noteIDs))
+	}
+	for _, noteID := range noteIDs {
+		note, err := src.LoadNote(noteID)
+		if err != nil {
+			return err
+		}
+		copied, err := dst.LoadNote(noteID)
+		if err != nil {
+			return fmt.Errorf("note %s: %v", noteID, err)
+		}
+		if !sameNote(note, copied) {
+			return fmt.Errorf("note %s differs", noteID)
+		}
+	}
+
+	blobIDs, err := src.GetAllBlobIDs()
+	if err != nil {
+		return err
+	}
+	dstBlobIDs, err := dst.GetAllBlobIDs()
+	if err != nil {
+		return err
+	}
+	if len(dstBlobIDs) < len(blobIDs) {
+		return fmt.Errorf("destination has %d blobs, source has %d", len(dstBlobIDs), len(blobIDs))
+	}
+	for _, blobID := range blobIDs {
+		srcSum, err := blobChecksum(src, blobID)
+		if err != nil {
+			return err
+		}
+		dstSum, err := blobChecksum(dst, blobID)
+		if err != nil {
+			return fmt.Errorf("blob %s: %v", blobID, err)
+		}
+		if srcSum != dstSum {
+			return fmt.Errorf("blob %s: checksum mismatch", blobID)
+		}
+	}
+
+	srcTags, err := tagsByNote(src)
+	if err != nil {
+		return err
+	}
+	dstTags, err := tagsByNote(dst)
+	if err != nil {
+		return err
+	}
+	for _, noteID := range noteIDs {
+		if !sameTags(srcTags[noteID], dstTags[noteID]) {
+			return fmt.Errorf("note %s: tags differ", noteID)
+		}
+	}
+	return nil
+}
+
+func sameNote(a *storage.Note, b *storage.Note) bool {
+	return a.ID == b.ID && a.Title == b.Title && a.Contents == b.Contents &&
+		a.LastEdit.Equal(b.LastEdit) && a.Created.Equal(b.Created)
+}
+
+func sameTags(a []string, b []string) bool {
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// tagsByNote inverts the tag index of st.
+func tagsByNote(st storage.Storage) (map[string][]string, error) {
+	tagIndex, err := st.GetAllNoteTags()
+	if err != nil {
+		return nil, err
+	}
+	noteTags := make(map[string][]string)
+	for tag, noteIDs := range tagIndex {
+		for _, noteID := range noteIDs {
+			noteTags[noteID] = append(noteTags[noteID], tag)
+		}
+	}
+	return noteTags, nil
+}
+
+// fieldsByNote inverts the field index of st.
+func fieldsByNote(st storage.Storage) (map[string]map[string][]string, error) {
+	fieldIndex, err := st.GetAllNoteFields()
+	if err != nil {
+		return nil, err
+	}
+	noteFields := make(map[string]map[string][]string)
+	for field, values := range fieldIndex {
+		for value, noteIDs := range values {
+			for _, noteID := range noteIDs {
+				if noteFields[noteID] == nil {
+					noteFields[noteID] = make(map[string][]string)
+				}
+				noteFields[noteID][field] = append(noteFields[noteID][field], value)
+			}
+		}
+	}
+	return noteFields, nil
+}
+
+func blobChecksum(st storage.Storage, blobID string) (string, error) {
+	blobPath, err := st.LoadBlobPath(blobID)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func copyBlob(src storage.Storage, dst storage.Storage, blobID string) error {
+	blobPath, err := src.LoadBlobPath(blobID)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data := new(bytes.Buffer)
+	if _, err := io.Copy(data, f); err != nil {
+		return err
+	}
+	return dst.SaveBlob(blobID, *data)
+}
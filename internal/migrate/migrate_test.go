@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/storage/storagetest"
+)
+
+func TestMigrate(t *testing.T) {
+	src := storagetest.TempStorage(t)
+	dst := storagetest.TempStorage(t)
+
+	edited := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	src.SaveNote(&storage.Note{ID: "a", Title: "A", Contents: "# A", LastEdit: edited})
+	src.SaveNote(&storage.Note{ID: "b", Title: "B", Contents: "# B", LastEdit: edited})
+	src.SetNoteTags("a", []string{"x", "y"})
+	src.SaveBlob("blob", *bytes.NewBufferString("data"))
+
+	// simulate an interrupted migration that already copied one note
+	note, _ := src.LoadNote("a")
+	dst.SaveNote(note)
+
+	result, err := Migrate(src, dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Notes != 1 || result.NotesSkipped != 1 || result.Blobs != 1 {
+		t.Error("wrong result:", result)
+	}
+	if err := Verify(src, dst); err != nil {
+		t.Error(err)
+	}
+	copied, err := dst.LoadNote("b")
+	if err != nil || !copied.LastEdit.Equal(edited) {
+		t.Error("last edit time not preserved:", copied)
+	}
+
+	// a second run has nothing left to do
+	result, err = Migrate(src, dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Notes != 0 || result.Blobs != 0 {
+		t.Error("second run copied again:", result)
+	}
+
+	// verification notices differences
+	dst.SaveNote(&storage.Note{ID: "b", Contents: "changed"})
+	if err := Verify(src, dst); err == nil {
+		t.Error("verification missed a changed note")
+	}
+}
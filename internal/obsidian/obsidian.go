@@ -0,0 +1,420 @@
+// Package obsidian imports an Obsidian vault into a storage.
+//
+// Every markdown file of the vault becomes a note whose ID is derived from
+// its path. Wiki-links are rewritten to regular markdown links, embedded
+// notes keep the ![[note]] syntax with their new IDs, and attachments
+// referenced by embeds or relative links are uploaded as blobs.
+// The front matter is kept as it is, snote reads its title, tags, aliases
+// and fields like Obsidian does.
+package obsidian
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/util"
+)
+
+// Problem is something that could not be converted faithfully.
+type Problem struct {
+	File    string `json:"file"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return p.File + ": " + p.Message
+}
+
+type Report struct {
+	// Notes are the IDs of the imported notes.
+	Notes []string `json:"notes"`
+	// Blobs counts the uploaded attachments.
+	Blobs    int       `json:"blobs"`
+	Skipped  []string  `json:"skipped"`
+	Problems []Problem `json:"problems"`
+}
+
+type Options struct {
+	// Overwrite replaces notes that already exist in storage.
+	// Otherwise such files are skipped.
+	Overwrite bool
+}
+
+type importer struct {
+	storage storage.Storage
+	vault   string
+	options Options
+	report  *Report
+
+	// noteIDs maps vault paths of markdown files (relative, slash
+	// separated, without extension) to note IDs.
+	noteIDs map[string]string
+	// byName maps lowercased base names of markdown files to their
+	// vault paths, Obsidian resolves links by name.
+	byName map[string][]string
+	// attachments maps lowercased base names of other files to their vault paths.
+	attachments map[string][]string
+	// blobURLs caches uploaded attachments by vault path.
+	blobURLs map[string]string
+}
+
+// Import walks the vault at dir and saves its notes to st.
+func Import(st storage.Storage, dir string, options Options) (*Report, error) {
+	imp := &importer{
+		storage:     st,
+		vault:       dir,
+		options:     options,
+		report:      &Report{Notes: []string{}, Skipped: []string{}, Problems: []Problem{}},
+		noteIDs:     make(map[string]string),
+		byName:      make(map[string][]string),
+		attachments: make(map[string][]string),
+		blobURLs:    make(map[string]string),
+	}
+
+	files, err := imp.scan()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := imp.importNote(file); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return imp.report, nil
+}
+
+// scan collects all files of the vault and assigns IDs to the notes.
+// It returns the vault paths of all markdown files.
+func (imp *importer) scan() ([]string, error) {
+	markdownFiles := make([]string, 0)
+	err := filepath.Walk(imp.vault, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(imp.vault, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// skip .obsidian, .trash and other hidden files
+		if rel != "." && strings.HasPrefix(path.Base(rel), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		name := strings.ToLower(path.Base(rel))
+		if strings.HasSuffix(name, ".md") {
+			markdownFiles = append(markdownFiles, rel)
+			name = strings.TrimSuffix(name, ".md")
+			imp.byName[name] = append(imp.byName[name], strings.TrimSuffix(rel, ".md"))
+		} else {
+			imp.attachments[name] = append(imp.attachments[name], rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(markdownFiles)
+	taken := make(map[string]bool)
+	for _, file := range markdownFiles {
+		// folders are joined with dashes, "Projects/My Note" becomes "projects-my-note"
+		base := storage.SlugID(strings.TrimSuffix(file, ".md"))
+		id := base
+		for i := 2; taken[id] || storage.IsReserved(id); i++ {
+			id = fmt.Sprintf("%s-%d", base, i)
+		}
+		taken[id] = true
+		imp.noteIDs[strings.TrimSuffix(file, ".md")] = id
+	}
+	return markdownFiles, nil
+}
+
+func (imp *importer) problem(file string, format string, a ...interface{}) {
+	imp.report.Problems = append(imp.report.Problems, Problem{file, fmt.Sprintf(format, a...)})
+}
+
+func (imp *importer) importNote(file string) error {
+	id := imp.noteIDs[strings.TrimSuffix(file, ".md")]
+	if _, err := imp.storage.LoadNote(id); err == nil && !imp.options.Overwrite {
+		imp.report.Skipped = append(imp.report.Skipped, file)
+		return nil
+	}
+
+	fullPath := filepath.Join(imp.vault, filepath.FromSlash(file))
+	b, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+
+	contents := strings.Replace(string(b), "\r\n", "\n", -1)
+	frontMatter, body, hasFrontMatter := storage.SplitFrontMatter(contents)
+	if hasFrontMatter {
+		frontMatter = "---\n" + frontMatter + "---\n"
+	}
+	body = imp.convert(file, body)
+	note := &storage.Note{ID: id, Contents: frontMatter + body, LastEdit: info.ModTime()}
+	if _, err := note.ParseFrontMatter(); err != nil {
+		imp.problem(file, "%v (kept as it is)", err)
+	}
+	// notes without a title in the front matter or a heading are titled
+	// by their file name
+	if note.ParseTitle() == "" {
+		note.Contents = frontMatter + "# " + strings.TrimSuffix(path.Base(file), ".md") + "\n\n" + body
+	}
+	note.Title = note.ParseTitle()
+
+	if err := imp.storage.SaveNote(note); err != nil {
+		return err
+	}
+	if err := storage.IndexNote(imp.storage, note); err != nil {
+		return err
+	}
+	imp.report.Notes = append(imp.report.Notes, id)
+	return nil
+}
+
+var (
+	wikiLink     = regexp.MustCompile(`(!?)\[\[([^\]\n]+)\]\]`)
+	markdownLink = regexp.MustCompile(`(!?)\[([^\]\n]*)\]\(([^)\s]+)\)`)
+)
+
+// convert rewrites links and embeds in body. Code blocks and code spans
+// are left untouched.
+func (imp *importer) convert(file string, body string) string {
+	lines := strings.Split(body, "\n")
+	fence := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+
+		// only convert outside of code spans, i.e. in even segments
+		segments := strings.Split(line, "`")
+		for j := 0; j < len(segments); j += 2 {
+			segment := segments[j]
+			segment = wikiLink.ReplaceAllStringFunc(segment, func(m string) string {
+				parts := wikiLink.FindStringSubmatch(m)
+				return imp.convertWikiLink(file, m, parts[1] == "!", parts[2])
+			})
+			segment = markdownLink.ReplaceAllStringFunc(segment, func(m string) string {
+				parts := markdownLink.FindStringSubmatch(m)
+				return imp.convertMarkdownLink(file, m, parts[1] == "!", parts[2], parts[3])
+			})
+			segments[j] = segment
+		}
+		lines[i] = strings.Join(segments, "`")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// convertWikiLink converts [[target#heading|alias]] and ![[target]].
+func (imp *importer) convertWikiLink(file string, original string, embed bool, inner string) string {
+	target, alias := inner, ""
+	if i := strings.Index(inner, "|"); i >= 0 {
+		target, alias = inner[:i], inner[i+1:]
+	}
+	target = strings.TrimSpace(target)
+	fragment := ""
+	if i := strings.Index(target, "#"); i >= 0 {
+		target, fragment = target[:i], target[i+1:]
+	}
+
+	// attachments have an extension other than .md
+	ext := strings.ToLower(path.Ext(target))
+	if ext != "" && ext != ".md" {
+		blobURL, ok := imp.upload(file, imp.resolveAttachment(file, target))
+		if !ok {
+			imp.problem(file, "attachment %q not found", target)
+			return original
+		}
+		name := path.Base(target)
+		if isImage(ext) && embed {
+			if alias != "" && !isSize(alias) {
+				name = alias
+			}
+			return "![" + name + "](" + blobURL + ")"
+		}
+		if alias != "" && !isSize(alias) {
+			name = alias
+		}
+		return "[" + name + "](" + blobURL + ")"
+	}
+
+	if target == "" {
+		// a link to a heading of the same note
+		return "[" + firstNonEmpty(alias, fragment) + "](#" + storage.Slug(fragment) + ")"
+	}
+	id, ok := imp.resolveNote(file, target)
+	if !ok {
+		imp.problem(file, "link target %q not found", target)
+		return firstNonEmpty(alias, target)
+	}
+	if embed {
+		// snote embeds notes the same way, only without the alias
+		if fragment != "" {
+			return "![[" + id + "#" + fragment + "]]"
+		}
+		return "![[" + id + "]]"
+	}
+	href := "/" + id
+	if fragment != "" {
+		href += "#" + storage.Slug(fragment)
+	}
+	return "[" + firstNonEmpty(alias, path.Base(target)) + "](" + href + ")"
+}
+
+// convertMarkdownLink rewrites relative links to notes and attachments
+// in the vault. Other links are returned unchanged.
+func (imp *importer) convertMarkdownLink(file string, original string, embed bool, text string, dest string) string {
+	if strings.Contains(dest, "://") || strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "#") || strings.HasPrefix(dest, "mailto:") {
+		return original
+	}
+	target, err := url.PathUnescape(dest)
+	if err != nil {
+		target = dest
+	}
+	fragment := ""
+	if i := strings.Index(target, "#"); i >= 0 {
+		target, fragment = target[:i], target[i+1:]
+	}
+	vaultPath := path.Clean(path.Join(path.Dir(file), target))
+
+	if strings.HasSuffix(strings.ToLower(target), ".md") {
+		id, found := imp.noteIDs[strings.TrimSuffix(vaultPath, path.Ext(vaultPath))]
+		if !found {
+			imp.problem(file, "link target %q not found", dest)
+			return original
+		}
+		href := "/" + id
+		if fragment != "" {
+			href += "#" + storage.Slug(fragment)
+		}
+		return "[" + text + "](" + href + ")"
+	}
+
+	blobURL, ok := imp.upload(file, vaultPath)
+	if !ok {
+		imp.problem(file, "attachment %q not found", dest)
+		return original
+	}
+	prefix := ""
+	if embed {
+		prefix = "!"
+	}
+	return prefix + "[" + text + "](" + blobURL + ")"
+}
+
+// resolveNote finds the note a wiki-link target refers to: an exact vault
+// path first, then the shortest path among files with the same name.
+func (imp *importer) resolveNote(file string, target string) (string, bool) {
+	target = strings.TrimSuffix(target, ".md")
+	if id, found := imp.noteIDs[target]; found {
+		return id, true
+	}
+	if id, found := imp.noteIDs[path.Join(path.Dir(file), target)]; found {
+		return id, true
+	}
+	candidates := imp.byName[strings.ToLower(path.Base(target))]
+	if len(candidates) == 0 {
+		return "", false
+	}
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if len(candidate) < len(best) {
+			best = candidate
+		}
+	}
+	return imp.noteIDs[best], true
+}
+
+func (imp *importer) resolveAttachment(file string, target string) string {
+	for _, candidate := range []string{target, path.Join(path.Dir(file), target)} {
+		if !fs.ValidPath(candidate) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(imp.vault, filepath.FromSlash(candidate))); err == nil {
+			return candidate
+		}
+	}
+	candidates := imp.attachments[strings.ToLower(path.Base(target))]
+	if len(candidates) == 0 {
+		return target
+	}
+	return candidates[0]
+}
+
+// upload saves the attachment at vaultPath as a blob and returns its URL.
+func (imp *importer) upload(file string, vaultPath string) (string, bool) {
+	if blobURL, found := imp.blobURLs[vaultPath]; found {
+		return blobURL, true
+	}
+	// links like ../../etc/passwd point outside of the vault
+	if !fs.ValidPath(vaultPath) {
+		return "", false
+	}
+	b, err := ioutil.ReadFile(filepath.Join(imp.vault, filepath.FromSlash(vaultPath)))
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(b)
+	blobID := hex.EncodeToString(sum[:])
+	if err := imp.storage.SaveBlob(blobID, *bytes.NewBuffer(b)); err != nil {
+		imp.problem(file, "uploading %q: %v", vaultPath, err)
+		return "", false
+	}
+	imp.report.Blobs++
+	// the blob url format is the one Note.ParseBlobIDs recognises
+	blobURL := "/api/blob/" + blobID + "/" + url.PathEscape(path.Base(vaultPath))
+	imp.blobURLs[vaultPath] = blobURL
+	return blobURL, true
+}
+
+func isImage(ext string) bool {
+	return util.SliceContainsString([]string{".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".bmp"}, ext)
+}
+
+// isSize reports whether the alias of an embed is an image size like "300" or "300x200".
+var sizeAlias = regexp.MustCompile(`^\d+(x\d+)?$`)
+
+func isSize(alias string) bool {
+	return sizeAlias.MatchString(strings.TrimSpace(alias))
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, el := range s {
+		if el != "" {
+			return el
+		}
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package obsidian
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/storage/storagetest"
+)
+
+func writeVault(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImport(t *testing.T) {
+	vault := writeVault(t, map[string]string{
+		".obsidian/app.json": "{}",
+		"Projects/Road Trip.md": "---\ntags: [travel, plans]\naliases:\n  - trip\nstatus: booked\n---\n" +
+			"Going with [[People/Ana|Ana]], see [[Budget#Fuel costs]].\n" +
+			"![[map.png|300]] and ![[tickets.pdf]] and ![[Missing.png]]\n" +
+			"Also [[Nowhere]] and #car.\n" +
+			"![[Budget#Fuel costs]]\n![[Ana|the photo]]\n" +
+			"```\n[[not a link]] #notatag\n```\n",
+		"People/Ana.md":             "# Ana Horvat\n\n![photo](../attachments/ana%20photo.jpg)",
+		"Budget.md":                 "# Budget\n\n## Fuel costs\n\nsee [road trip](Projects/Road%20Trip.md)",
+		"attachments/map.png":       "png",
+		"attachments/tickets.pdf":   "pdf",
+		"attachments/ana photo.jpg": "jpg",
+	})
+	st := storagetest.TempStorage(t)
+
+	report, err := Import(st, vault, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Notes) != 3 || report.Blobs != 3 {
+		t.Error("wrong report:", report)
+	}
+
+	trip, err := st.LoadNote("projects-road-trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"---\ntags: [travel, plans]\naliases:\n  - trip\nstatus: booked\n---\n# Road Trip\n\nGoing with",
+		"[Ana](/people-ana)",
+		"[Budget](/budget#fuel-costs)",
+		"![map.png](/api/blob/",
+		"[tickets.pdf](/api/blob/",
+		"![[Missing.png]]",
+		"Also Nowhere and",
+		"![[budget#Fuel costs]]\n![[people-ana]]\n",
+		"[[not a link]] #notatag",
+	} {
+		if !strings.Contains(trip.Contents, expected) {
+			t.Errorf("%q not found in:\n%s", expected, trip.Contents)
+		}
+	}
+	if fields := trip.ParseFields(); len(fields["status"]) != 1 || len(fields["aliases"]) != 1 {
+		t.Error("front matter fields were not kept:", fields)
+	}
+	if blobIDs := trip.ParseBlobIDs(); len(blobIDs) != 2 {
+		t.Error("blob links are not recognised:", blobIDs)
+	}
+	if trip.Title != "Road Trip" {
+		t.Error("wrong title:", trip.Title)
+	}
+
+	ana, _ := st.LoadNote("people-ana")
+	if ana.Title != "Ana Horvat" || !strings.Contains(ana.Contents, "![photo](/api/blob/") {
+		t.Error("relative image not converted:", ana.Contents)
+	}
+	budget, _ := st.LoadNote("budget")
+	if !strings.Contains(budget.Contents, "[road trip](/projects-road-trip)") {
+		t.Error("relative note link not converted:", budget.Contents)
+	}
+
+	tags, _ := st.GetAllNoteTags()
+	if len(tags["travel"]) != 1 || len(tags["car"]) != 1 || len(tags["notatag"]) != 0 {
+		t.Error("wrong tags:", tags)
+	}
+
+	problems := make([]string, 0)
+	for _, problem := range report.Problems {
+		problems = append(problems, problem.String())
+	}
+	joined := strings.Join(problems, "\n")
+	if strings.Contains(joined, "embed") {
+		t.Error("embedded notes reported as problems:", joined)
+	}
+	for _, expected := range []string{"Missing.png", "Nowhere"} {
+		if !strings.Contains(joined, expected) {
+			t.Errorf("problem %q not reported in:\n%s", expected, joined)
+		}
+	}
+
+	// existing notes are skipped unless overwriting
+	report, err = Import(st, vault, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Notes) != 0 || len(report.Skipped) != 3 {
+		t.Error("existing notes were not skipped:", report)
+	}
+}
+
+func TestImportStaysInVault(t *testing.T) {
+	dir := writeVault(t, map[string]string{
+		"secret.txt":    "secret",
+		"vault/Note.md": "![x](../secret.txt) and ![[../secret.txt]]",
+	})
+	st := storage.NewDiskStorage(filepath.Join(dir, "storage"))
+
+	report, err := Import(st, filepath.Join(dir, "vault"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blobs != 0 {
+		t.Error("file outside of the vault uploaded:", report)
+	}
+}
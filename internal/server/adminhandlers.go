@@ -0,0 +1,86 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/sbrki/snote/internal/archive"
+)
+
+// returns the report of the last storage check.
+// returns HTTP 404 (Not Found) if the storage has not been checked yet.
+func (s *Server) fsckGetHandler(c echo.Context) error {
+	s.fsckMutex.Lock()
+	report := s.fsckReport
+	s.fsckMutex.Unlock()
+	if report == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "storage has not been checked yet")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// checks the storage right away and returns the report.
+// problems are repaired if the repair query parameter is "true".
+func (s *Server) fsckPostHandler(c echo.Context) error {
+	report, err := s.runFsck(c.QueryParam("repair") == "true")
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "error checking storage (check logs for more info)")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// streams an archive of the whole storage (see package archive).
+func (s *Server) exportGetHandler(c echo.Context) error {
+	filename := "snote-" + time.Now().Format("2006-01-02-150405") + ".tar.gz"
+	c.Response().Header().Set(echo.HeaderContentType, "application/gzip")
+	setAttachment(c, filename)
+	c.Response().WriteHeader(http.StatusOK)
+	if err := archive.Export(s.storage, c.Response()); err != nil {
+		// the status has already been sent, all that is left is to
+		// log the error and cut the archive short.
+		c.Logger().Error(err)
+	}
+	return nil
+}
+
+// restores an archive into the storage. the archive is either the request
+// body or the "file" field of a multipart form. the mode query parameter
+// selects between merging (default) and replacing the storage contents.
+func (s *Server) importPostHandler(c echo.Context) error {
+	modeName := c.QueryParam("mode")
+	if modeName == "" {
+		modeName = string(archive.Merge)
+	}
+	mode, err := archive.ParseMode(modeName)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var src io.Reader = c.Request().Body
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		defer f.Close()
+		src = f
+	}
+
+	stats, err := archive.Import(s.storage, src, mode)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusBadRequest, "error importing archive: "+err.Error())
+	}
+	// every note may have changed
+	s.renderCache.Flush()
+	return c.JSON(http.StatusOK, stats)
+}
@@ -4,23 +4,31 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"io"
 	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf16"
 
 	"github.com/labstack/echo"
 	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/util"
 )
 
 func (s *Server) noteGetHandler(c echo.Context) error {
 	id := c.Param("note_id")
 	var note *storage.Note
-	if id == "ls" {
-		note = new(storage.Note)
-		note.GenerateLs(s.storage)
-	} else if id == "lstag" {
-		note = new(storage.Note)
-		note.GenerateLsTag(s.storage)
+	if storage.IsAutogenerated(id) {
+		generated, err := storage.GenerateNote(s.storage, id)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		note = generated
 	} else {
 		storedNote, err := s.storage.LoadNote(id)
 		if err != nil {
@@ -31,18 +39,51 @@ func (s *Server) noteGetHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, note)
 }
 
+// returns the heading tree of a note, with the slug anchors of the headings.
+func (s *Server) noteOutlineGetHandler(c echo.Context) error {
+	note, err := s.storage.LoadNote(c.Param("note_id"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	return c.JSON(http.StatusOK, note.ParseOutline())
+}
+
+// returns a block of a note, with its html. Blocks moved to another note
+// are redirected to it.
+func (s *Server) noteBlockGetHandler(c echo.Context) error {
+	noteID := c.Param("note_id")
+	blockID := strings.TrimPrefix(c.Param("block_id"), "^")
+	if note, err := s.storage.LoadNote(noteID); err == nil {
+		if block, found := note.RenderBlock(s.storage, blockID, codeCache{s}); found {
+			return c.JSON(http.StatusOK, block)
+		}
+	}
+	blockIndex, err := s.storage.GetAllNoteBlocks()
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	if noteIDs := blockIndex[blockID]; len(noteIDs) > 0 && noteIDs[0] != noteID {
+		return c.Redirect(http.StatusMovedPermanently, "/api/note/"+noteIDs[0]+"/block/"+blockID)
+	}
+	return echo.NewHTTPError(http.StatusNotFound, "404 Not found")
+}
+
 func (s *Server) notePutHandler(c echo.Context) error {
 	id := c.Param("note_id")
 
 	// ignore put requests to system autogenerated notes
-	if id == "ls" || id == "lstag" {
+	if storage.IsAutogenerated(id) {
 		return c.NoContent(http.StatusOK)
 	}
+	if storage.IsReserved(id) {
+		return echo.NewHTTPError(http.StatusBadRequest, "note ID "+id+" is reserved")
+	}
 
 	// delete rendered HTML from cache.
 	// without deleting the cache on PUT requests the cache
 	// get stale.
-	s.renderCache.Delete(id)
+	s.invalidateRender(id)
 
 	updatedNote := new(storage.Note)
 
@@ -50,31 +91,47 @@ func (s *Server) notePutHandler(c echo.Context) error {
 		c.Logger().Error(err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "error bining request body json to storage.Note struct (check logs for more info)")
 	}
+	// the note is always stored under the id from the URL
+	updatedNote.ID = id
 
-	updatedNote.LastEdit = time.Now()
-	updatedNote.Title = updatedNote.ParseTitle()
-
-	// save note to storage
-	err := s.storage.SaveNote(updatedNote)
+	// save note and its tags to storage (tag index)
+	err := storage.UpdateNote(s.storage, updatedNote)
 	if err != nil {
 		c.Logger().Error(err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "error saving note (check logs for more info)")
 	}
 
-	// update note tags to storage (tag index)
-	err = s.storage.SetNoteTags(id, updatedNote.ParseTags())
+	return c.NoContent(http.StatusOK)
+}
+
+// toggles a task list item of a note between open and done.
+// returns the toggled task.
+func (s *Server) noteTaskTogglePostHandler(c echo.Context) error {
+	id := c.Param("note_id")
+	index, err := strconv.Atoi(c.Param("index"))
 	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid task index")
+	}
+	note, err := s.storage.LoadNote(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "404 Not found")
+	}
+	task, err := note.ToggleTask(index)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err := storage.UpdateNote(s.storage, note); err != nil {
 		c.Logger().Error(err)
-		return c.NoContent(http.StatusInternalServerError)
+		return echo.NewHTTPError(http.StatusInternalServerError, "error saving note (check logs for more info)")
 	}
-
-	return c.NoContent(http.StatusOK)
+	s.invalidateRender(id)
+	return c.JSON(http.StatusOK, task)
 }
 
 func (s *Server) noteDeleteHandler(c echo.Context) error {
 	id := c.Param("note_id")
 	// ignore system autogenerated notes
-	if id == "ls" || id == "lstag" {
+	if storage.IsAutogenerated(id) {
 		return c.NoContent(http.StatusOK)
 	}
 
@@ -88,9 +145,11 @@ func (s *Server) noteDeleteHandler(c echo.Context) error {
 		c.Logger().Error(err)
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
+	// notes embedding the deleted note have to be rendered again
+	s.invalidateRender(id)
 
-	// delete note id from tag index
-	err = s.storage.SetNoteTags(id, []string{})
+	// delete note id from tag and field indexes
+	err = storage.UnindexNote(s.storage, id)
 	if err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
@@ -99,36 +158,206 @@ func (s *Server) noteDeleteHandler(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
-// used by the client to create a new note.
-// returns HTTP 409 (Conflict) if a note with the same id already exists.
-// returns HTTP 201 (Created)  on a successful creation.
+// used by the client to create a new note, optionally from the template
+// note given by the template form value, or else from the default template
+// of the tag form value. The title form value fills {{title}}.
+// returns HTTP 409 (Conflict) if a note with the same id already exists
+// or the id is reserved (see storage.IsReserved).
+// returns HTTP 400 (Bad Request) if the template is not a template note
+// or the id is not valid.
+// returns HTTP 201 (Created)  on a successful creation, with the id of the
+// note and the position of the {{cursor}} of the template, if any.
 func (s *Server) noteCollectionPostHandler(c echo.Context) error {
 	// read the id of the new note that the client suggested
 	id := c.FormValue("suggested_id")
-	// ignore system autogenerated notes
-	if id == "ls" || id == "lstag" {
+	// autogenerated notes and pages can not be created
+	if storage.IsReserved(id) {
 		return c.NoContent(http.StatusConflict)
 	}
 
-	// check if a note with the provided id exists
+	// check if a note with the provided id exists. a note that exists but
+	// can not be loaded must not be overwritten either.
 	_, err := s.storage.LoadNote(id)
 	if err == nil {
 		return c.NoContent(http.StatusConflict)
+	} else if errors.Is(err, storage.ErrInvalidNoteID) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else if !os.IsNotExist(err) {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusConflict)
 	}
 
-	// create new note
+	// fill the note from the template, or the default template of the tag
+	templateID := c.FormValue("template")
+	if tag := c.FormValue("tag"); templateID == "" && tag != "" {
+		templateID, _, err = storage.DefaultTemplate(s.storage, tag)
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+	title := c.FormValue("title")
+	if title == "" {
+		title = id
+	}
+	cursor, err := s.createNote(id, templateID, title)
+	if err == errNoSuchTemplate || errors.Is(err, storage.ErrInvalidNoteID) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	created := struct {
+		ID     string `json:"id"`
+		Cursor *int   `json:"cursor,omitempty"`
+	}{ID: id}
+	if cursor >= 0 {
+		created.Cursor = &cursor
+	}
+	return c.JSON(http.StatusCreated, created)
+}
+
+var errNoSuchTemplate = errors.New("no such template")
+
+// createNote creates the note with the given id, filled from the template
+// note if templateID is not empty, or else with the title as its heading.
+// It returns the position of the {{cursor}} of the template, counted in
+// UTF-16 code units like in JavaScript strings, or -1 if there is none.
+func (s *Server) createNote(id string, templateID string, title string) (int, error) {
 	newNote := new(storage.Note)
 	newNote.ID = id
-	newNote.Contents = "# " + id
-	newNote.LastEdit = time.Now()
+	newNote.Contents = "# " + title
+
+	cursor := -1
+	if templateID != "" {
+		template, err := s.storage.LoadNote(templateID)
+		if err != nil || !template.IsTemplate() {
+			return -1, errNoSuchTemplate
+		}
+		newNote.Contents, cursor = template.ExpandTemplate(storage.TemplateVars{ID: id, Title: title, Date: time.Now()})
+	}
+
+	// sets the title and the creation time and indexes the note
+	if err := storage.UpdateNote(s.storage, newNote); err != nil {
+		return -1, err
+	}
+	// notes embedding the new note have to be rendered again
+	s.invalidateRender(id)
+
+	if cursor < 0 {
+		return -1, nil
+	}
+	return len(utf16.Encode([]rune(newNote.Contents[:cursor]))), nil
+}
+
+// lists all notes, optionally only the ones tagged with the tag query parameter
+// (or one of its descendant tags) and the ones having the front matter field
+// query parameter (with the value query parameter, if given).
+func (s *Server) noteCollectionGetHandler(c echo.Context) error {
+	filterTag := strings.ToLower(strings.Trim(c.QueryParam("tag"), "#/"))
+	filterField := strings.ToLower(c.QueryParam("field"))
+	filterValue := c.QueryParam("value")
+
+	allNoteIDs, err := s.storage.GetAllNoteIDs()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	tagIndex, err := s.storage.GetAllNoteTags()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
 
-	err = s.storage.SaveNote(newNote)
+	fieldIndex, err := s.storage.GetAllNoteFields()
 	if err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	return c.NoContent(http.StatusCreated)
+	// invert the tag index to get the tags of every note
+	noteTags := make(map[string][]string)
+	for tag, noteIDs := range tagIndex {
+		for _, noteID := range noteIDs {
+			noteTags[noteID] = append(noteTags[noteID], tag)
+		}
+	}
+	// and the field index to get the fields of every note
+	noteFields := make(map[string]map[string][]string)
+	for field, values := range fieldIndex {
+		for value, noteIDs := range values {
+			for _, noteID := range noteIDs {
+				if noteFields[noteID] == nil {
+					noteFields[noteID] = make(map[string][]string)
+				}
+				noteFields[noteID][field] = append(noteFields[noteID][field], value)
+			}
+		}
+	}
+
+	sort.Strings(allNoteIDs)
+	result := make([]storage.NoteInfo, 0, len(allNoteIDs))
+	for _, noteID := range allNoteIDs {
+		tags := noteTags[noteID]
+		if tags == nil {
+			tags = []string{}
+		}
+		if filterTag != "" && !hasTag(tags, filterTag) {
+			continue
+		}
+		fields := noteFields[noteID]
+		if filterField != "" {
+			values, found := fields[filterField]
+			if !found || filterValue != "" && !util.SliceContainsString(values, filterValue) {
+				continue
+			}
+		}
+		for _, values := range fields {
+			sort.Strings(values)
+		}
+		note, err := s.storage.LoadNote(noteID)
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		sort.Strings(tags)
+		result = append(result, storage.NoteInfo{
+			ID:       noteID,
+			Title:    note.Title,
+			Size:     len(note.Contents),
+			LastEdit: note.LastEdit,
+			Tags:     tags,
+			Fields:   fields,
+		})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// hasTag reports whether one of tags is tag or one of its descendants.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if storage.TagIncludes(tag, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the field index: every front matter field with all its values
+// and the IDs of the notes having them.
+func (s *Server) fieldCollectionGetHandler(c echo.Context) error {
+	fieldIndex, err := s.storage.GetAllNoteFields()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	for _, values := range fieldIndex {
+		for _, noteIDs := range values {
+			sort.Strings(noteIDs)
+		}
+	}
+	return c.JSON(http.StatusOK, fieldIndex)
 }
 
 func (s *Server) blobCollectionPostHandler(c echo.Context) error {
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// assetHandler serves the static files of s.assets. Files requested with
+// their current content hash are cached forever.
+func (s *Server) assetHandler(c echo.Context) error {
+	name := strings.TrimPrefix(c.Param("*"), "/")
+	if !fs.ValidPath(name) {
+		return c.NoContent(http.StatusNotFound)
+	}
+	return s.serveAsset(c, name)
+}
+
+func (s *Server) serveAsset(c echo.Context, name string) error {
+	f, err := s.assets.FS().Open(name)
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return c.NoContent(http.StatusNotFound)
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	header := c.Response().Header()
+	hash, found := s.assets.Hash(name)
+	if found {
+		header.Set("ETag", `"`+hash+`"`)
+	}
+	if found && !s.assets.Dev() && c.QueryParam("v") == hash {
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		header.Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(c.Response(), c.Request(), info.Name(), info.ModTime(), content)
+	return nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/labstack/echo"
+	"github.com/sbrki/snote/internal/export"
+	"github.com/sbrki/snote/internal/storage"
+)
+
+// downloads a note as a document that can be read without snote (see package export).
+// the format query parameter is "html" (default), "epub" or "docx". an epub
+// with the tag query parameter contains every note with that tag, using the
+// note as its index.
+func (s *Server) noteExportHandler(c echo.Context) error {
+	id := c.Param("note_id")
+	note, err := s.storage.LoadNote(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "404 Not found")
+	}
+
+	// documents are rendered to a buffer first, so that errors
+	// can still be reported with a proper status code.
+	b := new(bytes.Buffer)
+	var contentType, extension string
+	switch format := c.QueryParam("format"); format {
+	case "", "html":
+		contentType, extension = echo.MIMETextHTMLCharsetUTF8, ".html"
+		err = export.HTML(s.storage, note, b)
+	case "epub":
+		contentType, extension = "application/epub+zip", ".epub"
+		notes := []*storage.Note{note}
+		title := note.Title
+		if title == "" {
+			title = note.ID
+		}
+		if tag := c.QueryParam("tag"); tag != "" {
+			notes, err = export.TagNotes(s.storage, note, tag)
+			if err != nil {
+				c.Logger().Error(err)
+				return c.NoContent(http.StatusInternalServerError)
+			}
+			title = tag
+		}
+		err = export.EPUB(s.storage, title, notes, b)
+	case "docx":
+		contentType, extension = "application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx"
+		err = export.DOCX(s.storage, note, b)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "unknown export format "+format)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	filename := path.Base(id) + extension
+	setAttachment(c, filename)
+	return c.Blob(http.StatusOK, contentType, b.Bytes())
+}
+
+// setAttachment makes the response a download saved as filename. The name
+// is encoded by mime.FormatMediaType, so that quotes or line breaks in note
+// IDs and tags can not break the header.
+func setAttachment(c echo.Context, filename string) {
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
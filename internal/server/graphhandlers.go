@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/sbrki/snote/internal/storage"
+)
+
+// shows the graph of the links between notes, see js/graph.js. the query
+// parameters are passed on to the api by the script.
+func (s *Server) htmlGraphHandler(c echo.Context) error {
+	return c.Render(http.StatusOK, "graph.html", nil)
+}
+
+// returns the graph of the links between notes. the tag query parameter
+// limits it to the notes having the tag, the note query parameter to the
+// notes within depth (default 1) links of the note.
+func (s *Server) graphGetHandler(c echo.Context) error {
+	depth := 1
+	if value := c.QueryParam("depth"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "depth must be a number of links")
+		}
+		depth = parsed
+	}
+
+	graph, err := storage.BuildGraph(s.storage, c.QueryParam("tag"))
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if id := c.QueryParam("note"); id != "" {
+		neighbourhood, found := graph.Neighbourhood(id, depth)
+		if !found {
+			return echo.NewHTTPError(http.StatusNotFound, "404 Not found")
+		}
+		graph = neighbourhood
+	}
+	return c.JSON(http.StatusOK, graph)
+}
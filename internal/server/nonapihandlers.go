@@ -3,9 +3,9 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"os"
+	"time"
 
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/parser"
 	"github.com/labstack/echo"
 	"github.com/sbrki/snote/internal/storage"
 )
@@ -18,47 +18,100 @@ func (s *Server) htmlNoteHandler(c echo.Context) error {
 	id := c.Param("note_id")
 	var note *storage.Note
 
-	if id == "ls" || id == "lstag" {
-		note := new(storage.Note)
-		if id == "ls" {
-			note.GenerateLs(s.storage)
-		} else {
-			note.GenerateLsTag(s.storage)
+	if storage.IsAutogenerated(id) {
+		note, err := storage.GenerateNote(s.storage, id)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
-		parser := parser.NewWithExtensions(parser.CommonExtensions)
-		html := markdown.ToHTML([]byte(note.Contents), parser, nil)
+		html := note.RenderHTMLCached(codeCache{s})
 		return c.Render(http.StatusOK, "preview.html", struct {
 			RenderedHTML string
 			ID           string
-		}{fmt.Sprintf("%s", html), note.ID})
+		}{html, note.ID})
 
 	} else {
 		storedNote, err := s.storage.LoadNote(id)
 		if err != nil {
+			// the id may be an alias of a note
+			if noteID, found, err := storage.ResolveAlias(s.storage, id); err == nil && found {
+				return c.Redirect(http.StatusFound, "/"+noteID)
+			}
 			return echo.NewHTTPError(http.StatusNotFound, "404 Not found")
 		}
 		note = storedNote
 	}
 
-	fmt.Println("TITLE:", note.ParseTitle())
-
 	// render the note markdown contents to html.
 	// rendered html is cached as it takes approx. 1s to render a 5k LoC markdown.
 	// first, check if html rendering of markdown exists in cache
 	html, found := s.renderCache.Get(note.ID)
 	if !found {
-		// if not, render it
-		html = note.RenderHTML()
+		// if not, render it, along with the notes it embeds
+		rendered, embedded := note.RenderHTMLEmbedding(s.storage, codeCache{s})
+		html = rendered
 		// add it to cache
-		s.renderCache.SetDefault(note.ID, html)
+		s.cacheRender(note.ID, rendered, embedded)
+	}
+
+	// journal entries link to the entries before and after them, the links
+	// change as entries are added so they are not cached
+	navigation := ""
+	if _, ok := storage.JournalDate(note.ID); ok {
+		allNoteIDs, err := s.storage.GetAllNoteIDs()
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		navigation = storage.JournalNavigation(allNoteIDs, note.ID)
 	}
 
 	return c.Render(http.StatusOK, "preview.html", struct {
 		RenderedHTML string
 		ID           string
-	}{fmt.Sprintf("%s", html), note.ID})
+	}{fmt.Sprintf("%s%s", navigation, html), note.ID})
+}
+
+// opens the journal entry of today, which is created first from the
+// journal template if it does not exist yet.
+func (s *Server) htmlTodayHandler(c echo.Context) error {
+	today := time.Now()
+	id := storage.JournalID(today)
+	if _, err := s.storage.LoadNote(id); err == nil {
+		return c.Redirect(http.StatusFound, "/"+id)
+	} else if !os.IsNotExist(err) {
+		// an unreadable entry must not be overwritten
+		c.Logger().Error(fmt.Errorf("journal entry %s: %v", id, err))
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	title := storage.JournalTitle(today)
+	cursor, err := s.createNote(id, s.config.Journal.Template, title)
+	if err == errNoSuchTemplate {
+		c.Logger().Warnf("journal template %q is not a template note, creating %s without it", s.config.Journal.Template, id)
+		cursor, err = s.createNote(id, "", title)
+	}
+	if err != nil {
+		c.Logger().Error(fmt.Errorf("journal entry %s: %v", id, err))
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	edit := "/" + id + "/edit"
+	if cursor >= 0 {
+		edit += fmt.Sprintf("#cursor=%d", cursor)
+	}
+	return c.Redirect(http.StatusFound, edit)
 }
 
 func (s *Server) htmlNoteEditHandler(c echo.Context) error {
 	return c.Render(http.StatusOK, "edit.html", nil)
 }
+
+// highlightCSSHandler serves the stylesheet of the configured theme for highlighted code.
+func (s *Server) highlightCSSHandler(c echo.Context) error {
+	css, err := storage.HighlightCSS(s.config.Render.HighlightStyle)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	return c.Blob(http.StatusOK, "text/css; charset=utf-8", []byte(css))
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/sbrki/snote/internal/export"
+	"github.com/sbrki/snote/internal/storage"
+)
+
+// shows the flashcards that are due one after another, see js/review.js.
+// the tag query parameter is read by the script.
+func (s *Server) htmlReviewHandler(c echo.Context) error {
+	return c.Render(http.StatusOK, "review.html", nil)
+}
+
+// returns the flashcard to review next along with the number of cards that
+// are due, or no content if no card is due. the tag query parameter limits
+// the cards to the notes having the tag.
+func (s *Server) reviewNextGetHandler(c echo.Context) error {
+	cards, err := s.storage.GetAllCards()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if tag := c.QueryParam("tag"); tag != "" {
+		tagIndex, err := s.storage.GetAllNoteTags()
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		tagged := make(map[string]bool)
+		for _, noteID := range storage.TaggedNoteIDs(tagIndex, tag) {
+			tagged[noteID] = true
+		}
+		for cardID, card := range cards {
+			if !tagged[card.NoteID] {
+				delete(cards, cardID)
+			}
+		}
+	}
+
+	due := storage.DueCards(cards, time.Now())
+	if len(due) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, struct {
+		storage.Card
+		Remaining int `json:"remaining"`
+	}{due[0], len(due)})
+}
+
+// grades a review of a flashcard, the grade form value goes from 0
+// (forgotten) to 5 (perfect recall). returns the card with its next review.
+func (s *Server) reviewGradePostHandler(c echo.Context) error {
+	grade, err := strconv.Atoi(c.FormValue("grade"))
+	if err != nil || grade < 0 || grade > storage.MaxGrade {
+		return echo.NewHTTPError(http.StatusBadRequest, "grade must be between 0 and "+strconv.Itoa(storage.MaxGrade))
+	}
+	cards, err := s.storage.GetAllCards()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	card, found := cards[c.Param("card_id")]
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "404 Not found")
+	}
+
+	card.Review.Grade(grade, time.Now())
+	if err := s.storage.SetCardReview(card.ID, card.Review); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, card)
+}
+
+// downloads the flashcards as a csv file that anki can import, limited to
+// the notes having the tag query parameter if it is given.
+func (s *Server) reviewExportGetHandler(c echo.Context) error {
+	tag := c.QueryParam("tag")
+	b := new(bytes.Buffer)
+	if err := export.AnkiCSV(s.storage, tag, b); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	filename := "cards.csv"
+	if tag != "" {
+		filename = strings.ReplaceAll(strings.TrimPrefix(tag, "#"), "/", "-") + ".csv"
+	}
+	setAttachment(c, filename)
+	return c.Blob(http.StatusOK, "text/csv; charset=utf-8", b.Bytes())
+}
@@ -1,122 +1,389 @@
 package server
 
 import (
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/labstack/gommon/log"
 	"github.com/patrickmn/go-cache"
+	"github.com/sbrki/snote/internal/auth"
+	"github.com/sbrki/snote/internal/config"
+	"github.com/sbrki/snote/internal/dav"
+	"github.com/sbrki/snote/internal/fsck"
 	"github.com/sbrki/snote/internal/storage"
 	"github.com/sbrki/snote/internal/util"
+	"github.com/sbrki/snote/web"
 )
 
 type Server struct {
+	config           *config.Config
 	storage          storage.Storage
 	templateRegistry *TemplateRegistry
+	assets           *web.Assets
+	users            *auth.UserStore
+	authMiddleware   echo.MiddlewareFunc
+	davHandler       echo.HandlerFunc
 	echo             *echo.Echo
 	renderCache      *cache.Cache
+	// highlightCache holds highlighted code blocks, so that editing a
+	// note does not highlight its unchanged code again.
+	highlightCache *cache.Cache
+	// embedders maps the ID of a note to the IDs of the notes whose cached
+	// rendering embeds it, see invalidateRender.
+	embedders      map[string][]string
+	embeddersMutex sync.Mutex
+
+	fsckMutex  sync.Mutex
+	fsckReport *fsck.Report
 }
 
-func NewServer(storage storage.Storage, templateRegistry *TemplateRegistry) *Server {
+func NewServer(config *config.Config, storage storage.Storage, templateRegistry *TemplateRegistry, assets *web.Assets) *Server {
 	s := new(Server)
+	s.config = config
 	s.storage = storage
 	s.templateRegistry = templateRegistry
+	s.assets = assets
 	s.echo = echo.New()
 	s.echo.Renderer = s.templateRegistry
-	s.renderCache = cache.New(1*time.Hour, 1*time.Minute)
+	s.renderCache = cache.New(
+		time.Duration(config.Cache.RenderTTL),
+		time.Duration(config.Cache.RenderCleanupInterval),
+	)
+	s.highlightCache = cache.New(
+		time.Duration(config.Cache.RenderTTL),
+		time.Duration(config.Cache.RenderCleanupInterval),
+	)
+	s.embedders = make(map[string][]string)
+
+	s.users = auth.NewUserStore(config.UsersFile())
+	// require credentials once users have been added (see `snote user`)
+	s.authMiddleware = middleware.BasicAuthWithConfig(middleware.BasicAuthConfig{
+		Skipper:   s.skipAuth,
+		Validator: s.authenticate,
+		Realm:     "snote",
+	})
 
 	// use the default echo json logger
-	s.echo.Use(middleware.Logger())
-	s.echo.Logger.SetLevel(log.INFO)
+	s.echo.Pre(middleware.Logger())
+	s.echo.Logger.SetLevel(logLevels[config.LogLevel])
+	s.echo.Use(s.authMiddleware)
+	// WebDAV uses methods (MKCOL, MOVE, LOCK, ...) that the echo router
+	// does not know, so its requests are dispatched before routing.
+	s.davHandler = echo.WrapHandler(dav.NewHandler("/dav", storage, s.invalidateRender))
+	s.echo.Pre(s.dav)
 
-	s.echo.Static("/static", "web/static")
-	s.echo.File("/favicon.ico", "web/static/favicon.ico")
+	s.echo.GET("/static/highlight.css", s.highlightCSSHandler)
+	s.echo.HEAD("/static/highlight.css", s.highlightCSSHandler)
+	s.echo.GET("/static/*", s.assetHandler)
+	s.echo.HEAD("/static/*", s.assetHandler)
+	s.echo.GET("/favicon.ico", func(c echo.Context) error {
+		return s.serveAsset(c, "favicon.ico")
+	})
 
 	s.setupRoutes()
 
 	return s
 }
 
+// ServeHTTP serves a request like the running server does, it lets s be
+// used as an http.Handler in tests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.echo.ServeHTTP(w, r)
+}
+
 func (s *Server) setupRoutes() {
 	// setup non-api (HTML) handlers
 	s.echo.GET("/", s.htmlIndexHandler)
+	s.echo.GET("/today", s.htmlTodayHandler)
+	s.echo.GET("/review", s.htmlReviewHandler)
+	s.echo.GET("/graph", s.htmlGraphHandler)
 	s.echo.GET("/:note_id", s.htmlNoteHandler)
 	s.echo.GET("/:note_id/edit", s.htmlNoteEditHandler)
+	s.echo.GET("/:note_id/export", s.noteExportHandler)
+	s.echo.GET("/:note_id/*", nestedNoteRoutes{
+		"":       s.htmlNoteHandler,
+		"edit":   s.htmlNoteEditHandler,
+		"export": s.noteExportHandler,
+	}.handler)
 	// setup api handlers
 	// note endpoints
 	s.echo.GET("/api/note/:note_id", s.noteGetHandler)
 	s.echo.PUT("/api/note/:note_id", s.notePutHandler)
 	s.echo.DELETE("/api/note/:note_id", s.noteDeleteHandler)
+	s.echo.GET("/api/note/:note_id/outline", s.noteOutlineGetHandler)
+	s.echo.GET("/api/note/:note_id/block/:block_id", s.noteBlockGetHandler)
+	s.echo.POST("/api/note/:note_id/task/:index/toggle", s.noteTaskTogglePostHandler)
+	s.echo.GET("/api/note/:note_id/*", nestedNoteRoutes{
+		"":                s.noteGetHandler,
+		"outline":         s.noteOutlineGetHandler,
+		"block/:block_id": s.noteBlockGetHandler,
+	}.handler)
+	s.echo.PUT("/api/note/:note_id/*", nestedNoteRoutes{"": s.notePutHandler}.handler)
+	s.echo.DELETE("/api/note/:note_id/*", nestedNoteRoutes{"": s.noteDeleteHandler}.handler)
+	s.echo.POST("/api/note/:note_id/*", nestedNoteRoutes{"task/:index/toggle": s.noteTaskTogglePostHandler}.handler)
+	s.echo.GET("/api/note", s.noteCollectionGetHandler)
 	s.echo.POST("/api/note", s.noteCollectionPostHandler)
+	s.echo.GET("/api/fields", s.fieldCollectionGetHandler)
+	s.echo.GET("/api/graph", s.graphGetHandler)
+	// flashcard endpoints
+	s.echo.GET("/api/review/next", s.reviewNextGetHandler)
+	s.echo.POST("/api/review/:card_id/grade", s.reviewGradePostHandler)
+	s.echo.GET("/api/review/export", s.reviewExportGetHandler)
 	// blob endpoints
-	s.echo.POST("/api/blob", s.blobCollectionPostHandler)
+	s.echo.POST("/api/blob", s.blobCollectionPostHandler, s.limitUploadSize)
 	s.echo.GET("/api/blob/:blob_id/:browser_filename", s.blobGetHandler)
+	// admin endpoints
+	s.echo.GET("/api/admin/fsck", s.fsckGetHandler, s.requireUsers)
+	s.echo.POST("/api/admin/fsck", s.fsckPostHandler, s.requireUsers)
+	s.echo.GET("/api/admin/export", s.exportGetHandler, s.requireUsers)
+	s.echo.POST("/api/admin/import", s.importPostHandler, s.requireUsers)
 
 }
 
+// nestedNoteRoutes maps the routes below a note (like "edit" of
+// /:note_id/edit) to their handlers, "" being the note itself.
+//
+// The :note_id parameter does not match the slashes of nested note IDs
+// (journal/2024-05-31), so the routes of such notes are matched by
+// /:note_id/* and dispatched by handler. The longest route matching the
+// end of the path wins, the rest of the path is the note ID.
+type nestedNoteRoutes map[string]echo.HandlerFunc
+
+func (routes nestedNoteRoutes) handler(c echo.Context) error {
+	segments := strings.Split(c.Param("note_id")+"/"+c.Param("*"), "/")
+	split := func(route string) []string {
+		return strings.FieldsFunc(route, func(r rune) bool { return r == '/' })
+	}
+
+	var matched string
+	var names, values []string
+	for route := range routes {
+		pattern := split(route)
+		// nested IDs have at least two segments
+		if names != nil && len(pattern) <= len(split(matched)) || len(segments)-len(pattern) < 2 {
+			continue
+		}
+		idLength := len(segments) - len(pattern)
+		routeNames := []string{"note_id"}
+		routeValues := []string{strings.Join(segments[:idLength], "/")}
+		for i, part := range pattern {
+			if strings.HasPrefix(part, ":") {
+				routeNames = append(routeNames, part[1:])
+				routeValues = append(routeValues, segments[idLength+i])
+			} else if part != segments[idLength+i] {
+				routeNames = nil
+				break
+			}
+		}
+		if routeNames != nil {
+			matched, names, values = route, routeNames, routeValues
+		}
+	}
+	if names == nil {
+		return echo.ErrNotFound
+	}
+	// echo keeps using the parameter values of the context for later
+	// requests and expects them to hold a value for every parameter of
+	// any route, so they are overwritten in place rather than replaced
+	pvalues := c.ParamValues()
+	pvalues = pvalues[:cap(pvalues)]
+	copy(pvalues, values)
+	c.SetParamNames(names...)
+	c.SetParamValues(pvalues...)
+	return routes[matched](c)
+}
+
 // parses all user-uploaded blobs from all notes and deletes blobs
 // from the storage if they are not referenced in any note.
 func (s *Server) deleteUnusedBlobs() {
-	usedBlobIDs := make([]string, 0)
-	allNoteIDs, err := s.storage.GetAllNoteIDs()
+	unusedBlobIDs, err := storage.UnusedBlobIDs(s.storage)
 	if err != nil {
 		s.echo.Logger.Error(err)
 		return
 	}
 
-	// collect all user-uploaded blob IDs from all notes in storage
-	for _, noteID := range allNoteIDs {
-		note, err := s.storage.LoadNote(noteID)
+	for _, blobID := range unusedBlobIDs {
+		err = s.storage.DeleteBlob(blobID)
 		if err != nil {
 			s.echo.Logger.Error(err)
 			return
 		}
-		noteBlobIDs := note.ParseBlobIDs()
-		usedBlobIDs = append(usedBlobIDs, noteBlobIDs...)
+		s.echo.Logger.Info("deleted blob:" + blobID)
 	}
+}
 
-	// if there are no user-uploaded blobs in any of the notes, return.
-	// also acts as a sanity check, if for some reason note loading/parsing
-	// failed (although all fail cases seem to be covered above), prevent
-	// deleting all of the blobs currently stored in storage.
-	if len(usedBlobIDs) == 0 {
+// checks the storage for inconsistencies and logs the problems found.
+func (s *Server) checkStorage() {
+	report, err := s.runFsck(s.config.Jobs.FsckRepair)
+	if err != nil {
+		s.echo.Logger.Error(err)
 		return
 	}
+	for _, problem := range report.Problems {
+		s.echo.Logger.Warn("fsck: " + problem.String())
+	}
+	s.echo.Logger.Infof("fsck: checked %d notes and %d blobs, %d problems found",
+		report.Notes, report.Blobs, len(report.Problems))
+}
 
-	// collect all blob IDs across all blobs currently stored in storage
-	allStorageBlobIDs, err := s.storage.GetAllBlobIDs()
+// runFsck checks the storage and keeps the report for the admin endpoint.
+func (s *Server) runFsck(repair bool) (*fsck.Report, error) {
+	s.fsckMutex.Lock()
+	defer s.fsckMutex.Unlock()
+	report, err := fsck.Check(s.storage, repair)
 	if err != nil {
-		s.echo.Logger.Error(err)
-		return
+		return nil, err
 	}
+	s.fsckReport = report
+	return report, nil
+}
 
-	// delete blobs that are not used, ie. that are present in allStorageBlobIDs
-	// but not present in usedBlobIDs.
-	for _, storageBlobID := range allStorageBlobIDs {
-		used := util.SliceContainsString(usedBlobIDs, storageBlobID)
-		if !used {
-			err = s.storage.DeleteBlob(storageBlobID)
-			if err != nil {
-				s.echo.Logger.Error(err)
-				return
-			}
-			s.echo.Logger.Info("deleted blob:" + storageBlobID)
+// ment to be run as a separate goroutine and do housekeeping tasks.
+// job is run every interval, forever.
+func runPeriodically(interval time.Duration, job func()) {
+	for {
+		time.Sleep(interval)
+		job()
+	}
+}
+
+// limitUploadSize rejects request bodies larger than the configured
+// maximum upload size.
+func (s *Server) limitUploadSize(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		maxSize := int64(s.config.Upload.MaxSize)
+		if c.Request().ContentLength > maxSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "upload exceeds "+s.config.Upload.MaxSize.String())
 		}
+		c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxSize)
+		return next(c)
 	}
+}
 
+// cacheRender stores the rendered HTML of a note in the render cache.
+// embedded are the IDs of the notes embedded in it, changing one of them
+// invalidates the rendered HTML too.
+func (s *Server) cacheRender(id string, html string, embedded []string) {
+	s.embeddersMutex.Lock()
+	for _, embeddedID := range embedded {
+		if !util.SliceContainsString(s.embedders[embeddedID], id) {
+			s.embedders[embeddedID] = append(s.embedders[embeddedID], id)
+		}
+	}
+	s.embeddersMutex.Unlock()
+	s.cacheSet(s.renderCache, id, html)
 }
 
-// ment to be run as a separate goroutine and do housekeeping tasks.
-func (s *Server) backgroundJobs() {
-	for {
-		time.Sleep(1 * time.Hour)
-		s.deleteUnusedBlobs()
+// invalidateRender drops the rendered HTML of the note with the given id
+// from the render cache, along with the renderings of all notes embedding
+// it, directly or not. It is called whenever a note changes.
+func (s *Server) invalidateRender(id string) {
+	s.embeddersMutex.Lock()
+	defer s.embeddersMutex.Unlock()
+	queue := []string{id}
+	for len(queue) > 0 {
+		id, queue = queue[0], queue[1:]
+		s.renderCache.Delete(id)
+		queue = append(queue, s.embedders[id]...)
+		// the embedding notes register again when they are rendered
+		delete(s.embedders, id)
+	}
+}
+
+// cacheSet stores value in c. go-cache has no size limit of its own,
+// so once the configured number of entries is reached, expired entries
+// are purged and if that does not help the whole cache is dropped.
+func (s *Server) cacheSet(c *cache.Cache, key string, value string) {
+	maxEntries := s.config.Cache.RenderMaxEntries
+	if maxEntries > 0 && c.ItemCount() >= maxEntries {
+		c.DeleteExpired()
+		if c.ItemCount() >= maxEntries {
+			c.Flush()
+		}
 	}
+	c.SetDefault(key, value)
+}
+
+// codeCache gives storage.Note.RenderHTMLCached access to the highlight cache.
+type codeCache struct {
+	s *Server
+}
+
+func (c codeCache) Get(key string) (string, bool) {
+	html, found := c.s.highlightCache.Get(key)
+	if !found {
+		return "", false
+	}
+	return html.(string), true
+}
+
+func (c codeCache) Set(key string, html string) {
+	c.s.cacheSet(c.s.highlightCache, key, html)
+}
+
+// skipAuth lets requests for static assets, and all requests to servers
+// without users, through without credentials.
+func (s *Server) skipAuth(c echo.Context) bool {
+	path := c.Request().URL.Path
+	if strings.HasPrefix(path, "/static/") || path == "/favicon.ico" {
+		return true
+	}
+	enabled, err := s.users.Enabled()
+	if err != nil {
+		s.echo.Logger.Error(err)
+		return false
+	}
+	return !enabled
+}
+
+// requireUsers refuses requests to servers without users, which would
+// otherwise let anyone export, import and repair the whole storage.
+func (s *Server) requireUsers(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		enabled, err := s.users.Enabled()
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if !enabled {
+			return echo.NewHTTPError(http.StatusForbidden, "admin endpoints require a user, add one with `snote user add`")
+		}
+		return next(c)
+	}
+}
+
+// dav serves the WebDAV view of the storage under /dav/.
+func (s *Server) dav(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		path := c.Request().URL.Path
+		if path != "/dav" && !strings.HasPrefix(path, "/dav/") {
+			return next(c)
+		}
+		return s.authMiddleware(s.davHandler)(c)
+	}
+}
+
+func (s *Server) authenticate(username string, password string, c echo.Context) (bool, error) {
+	return s.users.Authenticate(username, password)
+}
+
+var logLevels = map[string]log.Lvl{
+	"debug": log.DEBUG,
+	"info":  log.INFO,
+	"warn":  log.WARN,
+	"error": log.ERROR,
+	"off":   log.OFF,
 }
 
 func (s *Server) Run() {
 	// start background jobs
-	go s.backgroundJobs()
-	s.echo.Logger.Fatal(s.echo.Start(":8081"))
+	go runPeriodically(time.Duration(s.config.Jobs.BlobGCInterval), s.deleteUnusedBlobs)
+	if s.config.Jobs.FsckInterval > 0 {
+		go runPeriodically(time.Duration(s.config.Jobs.FsckInterval), s.checkStorage)
+	}
+	s.echo.Logger.Fatal(s.echo.Start(s.config.Listen))
 }
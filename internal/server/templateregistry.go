@@ -2,21 +2,47 @@ package server
 
 import (
 	"io"
+	"io/fs"
 	"text/template"
 
 	"github.com/labstack/echo"
+	"github.com/sbrki/snote/web"
 )
 
 type TemplateRegistry struct {
 	templates *template.Template
+	fsys      fs.FS
+	pattern   string
+	funcs     template.FuncMap
+	dev       bool
 }
 
-func NewTemplateRegistry(templatesPath string) *TemplateRegistry {
+// NewTemplateRegistry parses all templates in fsys matching pattern.
+// Templates can reference static files through the "static", "vendorScript"
+// and "vendorStyle" functions provided by assets.
+func NewTemplateRegistry(fsys fs.FS, pattern string, assets *web.Assets) *TemplateRegistry {
 	tr := new(TemplateRegistry)
-	tr.templates = template.Must(template.ParseGlob(templatesPath))
+	tr.fsys = fsys
+	tr.pattern = pattern
+	tr.dev = assets.Dev()
+	tr.funcs = web.TemplateFuncs(assets, func(id string) string { return "/" + id }, true)
+	tr.templates = template.Must(tr.parse())
 	return tr
 }
 
+func (tr *TemplateRegistry) parse() (*template.Template, error) {
+	return template.New("").Funcs(tr.funcs).ParseFS(tr.fsys, tr.pattern)
+}
+
 func (tr *TemplateRegistry) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	return tr.templates.ExecuteTemplate(w, name, data)
+	templates := tr.templates
+	if tr.dev {
+		// in dev mode, templates are re-read from disk on every render
+		parsed, err := tr.parse()
+		if err != nil {
+			return err
+		}
+		templates = parsed
+	}
+	return templates.ExecuteTemplate(w, name, data)
 }
@@ -0,0 +1,394 @@
+// Package site exports notes as a static website.
+//
+// Notes are rendered like the server renders them, with the preview
+// template, and written as <id>.html files. Links between exported notes
+// and to blobs are rewritten to relative paths, so the site can be opened
+// straight from the filesystem. The index and tag pages are the /ls and
+// /lstag notes, generated from the exported notes only.
+package site
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/internal/util"
+	"github.com/sbrki/snote/web"
+)
+
+type Options struct {
+	// Tag selects the notes to export (including the ones with descendant
+	// tags), all notes are exported if it is empty.
+	Tag string
+	// Out is the output directory.
+	Out string
+	// Templates holds the templates/*.html files.
+	Templates fs.FS
+	// Assets are copied to static/ in the output directory.
+	Assets *web.Assets
+	// HighlightStyle is the theme of highlighted code, written to
+	// static/highlight.css. Defaults to storage.DefaultHighlightStyle.
+	HighlightStyle string
+}
+
+// Problem is a link that could not be rewritten.
+type Problem struct {
+	Note    string `json:"note"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return p.Note + ": " + p.Message
+}
+
+type Result struct {
+	Notes    int       `json:"notes"`
+	Blobs    int       `json:"blobs"`
+	Problems []Problem `json:"problems"`
+}
+
+type exporter struct {
+	storage  storage.Storage
+	options  Options
+	result   *Result
+	exported []string
+	// copiedBlobs are the blob URLs that were already copied.
+	copiedBlobs map[string]bool
+	// root is the relative path from the page being rendered to the output directory.
+	root string
+	// templates are parsed once for every root, the static
+	// file URLs they produce are relative to it.
+	templates map[string]*template.Template
+}
+
+// Export writes the selected notes of st to options.Out.
+func Export(st storage.Storage, options Options) (*Result, error) {
+	e := &exporter{
+		storage:     st,
+		options:     options,
+		result:      &Result{Problems: []Problem{}},
+		copiedBlobs: make(map[string]bool),
+		templates:   make(map[string]*template.Template),
+	}
+
+	if err := e.selectNotes(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(options.Out, 0755); err != nil {
+		return nil, err
+	}
+	view := &filteredStorage{st, e.exported}
+
+	for _, id := range e.exported {
+		note, err := st.LoadNote(id)
+		if err != nil {
+			return nil, fmt.Errorf("note %s: %v", id, err)
+		}
+		if err := e.writePage(note); err != nil {
+			return nil, fmt.Errorf("note %s: %v", id, err)
+		}
+		e.result.Notes++
+	}
+
+	ls := new(storage.Note)
+	if err := ls.GenerateLs(view); err != nil {
+		return nil, err
+	}
+	if err := e.writePage(ls); err != nil {
+		return nil, err
+	}
+	lsTag := new(storage.Note)
+	if err := lsTag.GenerateLsTag(view); err != nil {
+		return nil, err
+	}
+	if err := e.writePage(lsTag); err != nil {
+		return nil, err
+	}
+	// the journal entries link to the calendar
+	if len(storage.JournalEntries(e.exported)) > 0 {
+		lsJournal := new(storage.Note)
+		if err := lsJournal.GenerateLsJournal(view); err != nil {
+			return nil, err
+		}
+		if err := e.writePage(lsJournal); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := copyFS(options.Assets.FS(), filepath.Join(options.Out, "static")); err != nil {
+		return nil, err
+	}
+	highlightStyle := options.HighlightStyle
+	if highlightStyle == "" {
+		highlightStyle = storage.DefaultHighlightStyle
+	}
+	css, err := storage.HighlightCSS(highlightStyle)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(filepath.Join(options.Out, "static", "highlight.css"), strings.NewReader(css)); err != nil {
+		return nil, err
+	}
+	return e.result, nil
+}
+
+// selectNotes collects the IDs of the notes to export.
+func (e *exporter) selectNotes() error {
+	if e.options.Tag == "" {
+		noteIDs, err := e.storage.GetAllNoteIDs()
+		if err != nil {
+			return err
+		}
+		e.exported = noteIDs
+	} else {
+		tagIndex, err := e.storage.GetAllNoteTags()
+		if err != nil {
+			return err
+		}
+		e.exported = storage.TaggedNoteIDs(tagIndex, e.options.Tag)
+	}
+	for _, id := range storage.AutogeneratedNoteIDs {
+		util.SliceRemoveString(&e.exported, id)
+	}
+	sort.Strings(e.exported)
+	return nil
+}
+
+// pageURL returns the relative link from the current page to the page of a note.
+func (e *exporter) pageURL(id string) string {
+	segments := strings.Split(pageName(id), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return e.root + strings.Join(segments, "/")
+}
+
+// pageName is the file name of the page of a note, the index takes the place of /ls.
+func pageName(id string) string {
+	if id == "ls" {
+		return "index.html"
+	}
+	return id + ".html"
+}
+
+func (e *exporter) writePage(note *storage.Note) error {
+	e.root = strings.Repeat("../", strings.Count(note.ID, "/"))
+	// only exported notes are embedded, the others are linked to
+	rendered, dependencies := note.RenderHTMLEmbedding(&filteredStorage{e.storage, e.exported}, nil)
+	for _, id := range dependencies {
+		if !util.SliceContainsString(e.exported, id) {
+			e.problem(note.ID, "embeds or links to a block of %s, which is not exported", id)
+		}
+	}
+	html := e.rewriteLinks(note.ID, storage.JournalNavigation(e.exported, note.ID)+rendered)
+
+	templates, found := e.templates[e.root]
+	if !found {
+		assets := e.options.Assets.Relative(e.root + "static/")
+		funcs := web.TemplateFuncs(assets, e.pageURL, false)
+		parsed, err := template.New("").Funcs(funcs).ParseFS(e.options.Templates, "templates/*.html")
+		if err != nil {
+			return err
+		}
+		templates = parsed
+		e.templates[e.root] = templates
+	}
+
+	b := new(bytes.Buffer)
+	err := templates.ExecuteTemplate(b, "preview.html", struct {
+		RenderedHTML string
+		ID           string
+	}{html, note.ID})
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(e.options.Out, filepath.FromSlash(pageName(note.ID)))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, b.Bytes(), 0644)
+}
+
+var linkAttribute = regexp.MustCompile(`(href|src)="(/[^/"][^"]*)"`)
+
+// rewriteLinks rewrites the server-absolute links in the rendered html of
+// a note to relative ones, copying the blobs that are linked to.
+func (e *exporter) rewriteLinks(noteID string, html string) string {
+	return linkAttribute.ReplaceAllStringFunc(html, func(m string) string {
+		parts := linkAttribute.FindStringSubmatch(m)
+		attribute, link := parts[1], parts[2]
+		if rewritten, ok := e.rewriteLink(noteID, link); ok {
+			return attribute + `="` + rewritten + `"`
+		}
+		return m
+	})
+}
+
+func (e *exporter) rewriteLink(noteID string, link string) (string, bool) {
+	target, fragment := link, ""
+	if i := strings.Index(target, "#"); i >= 0 {
+		target, fragment = target[:i], target[i:]
+	}
+	if i := strings.Index(target, "?"); i >= 0 {
+		target = target[:i]
+	}
+
+	if strings.HasPrefix(target, "/api/blob/") {
+		blobPath, err := e.copyBlob(target)
+		if err != nil {
+			e.problem(noteID, "blob %s: %v", link, err)
+			return "", false
+		}
+		return e.root + blobPath, true
+	}
+
+	id, err := url.PathUnescape(strings.TrimPrefix(target, "/"))
+	if err != nil {
+		e.problem(noteID, "invalid link %s", link)
+		return "", false
+	}
+	if id == "" {
+		id = "ls"
+	}
+	if !storage.IsAutogenerated(id) && !util.SliceContainsString(e.exported, id) {
+		e.problem(noteID, "link to %s, which is not exported", link)
+		return "", false
+	}
+	return e.pageURL(id) + fragment, true
+}
+
+// copyBlob copies the blob that the /api/blob/<id>/<name> URL points to
+// and returns its path relative to the output directory.
+func (e *exporter) copyBlob(blobURL string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(blobURL, "/api/blob/"), "/", 2)
+	blobID := parts[0]
+	name := blobID
+	if len(parts) == 2 && parts[1] != "" {
+		unescaped, err := url.PathUnescape(parts[1])
+		if err != nil {
+			return "", err
+		}
+		name = path.Base(unescaped)
+	}
+	if !fs.ValidPath(blobID) || !fs.ValidPath(name) || strings.Contains(blobID, "/") {
+		return "", errors.New("invalid blob url")
+	}
+	blobPath := "blobs/" + blobID + "/" + name
+	if !e.copiedBlobs[blobPath] {
+		src, err := e.storage.LoadBlobPath(blobID)
+		if err != nil {
+			return "", err
+		}
+		if err := copyFile(src, filepath.Join(e.options.Out, filepath.FromSlash(blobPath))); err != nil {
+			return "", err
+		}
+		e.copiedBlobs[blobPath] = true
+		e.result.Blobs++
+	}
+	return "blobs/" + blobID + "/" + url.PathEscape(name), nil
+}
+
+func (e *exporter) problem(noteID string, format string, a ...interface{}) {
+	e.result.Problems = append(e.result.Problems, Problem{noteID, fmt.Sprintf(format, a...)})
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(dst, in)
+}
+
+func writeFile(dst string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyFS copies all files of fsys to the directory dst.
+func copyFS(fsys fs.FS, dst string) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return writeFile(filepath.Join(dst, filepath.FromSlash(name)), f)
+	})
+}
+
+// filteredStorage is a view of a storage containing only some of its notes.
+// It is used to generate the index and tag pages of the exported notes, and
+// to render notes without embedding the notes that are not exported.
+type filteredStorage struct {
+	storage.Storage
+	noteIDs []string
+}
+
+func (v *filteredStorage) GetAllNoteIDs() ([]string, error) {
+	return v.noteIDs, nil
+}
+
+func (v *filteredStorage) LoadNote(id string) (*storage.Note, error) {
+	if !util.SliceContainsString(v.noteIDs, id) {
+		return nil, fmt.Errorf("note %s: %w", id, storage.ErrNotEmbeddable)
+	}
+	return v.Storage.LoadNote(id)
+}
+
+func (v *filteredStorage) GetAllNoteBlocks() (map[string][]string, error) {
+	blockIndex, err := v.Storage.GetAllNoteBlocks()
+	if err != nil {
+		return nil, err
+	}
+	filtered := make(map[string][]string)
+	for blockID, noteIDs := range blockIndex {
+		for _, noteID := range noteIDs {
+			if util.SliceContainsString(v.noteIDs, noteID) {
+				filtered[blockID] = append(filtered[blockID], noteID)
+			}
+		}
+	}
+	return filtered, nil
+}
+
+func (v *filteredStorage) GetAllNoteTags() (map[string][]string, error) {
+	tagIndex, err := v.Storage.GetAllNoteTags()
+	if err != nil {
+		return nil, err
+	}
+	filtered := make(map[string][]string)
+	for tag, noteIDs := range tagIndex {
+		for _, noteID := range noteIDs {
+			if util.SliceContainsString(v.noteIDs, noteID) {
+				filtered[tag] = append(filtered[tag], noteID)
+			}
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,89 @@
+package site
+
+import (
+	"bytes"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sbrki/snote/internal/storage"
+	"github.com/sbrki/snote/web"
+)
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "storage"), 0755)
+	st := storage.NewDiskStorage(filepath.Join(dir, "storage"))
+	for _, note := range []*storage.Note{
+		{ID: "guide", Contents: "# Guide\n`tags: public`\n\nSee [setup](/setup#install), [secret](/secret) and [all](/ls).\n\n![logo](/api/blob/abc/logo%20big.png)\n\n![[setup]]\n\n![[secret]]"},
+		{ID: "setup", Contents: "# Setup\n`tags: public`\n\nBack to [guide](/guide)."},
+		{ID: "secret", Contents: "# Secret\n`tags: private`\n\nThe password."},
+	} {
+		if err := storage.UpdateNote(st, note); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.SaveBlob("abc", *bytes.NewBufferString("png")); err != nil {
+		t.Fatal(err)
+	}
+
+	staticFS, _ := fs.Sub(web.FS(""), "static")
+	assets, err := web.NewAssets(staticFS, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "site")
+	result, err := Export(st, Options{Tag: "public", Out: out, Templates: web.FS(""), Assets: assets})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the link to secret, the link of its embed and the embed itself
+	if result.Notes != 2 || result.Blobs != 1 || len(result.Problems) != 3 {
+		t.Error("wrong result:", result)
+	}
+
+	read := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	guide := read("guide.html")
+	for _, expected := range []string{
+		`href="setup.html#install"`,
+		`href="/secret"`,
+		`href="index.html"`,
+		`src="blobs/abc/logo%20big.png"`,
+		`href="static/style.css"`,
+	} {
+		if !strings.Contains(guide, expected) {
+			t.Errorf("%s not found in guide.html", expected)
+		}
+	}
+	if !strings.Contains(guide, "Back to") || strings.Contains(guide, "The password") {
+		t.Error("embeds not limited to the exported notes:", guide)
+	}
+	if strings.Contains(guide, "/guide/edit") {
+		t.Error("static page links to the editor")
+	}
+	if read("blobs/abc/logo big.png") != "png" {
+		t.Error("blob not copied")
+	}
+	read("static/style.css")
+
+	index := read("index.html")
+	if !strings.Contains(index, `href="setup.html"`) || strings.Contains(index, "secret") {
+		t.Error("index does not list exactly the exported notes")
+	}
+	lsTag := read("lstag.html")
+	if !strings.Contains(lsTag, "public") || strings.Contains(lsTag, "private") {
+		t.Error("tag page does not list exactly the exported tags")
+	}
+	if _, err := os.Stat(filepath.Join(out, "secret.html")); err == nil {
+		t.Error("unselected note exported")
+	}
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"html"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// Block is a paragraph or a list item marked with a ^block-id at its end,
+// which can be linked to ([[note#^block-id]]) and embedded on its own.
+type Block struct {
+	ID     string `json:"id"`
+	NoteID string `json:"note_id"`
+	// Line is the 1-based line of the marker in the contents of the note.
+	Line int `json:"line"`
+	// Markdown is the source of the block, without the marker.
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html,omitempty"`
+}
+
+var (
+	// blockMarker matches a ^block-id at the end of a line.
+	blockMarker = regexp.MustCompile(`(?:^|\s)\^([A-Za-z0-9-]+)\s*$`)
+	// listItemStart matches the first line of a list item.
+	listItemStart = regexp.MustCompile(`^\s*(?:>\s*)*(?:[-*+]|\d+[.)])\s`)
+	// headingLine matches ATX headings.
+	headingLine = regexp.MustCompile(`^ {0,3}#{1,6}(?:\s|$)`)
+	// wikiLink matches [[note]], [[note#Heading]], [[note#^block-id]] and
+	// [[note|label]]. The note is left out to link within the note.
+	wikiLink = regexp.MustCompile(`\[\[([^\]#|\n]*)(?:#([^\]|\n]+))?(?:\|([^\]\n]+))?\]\]`)
+)
+
+// blockAnchor is the id of a block in the rendered note.
+func blockAnchor(id string) string {
+	return "block-" + id
+}
+
+// ParseBlocks returns the blocks of the note in order. Headings are not
+// blocks, they have anchors of their own. When a block ID is repeated,
+// the first block wins.
+func (note *Note) ParseBlocks() []Block {
+	lines := strings.Split(note.Contents, "\n")
+	prose := make(map[int]bool)
+	for _, i := range proseLines(lines) {
+		prose[i] = true
+	}
+	blank := func(i int) bool {
+		return i >= len(lines) || strings.TrimSpace(lines[i]) == ""
+	}
+
+	blocks := make([]Block, 0)
+	seen := make(map[string]bool)
+	for i, line := range lines {
+		m := blockMarker.FindStringSubmatchIndex(line)
+		if !prose[i] || m == nil || headingLine.MatchString(line) {
+			continue
+		}
+		// the marker ends the paragraph, or the list item when the next item follows
+		if !blank(i+1) && prose[i+1] && !listItemStart.MatchString(lines[i+1]) && !headingLine.MatchString(lines[i+1]) {
+			continue
+		}
+		start := i
+		for start > 0 && prose[start-1] && !blank(start-1) && !listItemStart.MatchString(lines[start]) {
+			start--
+		}
+		id := line[m[2]:m[3]]
+		source := append(append([]string{}, lines[start:i]...), strings.TrimRight(line[:m[0]], " \t"))
+		markdown := strings.Join(source, "\n")
+		if strings.TrimSpace(markdown) == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		blocks = append(blocks, Block{ID: id, NoteID: note.ID, Line: i + 1, Markdown: markdown})
+	}
+	return blocks
+}
+
+// ParseBlockIDs returns the IDs of the blocks of the note.
+func (note *Note) ParseBlockIDs() []string {
+	blockIDs := make([]string, 0)
+	for _, block := range note.ParseBlocks() {
+		blockIDs = append(blockIDs, block.ID)
+	}
+	return blockIDs
+}
+
+// assignBlockIDs strips the block markers at the end of paragraphs and
+// gives the paragraphs, or the list items they start, the anchors of their
+// blocks. It returns the marked nodes by block ID.
+func assignBlockIDs(rootNode ast.Node) map[string]ast.Node {
+	paragraphs := make([]*ast.Paragraph, 0)
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		if n, ok := node.(*ast.Paragraph); ok && entering {
+			paragraphs = append(paragraphs, n)
+		}
+		return ast.GoToNext
+	})
+
+	blocks := make(map[string]ast.Node)
+	for _, paragraph := range paragraphs {
+		if len(paragraph.Children) == 0 {
+			continue
+		}
+		text, ok := paragraph.Children[len(paragraph.Children)-1].(*ast.Text)
+		if !ok {
+			continue
+		}
+		m := blockMarker.FindSubmatchIndex(text.Literal)
+		if m == nil || m[0] == 0 && len(paragraph.Children) == 1 {
+			continue
+		}
+		id := string(text.Literal[m[2]:m[3]])
+		text.Literal = text.Literal[:m[0]]
+		if _, found := blocks[id]; found {
+			continue
+		}
+
+		var block ast.Node = paragraph
+		if item, ok := paragraph.Parent.(*ast.ListItem); ok && item.Children[0] == paragraph {
+			block = item
+		}
+		block.AsContainer().Attribute = &ast.Attribute{ID: []byte(blockAnchor(id))}
+		blocks[id] = block
+	}
+	return blocks
+}
+
+// blockHook is a render hook that gives list items that are blocks their
+// anchor. The html renderer writes the anchors of paragraphs itself.
+func blockHook(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	item, ok := node.(*ast.ListItem)
+	if !ok || !entering || item.Attribute == nil || item.RefLink != nil ||
+		item.ListFlags&(ast.ListTypeDefinition|ast.ListTypeTerm) != 0 {
+		return ast.GoToNext, false
+	}
+	if list, ok := item.Parent.(*ast.List); ok && !list.Tight && ast.GetPrevNode(item) != nil {
+		io.WriteString(w, "\n")
+	}
+	io.WriteString(w, `<li id="`+html.EscapeString(string(item.Attribute.ID))+`">`)
+	return ast.GoToNext, true
+}
+
+// blockIndexer is implemented by loaders that know which notes hold which
+// blocks, like Storage.
+type blockIndexer interface {
+	GetAllNoteBlocks() (map[string][]string, error)
+}
+
+// resolveBlock returns the ID of the note holding the block: noteID, or
+// the note the block was moved to if the block index knows it.
+func (r *noteRenderer) resolveBlock(noteID string, blockID string) string {
+	if r.loader == nil {
+		return noteID
+	}
+	if note, err := r.loader.LoadNote(noteID); err == nil {
+		for _, block := range note.ParseBlocks() {
+			if block.ID == blockID {
+				return noteID
+			}
+		}
+	}
+	indexer, ok := r.loader.(blockIndexer)
+	if !ok {
+		return noteID
+	}
+	blockIndex, err := indexer.GetAllNoteBlocks()
+	if err != nil || len(blockIndex[blockID]) == 0 {
+		return noteID
+	}
+	moved := blockIndex[blockID][0]
+	r.depend(moved)
+	return moved
+}
+
+// addWikiLinks turns the [[wiki links]] below rootNode into links. Links
+// to blocks follow the blocks to the notes they were moved to. noteID is
+// the ID of the note the links are in.
+func (r *noteRenderer) addWikiLinks(rootNode ast.Node, noteID string) {
+	texts := make([]*ast.Text, 0)
+	inLink := 0
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch n := node.(type) {
+		case *ast.Link:
+			if entering {
+				inLink++
+			} else {
+				inLink--
+			}
+		case *ast.Text:
+			if entering && inLink == 0 && wikiLink.Match(n.Literal) {
+				texts = append(texts, n)
+			}
+		}
+		return ast.GoToNext
+	})
+
+	for _, text := range texts {
+		literal := text.Literal
+		last := 0
+		for _, m := range wikiLink.FindAllSubmatchIndex(literal, -1) {
+			before := &ast.Text{}
+			before.Literal = literal[last:m[0]]
+			link := &ast.HTMLSpan{}
+			link.Literal = []byte(r.wikiLinkHTML(literal, m, noteID))
+			insertBefore(text, before)
+			insertBefore(text, link)
+			last = m[1]
+		}
+		text.Literal = literal[last:]
+	}
+}
+
+// wikiLinkHTML returns the html of the wiki link matched at m in literal.
+func (r *noteRenderer) wikiLinkHTML(literal []byte, m []int, noteID string) string {
+	target := strings.TrimSpace(string(literal[m[2]:m[3]]))
+	section := ""
+	if m[4] >= 0 {
+		section = strings.TrimSpace(string(literal[m[4]:m[5]]))
+	}
+	label := string(literal[m[0]+2 : m[1]-2])
+	if m[6] >= 0 {
+		label = strings.TrimSpace(string(literal[m[6]:m[7]]))
+	}
+
+	href := sectionAnchor(section)
+	if target != "" && target != noteID {
+		if strings.HasPrefix(section, "^") {
+			r.depend(target)
+			target = r.resolveBlock(target, section[1:])
+		}
+		href = "/" + target + href
+	}
+	return `<a class="wikilink" href="` + html.EscapeString(href) + `">` + html.EscapeString(label) + "</a>"
+}
+
+// RenderBlock returns the block of the note with the given ID, with its
+// html rendered like an embedded block.
+func (note *Note) RenderBlock(loader NoteLoader, blockID string, codeCache CodeCache) (Block, bool) {
+	for _, block := range note.ParseBlocks() {
+		if block.ID != blockID {
+			continue
+		}
+		r := newNoteRenderer(loader, codeCache)
+		rendered, err := r.renderSection(note, "^"+blockID, []string{note.ID})
+		if err != nil {
+			return Block{}, false
+		}
+		block.HTML = rendered
+		return block, true
+	}
+	return Block{}, false
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const blockNote = "# Plan ^not-a-block\n\nWater the roses\nevery morning ^water\n\n- buy seeds\n  in spring ^seeds\n- rake\n\n" +
+	"```\nfenced ^fenced\n```\n\nrepeated ^water\n"
+
+// blockMap is a noteMap that also knows the block index.
+type blockMap struct {
+	noteMap
+	blockIndex map[string][]string
+}
+
+func (m blockMap) GetAllNoteBlocks() (map[string][]string, error) {
+	return m.blockIndex, nil
+}
+
+func TestParseBlocks(t *testing.T) {
+	blocks := (&Note{ID: "plan", Contents: blockNote}).ParseBlocks()
+	expected := []Block{
+		{ID: "water", NoteID: "plan", Line: 4, Markdown: "Water the roses\nevery morning"},
+		{ID: "seeds", NoteID: "plan", Line: 7, Markdown: "- buy seeds\n  in spring"},
+	}
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("wrong blocks: %+v", blocks)
+	}
+}
+
+func TestRenderBlocks(t *testing.T) {
+	html := (&Note{ID: "plan", Contents: blockNote}).RenderHTML()
+	for _, expected := range []string{
+		"<p id=\"block-water\">Water the roses\nevery morning</p>",
+		`<li id="block-seeds">`,
+		"<p>repeated</p>",
+		"fenced ^fenced",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("missing %q in %s", expected, html)
+		}
+	}
+}
+
+func TestBlockLinks(t *testing.T) {
+	notes := blockMap{
+		noteMap: noteMap{
+			"plan":    blockNote,
+			"archive": "Old notes\n\nmulch the beds ^mulch\n",
+			"page":    "See [[plan#^water]], [[plan#^mulch|mulching]], [[#Intro]] and [[plan]].\n\n![[plan#^seeds]]\n",
+		},
+		blockIndex: map[string][]string{"water": {"plan"}, "seeds": {"plan"}, "mulch": {"archive"}},
+	}
+	html, dependencies := (&Note{ID: "page", Contents: notes.noteMap["page"]}).RenderHTMLEmbedding(notes, nil)
+	for _, expected := range []string{
+		`<a class="wikilink" href="/plan#block-water">plan#^water</a>`,
+		`<a class="wikilink" href="/archive#block-mulch">mulching</a>`,
+		`<a class="wikilink" href="#intro">#Intro</a>`,
+		`<a class="wikilink" href="/plan">plan</a>`,
+		`<a class="embed-source" href="/plan#block-seeds">![[plan#^seeds]]</a>`,
+		"<ul>\n<li>buy seeds\nin spring</li>\n</ul>",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("missing %q in %s", expected, html)
+		}
+	}
+	if strings.Contains(html, "rake") || strings.Contains(html, "id=\"block-seeds\"") {
+		t.Error("wrong block embedded:", html)
+	}
+	if !reflect.DeepEqual(dependencies, []string{"plan", "archive"}) {
+		t.Error("wrong dependencies:", dependencies)
+	}
+
+	block, found := (&Note{ID: "archive", Contents: notes.noteMap["archive"]}).RenderBlock(notes, "mulch", nil)
+	if !found || block.Line != 3 || block.Markdown != "mulch the beds" || block.HTML != "<p>mulch the beds</p>\n" {
+		t.Errorf("wrong block: %+v", block)
+	}
+	if _, found := (&Note{ID: "plan", Contents: blockNote}).RenderBlock(notes, "mulch", nil); found {
+		t.Error("block of another note found")
+	}
+}
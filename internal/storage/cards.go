@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Card is a flashcard parsed from a note: a "Q:" line followed by an "A:"
+// line, or a "term :: definition" line.
+type Card struct {
+	ID     string `json:"id"`
+	NoteID string `json:"note_id"`
+	// Line is the 1-based line of the question in the contents of the note.
+	Line     int    `json:"line"`
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+	Review   Review `json:"review"`
+}
+
+// Review is the review state of a card, scheduled with SM-2. The zero
+// Review is the state of a card that was never reviewed, which is due.
+type Review struct {
+	// Repetitions is the number of successful reviews in a row.
+	Repetitions int `json:"repetitions"`
+	// Interval is the number of days between the last and the next review.
+	Interval int `json:"interval"`
+	// Ease is the easiness factor, 0 stands for the initial DefaultEase.
+	Ease       float64   `json:"ease"`
+	Due        time.Time `json:"due"`
+	LastReview time.Time `json:"last_review"`
+}
+
+const (
+	// DefaultEase is the easiness factor of new cards.
+	DefaultEase = 2.5
+	// MinEase is the lowest easiness factor of a card.
+	MinEase = 1.3
+	// MaxGrade is the grade of a perfect recall, grades below 3 are failures.
+	MaxGrade = 5
+)
+
+var (
+	// questionLine and answerLine match "Q: ..." and "A: ...", also as list items.
+	questionLine = regexp.MustCompile(`^\s*(?:[-*+]\s+)?Q:\s*(.*)$`)
+	answerLine   = regexp.MustCompile(`^\s*(?:[-*+]\s+)?A:\s*(.*)$`)
+	// definitionLine matches "term :: definition", also as a list item.
+	definitionLine = regexp.MustCompile(`^\s*(?:[-*+]\s+)?(\S.*?)\s+::\s+(\S.*?)\s*$`)
+)
+
+// cardID derives the ID of a card from its note and its question, so
+// that cards keep their review state when their answers are edited.
+func cardID(noteID string, question string) string {
+	sum := sha256.Sum256([]byte(noteID + "\n" + question))
+	return hex.EncodeToString(sum[:6])
+}
+
+// IndexedCards returns the flashcards of the note kept in the card index:
+// those of ParseCards, or none for templates, which are not reviewed.
+func (note *Note) IndexedCards() []Card {
+	if note.IsTemplate() {
+		return []Card{}
+	}
+	return note.ParseCards()
+}
+
+// ParseCards returns the flashcards of the note in order. Questions and
+// answers span the lines up to the next blank line, an answer also ends
+// at the next question. Cards in code blocks and front matter are ignored,
+// as are repeated questions.
+func (note *Note) ParseCards() []Card {
+	lines := strings.Split(note.Contents, "\n")
+	prose := make(map[int]bool)
+	for _, i := range proseLines(lines) {
+		prose[i] = true
+	}
+	// continued returns the lines following line i up to a blank line or
+	// a line that stop matches
+	continued := func(i int, stop func(line string) bool) []string {
+		result := make([]string, 0)
+		for j := i + 1; j < len(lines) && prose[j] && strings.TrimSpace(lines[j]) != "" && !stop(lines[j]); j++ {
+			result = append(result, strings.TrimSpace(lines[j]))
+		}
+		return result
+	}
+
+	cards := make([]Card, 0)
+	seen := make(map[string]bool)
+	add := func(line int, question string, answer string) {
+		id := cardID(note.ID, question)
+		if question == "" || answer == "" || seen[id] {
+			return
+		}
+		seen[id] = true
+		cards = append(cards, Card{ID: id, NoteID: note.ID, Line: line + 1, Question: question, Answer: answer})
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if !prose[i] {
+			continue
+		}
+		if m := questionLine.FindStringSubmatch(line); m != nil {
+			question := append([]string{strings.TrimSpace(m[1])}, continued(i, answerLine.MatchString)...)
+			a := i + len(question)
+			if a >= len(lines) || !prose[a] {
+				continue
+			}
+			if m := answerLine.FindStringSubmatch(lines[a]); m != nil {
+				answer := append([]string{strings.TrimSpace(m[1])}, continued(a, questionLine.MatchString)...)
+				add(i, strings.TrimSpace(strings.Join(question, "\n")), strings.TrimSpace(strings.Join(answer, "\n")))
+				// the lines of the answer are no cards of their own
+				i = a + len(answer) - 1
+			}
+		} else if m := definitionLine.FindStringSubmatch(line); m != nil && !headingLine.MatchString(line) {
+			add(i, m[1], m[2])
+		}
+	}
+	return cards
+}
+
+// Grade records a review of the card at now, graded from 0 (forgotten) to
+// MaxGrade (perfect recall), and schedules the next review following SM-2.
+func (review *Review) Grade(grade int, now time.Time) {
+	if review.Ease == 0 {
+		review.Ease = DefaultEase
+	}
+	if grade < 3 {
+		// forgotten cards are learned again from the start
+		review.Repetitions = 0
+		review.Interval = 1
+	} else {
+		review.Repetitions++
+		switch review.Repetitions {
+		case 1:
+			review.Interval = 1
+		case 2:
+			review.Interval = 6
+		default:
+			review.Interval = int(math.Round(float64(review.Interval) * review.Ease))
+		}
+	}
+	q := float64(MaxGrade - grade)
+	review.Ease = math.Max(MinEase, review.Ease+0.1-q*(0.08+q*0.02))
+	review.LastReview = now
+	review.Due = now.AddDate(0, 0, review.Interval)
+}
+
+// IsDue reports whether the card is due for review at now.
+func (review *Review) IsDue(now time.Time) bool {
+	return !review.Due.After(now)
+}
+
+// DueCards returns the cards of cards that are due at now, the ones due
+// first at the front. Cards that were never reviewed come first, in the
+// order of their notes.
+func DueCards(cards map[string]Card, now time.Time) []Card {
+	due := make([]Card, 0)
+	for _, card := range cards {
+		if card.Review.IsDue(now) {
+			due = append(due, card)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		a, b := due[i], due[j]
+		switch {
+		case !a.Review.Due.Equal(b.Review.Due):
+			return a.Review.Due.Before(b.Review.Due)
+		case a.NoteID != b.NoteID:
+			return a.NoteID < b.NoteID
+		default:
+			return a.Line < b.Line
+		}
+	})
+	return due
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCards(t *testing.T) {
+	note := &Note{ID: "go", Contents: "# Go\n\nQ: Who designed\nGo?\nA: Griesemer, Pike\nand Thompson\nQ: When?\nA: 2007\n\n" +
+		"- goroutine :: a lightweight thread\n## Syntax :: not a card\n\n```\nQ: in code\nA: ignored\n```\n\nQ: no answer\n\ngoroutine :: again\n"}
+	cards := note.ParseCards()
+	expected := []Card{
+		{NoteID: "go", Line: 3, Question: "Who designed\nGo?", Answer: "Griesemer, Pike\nand Thompson"},
+		{NoteID: "go", Line: 7, Question: "When?", Answer: "2007"},
+		{NoteID: "go", Line: 10, Question: "goroutine", Answer: "a lightweight thread"},
+	}
+	if len(cards) != len(expected) {
+		t.Fatal("wrong cards:", cards)
+	}
+	for i, card := range cards {
+		expected[i].ID = cardID("go", expected[i].Question)
+		if card != expected[i] {
+			t.Errorf("wrong card %d: %+v", i, card)
+		}
+	}
+}
+
+func TestGrade(t *testing.T) {
+	now := time.Date(2024, 5, 31, 9, 0, 0, 0, time.UTC)
+	review := new(Review)
+	if !review.IsDue(now) {
+		t.Error("new card not due")
+	}
+	for _, step := range []struct {
+		grade    int
+		interval int
+		ease     float64
+	}{
+		{4, 1, 2.5},
+		{5, 6, 2.6},
+		{3, 16, 2.46},
+		{1, 1, 1.92},
+		{4, 1, 1.92},
+	} {
+		review.Grade(step.grade, now)
+		if review.Interval != step.interval || review.Ease < step.ease-1e-9 || review.Ease > step.ease+1e-9 {
+			t.Errorf("wrong schedule after grade %d: %+v", step.grade, review)
+		}
+		if !review.Due.Equal(now.AddDate(0, 0, step.interval)) || review.IsDue(now) {
+			t.Error("wrong due date:", review.Due)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		review.Grade(0, now)
+	}
+	if review.Ease != MinEase {
+		t.Error("ease below the minimum:", review.Ease)
+	}
+}
+
+func TestCardIndex(t *testing.T) {
+	st := NewDiskStorage(t.TempDir())
+
+	now := time.Now()
+	if err := UpdateNote(st, &Note{ID: "a", Contents: "# A\n\nQ: one\nA: 1\n\ntwo :: 2\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateNote(st, &Note{ID: "b", Contents: "# B\n\nthree :: 3\n"}); err != nil {
+		t.Fatal(err)
+	}
+	// the cards of templates are not reviewed
+	if err := UpdateNote(st, &Note{ID: "t", Contents: "---\ntemplate: true\n---\n# T\n\nfour :: 4\n"}); err != nil {
+		t.Fatal(err)
+	}
+	cards, err := st.GetAllCards()
+	if err != nil || len(cards) != 3 {
+		t.Fatal("wrong cards:", cards, err)
+	}
+	due := DueCards(cards, now)
+	if len(due) != 3 || due[0].Question != "one" || due[2].Question != "three" {
+		t.Error("wrong due cards:", due)
+	}
+
+	one := due[0]
+	one.Review.Grade(MaxGrade, now)
+	if err := st.SetCardReview(one.ID, one.Review); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetCardReview("unknown", one.Review); err == nil {
+		t.Error("review of an unknown card set")
+	}
+
+	// editing the answer keeps the review, removing a card drops it
+	if err := UpdateNote(st, &Note{ID: "a", Contents: "# A\n\nQ: one\nA: 1, really\n"}); err != nil {
+		t.Fatal(err)
+	}
+	cards, err = st.GetAllCards()
+	if err != nil || len(cards) != 2 {
+		t.Fatal("wrong cards:", cards, err)
+	}
+	if card := cards[one.ID]; card.Answer != "1, really" || card.Review.Repetitions != 1 {
+		t.Errorf("card not updated: %+v", card)
+	}
+	if due := DueCards(cards, now); len(due) != 1 || due[0].Question != "three" {
+		t.Error("wrong due cards:", due)
+	}
+
+	if err := UnindexNote(st, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if cards, err := st.GetAllCards(); err != nil || len(cards) != 1 {
+		t.Error("cards of removed note kept:", cards, err)
+	}
+}
+
+func TestRenameNoteKeepsReviews(t *testing.T) {
+	st := NewDiskStorage(t.TempDir())
+	if err := UpdateNote(st, &Note{ID: "a", Contents: "# A\n\none :: 1\n\ntwo :: 2\n"}); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ := st.GetAllCards()
+	for _, card := range cards {
+		if card.Question == "one" {
+			card.Review.Grade(MaxGrade, time.Now())
+			if err := st.SetCardReview(card.ID, card.Review); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := RenameNote(st, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.LoadNote("a"); err == nil {
+		t.Error("old note still exists")
+	}
+	cards, _ = st.GetAllCards()
+	if len(cards) != 2 {
+		t.Fatal("wrong cards:", cards)
+	}
+	for _, card := range cards {
+		if card.NoteID != "b" || (card.Question == "one") != (card.Review.Repetitions == 1) {
+			t.Errorf("review not carried over: %+v", card)
+		}
+	}
+}
@@ -3,16 +3,48 @@ package storage
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/sbrki/snote/internal/util"
 )
 
 type DiskStorage struct {
 	path string
+	// tagIndexMutex serializes read-modify-write cycles of the tag index.
+	tagIndexMutex sync.Mutex
+	// fieldIndexMutex serializes read-modify-write cycles of the field index.
+	fieldIndexMutex sync.Mutex
+	// blockIndexMutex serializes read-modify-write cycles of the block index.
+	blockIndexMutex sync.Mutex
+	// cardIndexMutex serializes read-modify-write cycles of the card index.
+	cardIndexMutex sync.Mutex
+	// linkIndexMutex serializes read-modify-write cycles of the link index.
+	linkIndexMutex sync.Mutex
+}
+
+// writeFileAtomic writes b to filename through a temporary file that is
+// renamed over filename, so readers never see a partially written file.
+func writeFileAtomic(filename string, b []byte) error {
+	f, err := ioutil.TempFile(path.Dir(filename), "."+path.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filename)
 }
 
 func NewDiskStorage(storagePath string) *DiskStorage {
@@ -26,9 +58,7 @@ func NewDiskStorage(storagePath string) *DiskStorage {
 		// create empty tagIdx and write it
 		ti := new(tagIndex)
 		ti.Tags = make(map[string][]string)
-		ti.Tags["snote/autogenerated"] = make([]string, 2)
-		ti.Tags["snote/autogenerated"][0] = "ls"
-		ti.Tags["snote/autogenerated"][1] = "lstag"
+		ti.Tags["snote/autogenerated"] = append([]string{}, AutogeneratedNoteIDs...)
 		f, err := os.OpenFile(tagIdxPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0700)
 		defer f.Close()
 		if err != nil {
@@ -41,24 +71,37 @@ func NewDiskStorage(storagePath string) *DiskStorage {
 		f.Write(json)
 
 	}
-	return &DiskStorage{storagePath}
+	return &DiskStorage{path: storagePath}
+}
+
+// notePath returns the file of the note with the given id. Note IDs can
+// contain slashes (journal/2024-05-31), such notes are kept in
+// subdirectories of notes/.
+func (ds *DiskStorage) notePath(id string) (string, error) {
+	if !ValidNoteID(id) {
+		return "", fmt.Errorf("%w %q", ErrInvalidNoteID, id)
+	}
+	return path.Join(ds.path, "notes", id+".json"), nil
 }
 
 func (ds *DiskStorage) LoadNote(id string) (*Note, error) {
-	filename := path.Join(ds.path, "notes", id+".json")
+	filename, err := ds.notePath(id)
+	if err != nil {
+		return nil, err
+	}
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 	note := new(Note)
-	json.Unmarshal(b, note)
+	if err := json.Unmarshal(b, note); err != nil {
+		return nil, err
+	}
 	return note, nil
 }
 
 func (ds *DiskStorage) SaveNote(note *Note) error {
-	filename := path.Join(ds.path, "notes", note.ID+".json")
-	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0700)
-	defer f.Close()
+	filename, err := ds.notePath(note.ID)
 	if err != nil {
 		return err
 	}
@@ -66,31 +109,57 @@ func (ds *DiskStorage) SaveNote(note *Note) error {
 	if err != nil {
 		return err
 	}
-	f.Write(json)
-	return nil
+	if err := os.MkdirAll(path.Dir(filename), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, json)
 }
 
 func (ds *DiskStorage) DeleteNote(id string) error {
-	err := os.Remove(path.Join(ds.path, "notes", id+".json"))
+	filename, err := ds.notePath(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filename)
 	if err != nil {
 		return err
 	}
+	// remove the subdirectories left empty, os.Remove fails on the others
+	notesPath := path.Join(ds.path, "notes")
+	for dir := path.Dir(filename); dir != notesPath; dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
 	return nil
 }
 
 func (ds *DiskStorage) GetAllNoteIDs() ([]string, error) {
 	notesPath := path.Join(ds.path, "notes")
 	IDs := make([]string, 0)
-	files, err := ioutil.ReadDir(notesPath)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, file := range files {
-		if !file.IsDir() && strings.Contains(file.Name(), ".json") {
-			ID := strings.Split(file.Name(), ".json")[0]
+	err := filepath.Walk(notesPath, func(name string, file os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip temporary files of writeFileAtomic
+		if strings.HasPrefix(file.Name(), ".") {
+			if file.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			relative, err := filepath.Rel(notesPath, name)
+			if err != nil {
+				return err
+			}
+			ID := strings.TrimSuffix(filepath.ToSlash(relative), ".json")
 			IDs = append(IDs, ID)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return IDs, nil
 }
@@ -151,6 +220,8 @@ type tagIndex struct {
 }
 
 func (ds *DiskStorage) SetNoteTags(id string, tags []string) error {
+	ds.tagIndexMutex.Lock()
+	defer ds.tagIndexMutex.Unlock()
 	tagIdxPath := path.Join(ds.path, "tagidx.json")
 
 	// read and unmarshall tagIndex json file
@@ -207,18 +278,11 @@ func (ds *DiskStorage) SetNoteTags(id string, tags []string) error {
 	}
 
 	// write ti to tagIndex json file
-	f, err := os.OpenFile(tagIdxPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0700)
-	defer f.Close()
-	if err != nil {
-		return err
-	}
 	json, err := json.Marshal(ti)
 	if err != nil {
 		return err
 	}
-	f.Write(json)
-
-	return nil
+	return writeFileAtomic(tagIdxPath, json)
 }
 
 func (ds *DiskStorage) GetAllNoteTags() (map[string][]string, error) {
@@ -237,3 +301,274 @@ func (ds *DiskStorage) GetAllNoteTags() (map[string][]string, error) {
 
 	return ti.Tags, nil
 }
+
+type fieldIndex struct {
+	Fields map[string]map[string][]string `json:"fields"`
+}
+
+// loadFieldIndex reads the field index. It is created on first use, so a
+// missing file is an empty index.
+func (ds *DiskStorage) loadFieldIndex() (*fieldIndex, error) {
+	fi := &fieldIndex{Fields: make(map[string]map[string][]string)}
+	b, err := ioutil.ReadFile(path.Join(ds.path, "fieldidx.json"))
+	if os.IsNotExist(err) {
+		return fi, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, fi); err != nil {
+		return nil, err
+	}
+	if fi.Fields == nil {
+		fi.Fields = make(map[string]map[string][]string)
+	}
+	return fi, nil
+}
+
+func (ds *DiskStorage) SetNoteFields(id string, fields map[string][]string) error {
+	ds.fieldIndexMutex.Lock()
+	defer ds.fieldIndexMutex.Unlock()
+
+	fi, err := ds.loadFieldIndex()
+	if err != nil {
+		return err
+	}
+
+	// remove id from all values, dropping values and fields without notes
+	for field, values := range fi.Fields {
+		for value, noteIDs := range values {
+			util.SliceRemoveString(&noteIDs, id)
+			if len(noteIDs) == 0 {
+				delete(values, value)
+			} else {
+				values[value] = noteIDs
+			}
+		}
+		if len(values) == 0 {
+			delete(fi.Fields, field)
+		}
+	}
+
+	// add id to its current values
+	for field, values := range fields {
+		for _, value := range values {
+			if fi.Fields[field] == nil {
+				fi.Fields[field] = make(map[string][]string)
+			}
+			if !util.SliceContainsString(fi.Fields[field][value], id) {
+				fi.Fields[field][value] = append(fi.Fields[field][value], id)
+			}
+		}
+	}
+
+	json, err := json.Marshal(fi)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(ds.path, "fieldidx.json"), json)
+}
+
+func (ds *DiskStorage) GetAllNoteFields() (map[string]map[string][]string, error) {
+	fi, err := ds.loadFieldIndex()
+	if err != nil {
+		return nil, err
+	}
+	return fi.Fields, nil
+}
+
+type blockIndex struct {
+	Blocks map[string][]string `json:"blocks"`
+}
+
+// loadBlockIndex reads the block index. Like the field index, it is
+// created on first use.
+func (ds *DiskStorage) loadBlockIndex() (*blockIndex, error) {
+	bi := &blockIndex{Blocks: make(map[string][]string)}
+	b, err := ioutil.ReadFile(path.Join(ds.path, "blockidx.json"))
+	if os.IsNotExist(err) {
+		return bi, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, bi); err != nil {
+		return nil, err
+	}
+	if bi.Blocks == nil {
+		bi.Blocks = make(map[string][]string)
+	}
+	return bi, nil
+}
+
+func (ds *DiskStorage) SetNoteBlocks(id string, blockIDs []string) error {
+	ds.blockIndexMutex.Lock()
+	defer ds.blockIndexMutex.Unlock()
+
+	bi, err := ds.loadBlockIndex()
+	if err != nil {
+		return err
+	}
+	for blockID, noteIDs := range bi.Blocks {
+		util.SliceRemoveString(&noteIDs, id)
+		if len(noteIDs) == 0 {
+			delete(bi.Blocks, blockID)
+		} else {
+			bi.Blocks[blockID] = noteIDs
+		}
+	}
+	for _, blockID := range blockIDs {
+		if !util.SliceContainsString(bi.Blocks[blockID], id) {
+			bi.Blocks[blockID] = append(bi.Blocks[blockID], id)
+		}
+	}
+
+	json, err := json.Marshal(bi)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(ds.path, "blockidx.json"), json)
+}
+
+func (ds *DiskStorage) GetAllNoteBlocks() (map[string][]string, error) {
+	bi, err := ds.loadBlockIndex()
+	if err != nil {
+		return nil, err
+	}
+	return bi.Blocks, nil
+}
+
+type cardIndex struct {
+	Cards map[string]Card `json:"cards"`
+}
+
+// loadCardIndex reads the card index, which also holds the review state
+// of the cards. Like the field index, it is created on first use.
+func (ds *DiskStorage) loadCardIndex() (*cardIndex, error) {
+	ci := &cardIndex{Cards: make(map[string]Card)}
+	b, err := ioutil.ReadFile(path.Join(ds.path, "cardidx.json"))
+	if os.IsNotExist(err) {
+		return ci, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, ci); err != nil {
+		return nil, err
+	}
+	if ci.Cards == nil {
+		ci.Cards = make(map[string]Card)
+	}
+	return ci, nil
+}
+
+func (ds *DiskStorage) writeCardIndex(ci *cardIndex) error {
+	json, err := json.Marshal(ci)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(ds.path, "cardidx.json"), json)
+}
+
+func (ds *DiskStorage) SetNoteCards(id string, cards []Card) error {
+	ds.cardIndexMutex.Lock()
+	defer ds.cardIndexMutex.Unlock()
+
+	ci, err := ds.loadCardIndex()
+	if err != nil {
+		return err
+	}
+	reviews := make(map[string]Review)
+	for cardID, card := range ci.Cards {
+		if card.NoteID == id {
+			reviews[cardID] = card.Review
+			delete(ci.Cards, cardID)
+		}
+	}
+	for _, card := range cards {
+		if review, found := reviews[card.ID]; found {
+			card.Review = review
+		}
+		card.NoteID = id
+		ci.Cards[card.ID] = card
+	}
+	return ds.writeCardIndex(ci)
+}
+
+func (ds *DiskStorage) GetAllCards() (map[string]Card, error) {
+	ci, err := ds.loadCardIndex()
+	if err != nil {
+		return nil, err
+	}
+	return ci.Cards, nil
+}
+
+func (ds *DiskStorage) SetCardReview(cardID string, review Review) error {
+	ds.cardIndexMutex.Lock()
+	defer ds.cardIndexMutex.Unlock()
+
+	ci, err := ds.loadCardIndex()
+	if err != nil {
+		return err
+	}
+	card, found := ci.Cards[cardID]
+	if !found {
+		return fmt.Errorf("card %s not found", cardID)
+	}
+	card.Review = review
+	ci.Cards[cardID] = card
+	return ds.writeCardIndex(ci)
+}
+
+type linkIndex struct {
+	Notes map[string]NoteLinks `json:"notes"`
+}
+
+// loadLinkIndex reads the link index. Like the field index, it is
+// created on first use.
+func (ds *DiskStorage) loadLinkIndex() (*linkIndex, error) {
+	li := &linkIndex{Notes: make(map[string]NoteLinks)}
+	b, err := ioutil.ReadFile(path.Join(ds.path, "linkidx.json"))
+	if os.IsNotExist(err) {
+		return li, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, li); err != nil {
+		return nil, err
+	}
+	if li.Notes == nil {
+		li.Notes = make(map[string]NoteLinks)
+	}
+	return li, nil
+}
+
+func (ds *DiskStorage) SetNoteLinks(id string, links *NoteLinks) error {
+	ds.linkIndexMutex.Lock()
+	defer ds.linkIndexMutex.Unlock()
+
+	li, err := ds.loadLinkIndex()
+	if err != nil {
+		return err
+	}
+	if links == nil {
+		delete(li.Notes, id)
+	} else {
+		li.Notes[id] = *links
+	}
+
+	json, err := json.Marshal(li)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(ds.path, "linkidx.json"), json)
+}
+
+func (ds *DiskStorage) GetAllNoteLinks() (map[string]NoteLinks, error) {
+	li, err := ds.loadLinkIndex()
+	if err != nil {
+		return nil, err
+	}
+	return li.Notes, nil
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	mdhtml "github.com/gomarkdown/markdown/html"
+	"github.com/sbrki/snote/internal/util"
+)
+
+// MaxEmbedDepth is how deep embeds can be nested: a note embedding a note
+// that embeds another note is two levels deep.
+const MaxEmbedDepth = 5
+
+// embed matches ![[note]], ![[note#Heading]] and ![[note#^block-id]].
+var embed = regexp.MustCompile(`!\[\[([^\]#|\n]+)(?:#([^\]|\n]+))?\]\]`)
+
+// NoteLoader loads the notes embedded in a note, Storage is a NoteLoader.
+type NoteLoader interface {
+	LoadNote(id string) (*Note, error)
+}
+
+// ErrNotEmbeddable is returned by a NoteLoader for notes that must not be
+// shown in other notes. Their embeds are shown as links, like with a nil loader.
+var ErrNotEmbeddable = errors.New("note cannot be embedded")
+
+// noteRenderer renders a note along with the notes it embeds.
+type noteRenderer struct {
+	loader    NoteLoader
+	codeCache CodeCache
+	today     time.Time
+	// dependencies holds the IDs of all notes embedded, directly or not,
+	// and of the notes holding linked blocks, including the ones that
+	// could not be loaded.
+	dependencies []string
+}
+
+func newNoteRenderer(loader NoteLoader, codeCache CodeCache) *noteRenderer {
+	return &noteRenderer{loader: loader, codeCache: codeCache, today: time.Now(), dependencies: []string{}}
+}
+
+// RenderHTMLEmbedding renders the note like RenderHTMLCached and replaces
+// each ![[note]], ![[note#Heading]] or ![[note#^block-id]] with the note,
+// or only the section of the heading or the block, loaded from loader.
+// It also returns the IDs of the notes that the html depends on: all
+// notes that are embedded, directly or not, and the targets of block links.
+//
+// Embeds nested deeper than MaxEmbedDepth and embeds of a note in itself
+// are shown as errors. If loader is nil, embeds are shown as links.
+func (note *Note) RenderHTMLEmbedding(loader NoteLoader, codeCache CodeCache) (string, []string) {
+	r := newNoteRenderer(loader, codeCache)
+
+	rootNode := ParseMarkdown(note.Contents)
+	assignBlockIDs(rootNode)
+	outline := headingTree(assignHeadingIDs(rootNode))
+	expandTOC(rootNode, outline)
+	addPermalinks(rootNode)
+	addTaskCheckboxes(rootNode, note.Contents, "", r.today)
+	addHashtagLinks(rootNode)
+	r.expandEmbeds(rootNode, []string{note.ID})
+	r.addWikiLinks(rootNode, note.ID)
+
+	return metadataPanel(note.ParseFrontMatter()) + r.render(rootNode), r.dependencies
+}
+
+func (r *noteRenderer) render(rootNode ast.Node) string {
+	renderer := mdhtml.NewRenderer(mdhtml.RendererOptions{
+		Flags:          mdhtml.CommonFlags,
+		RenderNodeHook: renderHooks(mathHook, codeBlockHook(r.codeCache), blockHook),
+	})
+	return string(markdown.Render(rootNode, renderer))
+}
+
+func (r *noteRenderer) depend(id string) {
+	if !util.SliceContainsString(r.dependencies, id) {
+		r.dependencies = append(r.dependencies, id)
+	}
+}
+
+// expandEmbeds replaces the embeds below rootNode. stack holds the IDs of
+// the note being rendered and the notes it is embedded in.
+func (r *noteRenderer) expandEmbeds(rootNode ast.Node, stack []string) {
+	texts := make([]*ast.Text, 0)
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		if n, ok := node.(*ast.Text); ok && entering && embed.Match(n.Literal) {
+			texts = append(texts, n)
+		}
+		return ast.GoToNext
+	})
+
+	for _, text := range texts {
+		literal := text.Literal
+		matches := embed.FindAllSubmatchIndex(literal, -1)
+
+		// a paragraph of only embeds is replaced by the embedded notes
+		paragraph, ok := text.GetParent().(*ast.Paragraph)
+		if ok && len(paragraph.Children) == 1 && len(strings.TrimSpace(embed.ReplaceAllString(string(literal), ""))) == 0 {
+			blocks := make([]ast.Node, 0, len(matches))
+			for _, m := range matches {
+				block := &ast.HTMLBlock{}
+				block.Literal = []byte(r.embed(literal, m, stack))
+				blocks = append(blocks, block)
+			}
+			replaceWith(paragraph, blocks...)
+			continue
+		}
+
+		last := 0
+		for _, m := range matches {
+			before := &ast.Text{}
+			before.Literal = literal[last:m[0]]
+			span := &ast.HTMLSpan{}
+			span.Literal = []byte(r.embed(literal, m, stack))
+			insertBefore(text, before)
+			insertBefore(text, span)
+			last = m[1]
+		}
+		text.Literal = literal[last:]
+	}
+}
+
+// embed returns the html of the embed matched at m in literal.
+func (r *noteRenderer) embed(literal []byte, m []int, stack []string) string {
+	id := strings.TrimSpace(string(literal[m[2]:m[3]]))
+	section := ""
+	if m[4] >= 0 {
+		section = strings.TrimSpace(string(literal[m[4]:m[5]]))
+	}
+	r.depend(id)
+	if strings.HasPrefix(section, "^") {
+		id = r.resolveBlock(id, section[1:])
+	}
+
+	source := `<a class="embed-source" href="/` + html.EscapeString(id+sectionAnchor(section)) + `">` +
+		html.EscapeString(string(literal[m[0]:m[1]])) + "</a>"
+	if r.loader == nil {
+		return source
+	}
+
+	if util.SliceContainsString(stack, id) {
+		return embedError(source + ": embed cycle " + html.EscapeString(strings.Join(append(stack, id), " → ")))
+	}
+	if len(stack) > MaxEmbedDepth {
+		return embedError(fmt.Sprintf("%s: embeds are nested deeper than %d levels", source, MaxEmbedDepth))
+	}
+	note, err := r.loader.LoadNote(id)
+	if errors.Is(err, ErrNotEmbeddable) {
+		return source
+	} else if err != nil {
+		return embedError(source + ": no such note")
+	}
+	rendered, err := r.renderSection(note, section, stack)
+	if err != nil {
+		return embedError(source + ": " + html.EscapeString(err.Error()))
+	}
+	return `<div class="embed" data-note="` + html.EscapeString(id) + `">` + source + "\n" + rendered + "</div>"
+}
+
+// renderSection renders the section of note (the whole note, a heading
+// with its section or a ^block) as embedded in the notes of stack.
+func (r *noteRenderer) renderSection(note *Note, section string, stack []string) (string, error) {
+	rootNode := ParseMarkdown(note.Contents)
+	blocks := assignBlockIDs(rootNode)
+	addTaskCheckboxes(rootNode, note.Contents, note.ID, r.today)
+
+	var nodes []ast.Node
+	switch {
+	case strings.HasPrefix(section, "^"):
+		block, found := blocks[section[1:]]
+		if !found {
+			return "", errors.New("no such block")
+		}
+		if item, ok := block.(*ast.ListItem); ok {
+			// list items are shown in a list of their own
+			list := *item.Parent.(*ast.List)
+			list.Children = []ast.Node{item}
+			item.Parent = &list
+			block = &list
+		}
+		nodes = []ast.Node{block}
+	case section != "":
+		if nodes = sectionNodes(rootNode, section); nodes == nil {
+			return "", errors.New("no such heading")
+		}
+	}
+	if nodes != nil {
+		// ast.AppendChild would drop the children of the nodes
+		rootNode = &ast.Document{}
+		rootNode.SetChildren(nodes)
+		for _, node := range nodes {
+			node.SetParent(rootNode)
+		}
+	}
+
+	// the headings and blocks of the embedded note get no anchors,
+	// those would clash with the ones of the note it is embedded in
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		if n, ok := node.(*ast.Heading); ok {
+			n.HeadingID = ""
+		}
+		if c := node.AsContainer(); c != nil {
+			c.Attribute = nil
+		}
+		return ast.GoToNext
+	})
+	addHashtagLinks(rootNode)
+	r.expandEmbeds(rootNode, append(append([]string{}, stack...), note.ID))
+	r.addWikiLinks(rootNode, note.ID)
+	return r.render(rootNode), nil
+}
+
+func embedError(message string) string {
+	return `<div class="embed embed-error">` + message + "</div>"
+}
+
+// sectionAnchor is the fragment of a link to section ("Heading" or "^block-id").
+func sectionAnchor(section string) string {
+	switch {
+	case section == "":
+		return ""
+	case strings.HasPrefix(section, "^"):
+		return "#" + blockAnchor(section[1:])
+	default:
+		return "#" + Slug(section)
+	}
+}
+
+// sectionNodes returns the heading matching section, by its text or its
+// anchor, and the nodes up to the next heading of the same or a higher
+// level. It returns nil if no heading matches.
+func sectionNodes(rootNode ast.Node, section string) []ast.Node {
+	assignHeadingIDs(rootNode)
+	var nodes []ast.Node
+	level := 0
+	for _, child := range rootNode.GetChildren() {
+		heading, isHeading := child.(*ast.Heading)
+		if nodes != nil {
+			if isHeading && heading.Level <= level {
+				break
+			}
+			nodes = append(nodes, child)
+			continue
+		}
+		if isHeading && (strings.EqualFold(headingText(heading), section) || heading.HeadingID == Slug(section)) {
+			nodes = []ast.Node{child}
+			level = heading.Level
+		}
+	}
+	return nodes
+}
+
+// replaceWith replaces node with nodes in the children of its parent.
+func replaceWith(node ast.Node, nodes ...ast.Node) {
+	parent := node.GetParent()
+	children := make([]ast.Node, 0, len(parent.GetChildren())+len(nodes))
+	for _, child := range parent.GetChildren() {
+		if child == node {
+			children = append(children, nodes...)
+		} else {
+			children = append(children, child)
+		}
+	}
+	parent.SetChildren(children)
+	for _, n := range nodes {
+		n.SetParent(parent)
+	}
+}
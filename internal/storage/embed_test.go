@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// noteMap is a NoteLoader holding notes by ID.
+type noteMap map[string]string
+
+func (m noteMap) LoadNote(id string) (*Note, error) {
+	contents, found := m[id]
+	if !found {
+		return nil, fmt.Errorf("note %s not found", id)
+	}
+	return &Note{ID: id, Contents: contents}, nil
+}
+
+func TestRenderEmbeds(t *testing.T) {
+	notes := noteMap{
+		"contacts": "---\ntitle: Contacts\n---\n# Contacts\n\n## On-call\n\n- [ ] call *Ann*\n\n### Backup\n\nBob\n\n## Vendors\n\nAcme\n",
+		"runbook":  "# Runbook\n\n![[contacts#On-call]]\n",
+		"loop":     "# Loop\n\n![[cycle]]\n",
+		"cycle":    "# Cycle\n\n![[loop]]\n",
+	}
+	html, embedded := (&Note{ID: "page", Contents: "# Page\n\n![[runbook]]\n\nsee ![[missing]] inline\n"}).RenderHTMLEmbedding(notes, nil)
+	if !reflect.DeepEqual(embedded, []string{"runbook", "contacts", "missing"}) {
+		t.Error("wrong embedded notes:", embedded)
+	}
+	for _, expected := range []string{
+		`<div class="embed" data-note="runbook"><a class="embed-source" href="/runbook">![[runbook]]</a>`,
+		`<div class="embed" data-note="contacts"><a class="embed-source" href="/contacts#on-call">![[contacts#On-call]]</a>`,
+		"<h2>On-call</h2>",
+		"<h3>Backup</h3>",
+		`<input type="checkbox" class="task" data-note="contacts" data-task="0" disabled> call <em>Ann</em>`,
+		`<p>see <div class="embed embed-error"><a class="embed-source" href="/missing">![[missing]]</a>: no such note</div> inline</p>`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("missing %q in %s", expected, html)
+		}
+	}
+	if strings.Contains(html, "Vendors") || strings.Contains(html, "title: Contacts") || strings.Contains(html, "<p>![[") {
+		t.Error("wrong section embedded:", html)
+	}
+
+	html, _ = (&Note{ID: "loop", Contents: notes["loop"]}).RenderHTMLEmbedding(notes, nil)
+	if !strings.Contains(html, "embed cycle loop → cycle → loop") {
+		t.Error("cycle not detected:", html)
+	}
+
+	deep := noteMap{}
+	for i := 0; i <= MaxEmbedDepth+1; i++ {
+		deep[fmt.Sprint(i)] = fmt.Sprintf("level %d\n\n![[%d]]\n", i, i+1)
+	}
+	html, _ = (&Note{ID: "0", Contents: deep["0"]}).RenderHTMLEmbedding(deep, nil)
+	if !strings.Contains(html, fmt.Sprintf("level %d", MaxEmbedDepth)) || strings.Contains(html, fmt.Sprintf("level %d", MaxEmbedDepth+1)) ||
+		!strings.Contains(html, "nested deeper than") {
+		t.Error("depth limit not applied:", html)
+	}
+
+	if html := (&Note{Contents: notes["runbook"]}).RenderHTML(); !strings.Contains(html, `<a class="embed-source" href="/contacts#on-call">`) {
+		t.Error("embed not shown as a link without a loader:", html)
+	}
+}
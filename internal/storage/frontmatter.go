@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter is the metadata declared in a YAML block at the very start
+// of a note:
+//
+//	---
+//	title: Road trip
+//	tags: [travel, planning]
+//	aliases: [trip]
+//	created: 2021-06-01
+//	status: draft
+//	---
+//
+// Title, tags and the creation time take precedence over the first
+// heading, the `tags:` code span and the stored creation time.
+type FrontMatter struct {
+	Title   string    `json:"title"`
+	Tags    []string  `json:"tags"`
+	Aliases []string  `json:"aliases"`
+	Created time.Time `json:"created"`
+	// Fields are the custom fields, with lowercased names. Values are
+	// converted to strings, nested fields are named "parent.child".
+	Fields map[string][]string `json:"fields"`
+}
+
+// aliasesField is the name under which aliases are kept in the field index.
+const aliasesField = "aliases"
+
+// dateFormats are the accepted layouts of the created field.
+var dateFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// SplitFrontMatter separates the front matter block from the rest of
+// contents. The block is delimited by "---" lines (or closed by "...").
+// It reports false if contents has no front matter.
+func SplitFrontMatter(contents string) (string, string, bool) {
+	normalized := strings.Replace(contents, "\r\n", "\n", -1)
+	if !strings.HasPrefix(normalized, "---\n") {
+		return "", contents, false
+	}
+	lines := strings.SplitAfter(normalized[4:], "\n")
+	offset := 4
+	for _, line := range lines {
+		if trimmed := strings.TrimRight(line, " \t\n"); trimmed == "---" || trimmed == "..." {
+			return normalized[4:offset], normalized[offset+len(line):], true
+		}
+		offset += len(line)
+	}
+	return "", contents, false
+}
+
+// stripFrontMatter returns contents without its front matter block.
+func stripFrontMatter(contents string) string {
+	_, body, _ := SplitFrontMatter(contents)
+	return body
+}
+
+// ParseFrontMatter returns the front matter of the note, or nil if it has
+// none. A block that is not valid YAML is reported as an error.
+func (note *Note) ParseFrontMatter() (*FrontMatter, error) {
+	block, _, found := SplitFrontMatter(note.Contents)
+	if !found {
+		return nil, nil
+	}
+	raw := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(block), &raw); err != nil {
+		return nil, fmt.Errorf("front matter: %v", err)
+	}
+
+	fm := &FrontMatter{Tags: []string{}, Aliases: []string{}, Fields: make(map[string][]string)}
+	for key, value := range raw {
+		values := flattenValue(value)
+		switch strings.ToLower(key) {
+		case "title":
+			fm.Title = strings.Join(values, " ")
+		case "tags", "tag":
+			for _, value := range values {
+				for _, tag := range strings.Split(value, ",") {
+					if tag = normalizeTag(tag); tag != "" {
+						fm.Tags = append(fm.Tags, tag)
+					}
+				}
+			}
+		case "aliases", "alias":
+			fm.Aliases = append(fm.Aliases, values...)
+		case "created", "date":
+			if created, ok := value.(time.Time); ok {
+				fm.Created = created
+				continue
+			}
+			// the creation time of a template is a placeholder
+			if placeholder.MatchString(strings.Join(values, "")) {
+				continue
+			}
+			created, err := parseDate(strings.Join(values, ""))
+			if err != nil {
+				return nil, fmt.Errorf("front matter: %v", err)
+			}
+			fm.Created = created
+		default:
+			flattenFields(fm.Fields, strings.ToLower(key), value)
+		}
+	}
+	return fm, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateFormats {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("created: %q is not a date (use YYYY-MM-DD)", value)
+}
+
+// flattenFields adds value to fields under name. Mappings add a field
+// for each of their keys.
+func flattenFields(fields map[string][]string, name string, value interface{}) {
+	if mapping, ok := value.(map[string]interface{}); ok {
+		for key, child := range mapping {
+			flattenFields(fields, name+"."+strings.ToLower(key), child)
+		}
+		return
+	}
+	fields[name] = append(fields[name], flattenValue(value)...)
+}
+
+// flattenValue converts a YAML value to strings, a list gives one string per item.
+func flattenValue(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return []string{}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, flattenValue(item)...)
+		}
+		return values
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 {
+			return []string{v.Format("2006-01-02")}
+		}
+		return []string{v.Format(time.RFC3339)}
+	case map[string]interface{}:
+		fields := make(map[string][]string)
+		flattenFields(fields, "", v)
+		values := make([]string, 0)
+		for key, items := range fields {
+			values = append(values, strings.TrimPrefix(key, ".")+": "+strings.Join(items, ", "))
+		}
+		sort.Strings(values)
+		return values
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// ParseFields returns the fields of the note kept in the field index: the
+// custom fields and the aliases of its front matter. Notes with invalid
+// front matter have no fields.
+func (note *Note) ParseFields() map[string][]string {
+	fm, err := note.ParseFrontMatter()
+	if err != nil {
+		return map[string][]string{}
+	}
+	return fm.indexedFields()
+}
+
+func (fm *FrontMatter) indexedFields() map[string][]string {
+	fields := make(map[string][]string)
+	if fm == nil {
+		return fields
+	}
+	for name, values := range fm.Fields {
+		fields[name] = values
+	}
+	if len(fm.Aliases) > 0 {
+		fields[aliasesField] = fm.Aliases
+	}
+	return fields
+}
+
+// ResolveAlias returns the ID of the note declaring alias in its front
+// matter. Aliases are matched regardless of case.
+func ResolveAlias(storage Storage, alias string) (string, bool, error) {
+	fieldIndex, err := storage.GetAllNoteFields()
+	if err != nil {
+		return "", false, err
+	}
+	for value, noteIDs := range fieldIndex[aliasesField] {
+		if strings.EqualFold(value, alias) && len(noteIDs) > 0 {
+			return noteIDs[0], true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// metadataPanel renders the front matter of a note (except its title,
+// which is shown as the heading) as a definition list.
+func metadataPanel(fm *FrontMatter, err error) string {
+	if err != nil {
+		return `<aside class="metadata metadata-error">` + html.EscapeString(err.Error()) + "</aside>\n"
+	}
+	if fm == nil {
+		return ""
+	}
+	var b strings.Builder
+	entry := func(name string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		b.WriteString("<dt>" + html.EscapeString(name) + "</dt>")
+		for _, value := range values {
+			b.WriteString("<dd>" + html.EscapeString(value) + "</dd>")
+		}
+		b.WriteString("\n")
+	}
+	entry("tags", fm.Tags)
+	entry("aliases", fm.Aliases)
+	if !fm.Created.IsZero() {
+		entry("created", flattenValue(fm.Created))
+	}
+	names := make([]string, 0, len(fm.Fields))
+	for name := range fm.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry(name, fm.Fields[name])
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "<aside class=\"metadata\"><dl>\n" + b.String() + "</dl></aside>\n"
+}
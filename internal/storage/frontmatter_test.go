@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const frontMatterNote = `---
+title: Road trip
+tags: [Travel, "#planning"]
+aliases:
+  - trip
+created: 2021-06-01
+status: draft
+budget:
+  total: 1200
+---
+# Itinerary
+
+` + "`tags: travel, cars`" + `
+`
+
+func TestParseFrontMatter(t *testing.T) {
+	note := &Note{Contents: frontMatterNote}
+	fm, err := note.ParseFrontMatter()
+	if err != nil || fm == nil {
+		t.Fatal("front matter not parsed:", fm, err)
+	}
+	if fm.Title != "Road trip" || !reflect.DeepEqual(fm.Tags, []string{"travel", "planning"}) || !reflect.DeepEqual(fm.Aliases, []string{"trip"}) {
+		t.Error("wrong front matter:", fm)
+	}
+	if fm.Created.Format("2006-01-02") != "2021-06-01" {
+		t.Error("wrong creation time:", fm.Created)
+	}
+	expected := map[string][]string{"status": {"draft"}, "budget.total": {"1200"}}
+	if !reflect.DeepEqual(fm.Fields, expected) {
+		t.Error("wrong fields:", fm.Fields)
+	}
+
+	if title := note.ParseTitle(); title != "Road trip" {
+		t.Error("wrong title:", title)
+	}
+	if tags := note.ParseTags(); !reflect.DeepEqual(tags, []string{"travel", "planning", "cars"}) {
+		t.Error("wrong tags:", tags)
+	}
+
+	for _, contents := range []string{"# No front matter\n", "---\nunterminated: true\n"} {
+		if fm, err := (&Note{Contents: contents}).ParseFrontMatter(); fm != nil || err != nil {
+			t.Error("front matter parsed from", contents)
+		}
+	}
+	if _, err := (&Note{Contents: "---\ntitle: [broken\n---\n"}).ParseFrontMatter(); err == nil {
+		t.Error("invalid front matter parsed")
+	}
+}
+
+func TestRenderFrontMatter(t *testing.T) {
+	html := (&Note{Contents: frontMatterNote}).RenderHTML()
+	if strings.Contains(html, "status: draft") || strings.Contains(html, "<hr") {
+		t.Error("front matter rendered:", html)
+	}
+	for _, expected := range []string{`<aside class="metadata">`, "<dt>status</dt><dd>draft</dd>", "<dt>created</dt><dd>2021-06-01</dd>", "<h1"} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("missing %q in %s", expected, html)
+		}
+	}
+
+	html = (&Note{Contents: "---\ntitle: [broken\n---\n# Note\n"}).RenderHTML()
+	if !strings.Contains(html, "metadata-error") {
+		t.Error("front matter error not shown:", html)
+	}
+}
+
+func TestFieldIndex(t *testing.T) {
+	st := NewDiskStorage(t.TempDir())
+
+	note := &Note{ID: "trip", Contents: frontMatterNote}
+	if err := UpdateNote(st, note); err != nil {
+		t.Fatal(err)
+	}
+	if note.Title != "Road trip" || !note.Created.Equal(time.Date(2021, 6, 1, 0, 0, 0, 0, note.Created.Location())) {
+		t.Error("wrong title or creation time:", note.Title, note.Created)
+	}
+	if err := UpdateNote(st, &Note{ID: "other", Contents: "---\nstatus: done\n---\n"}); err != nil {
+		t.Fatal(err)
+	}
+	fields, err := st.GetAllNoteFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string][]string{
+		"status":       {"draft": {"trip"}, "done": {"other"}},
+		"budget.total": {"1200": {"trip"}},
+		"aliases":      {"trip": {"trip"}},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Error("wrong field index:", fields)
+	}
+	if noteID, found, err := ResolveAlias(st, "Trip"); err != nil || !found || noteID != "trip" {
+		t.Error("alias not resolved:", noteID, found, err)
+	}
+
+	if err := st.DeleteNote("other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reindex(st); err != nil {
+		t.Fatal(err)
+	}
+	if fields, _ := st.GetAllNoteFields(); !reflect.DeepEqual(fields["status"], map[string][]string{"draft": {"trip"}}) {
+		t.Error("stale field entry kept:", fields)
+	}
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// Graph is the graph of the links between notes.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Links []GraphLink `json:"links"`
+}
+
+// GraphNode is a note in a Graph.
+type GraphNode struct {
+	ID    string   `json:"id"`
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+	// Degree is the number of links from and to the note, 0 for isolated notes.
+	Degree int `json:"degree"`
+	// Cluster numbers the group of notes connected by links the note
+	// belongs to, the biggest group being 0.
+	Cluster int `json:"cluster"`
+}
+
+// GraphLink is a link from the note Source to the note Target.
+type GraphLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// NoteLinks is the link index entry of a note: its title and the targets
+// of its links, as returned by Note.ParseLinks.
+type NoteLinks struct {
+	Title   string   `json:"title"`
+	Targets []string `json:"targets"`
+}
+
+// ParseNoteLinks returns the link index entry of the note, or nil for
+// templates, which are left out of the graph.
+func (note *Note) ParseNoteLinks() *NoteLinks {
+	if note.IsTemplate() {
+		return nil
+	}
+	return &NoteLinks{Title: note.ParseTitle(), Targets: note.ParseLinks()}
+}
+
+// ParseLinks returns the targets of the links of the note in order, both
+// markdown links to /<id> and [[wiki links]], which also covers embeds.
+// Links to other paths of the server (/api/..., /static/...) and links
+// within the note are left out. Targets are not checked to be notes.
+func (note *Note) ParseLinks() []string {
+	rootNode := ParseMarkdown(note.Contents)
+
+	targets := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(target string) {
+		if target == "" || target == note.ID || seen[target] {
+			return
+		}
+		seen[target] = true
+		targets = append(targets, target)
+	}
+	inLink := 0
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch n := node.(type) {
+		case *ast.Link:
+			if entering {
+				inLink++
+				add(linkedNoteID(string(n.Destination)))
+			} else {
+				inLink--
+			}
+		case *ast.Text:
+			if entering && inLink == 0 {
+				for _, m := range wikiLink.FindAllStringSubmatch(string(n.Literal), -1) {
+					add(strings.TrimSpace(m[1]))
+				}
+			}
+		}
+		return ast.GoToNext
+	})
+	return targets
+}
+
+// linkedNoteID returns the ID of the note destination links to, or "" if
+// it does not link to a note.
+func linkedNoteID(destination string) string {
+	destination = strings.TrimSpace(destination)
+	if !strings.HasPrefix(destination, "/") || strings.HasPrefix(destination, "//") {
+		return ""
+	}
+	if i := strings.IndexAny(destination, "?#"); i >= 0 {
+		destination = destination[:i]
+	}
+	id, err := url.PathUnescape(strings.Trim(destination, "/"))
+	if err != nil || strings.HasPrefix(id, "api/") || strings.HasPrefix(id, "static/") {
+		return ""
+	}
+	return id
+}
+
+// BuildGraph returns the graph of the links between the notes. Links to
+// aliases count as links to their notes, links to missing notes are left
+// out. A non-empty tag limits the graph to the notes having the tag.
+// Templates are left out. The titles and links come from the link index,
+// notes missing from it (e.g. in storages predating it) are indexed on
+// the way.
+func BuildGraph(storage Storage, tag string) (*Graph, error) {
+	allNoteIDs, err := storage.GetAllNoteIDs()
+	if err != nil {
+		return nil, err
+	}
+	linkIndex, err := storage.GetAllNoteLinks()
+	if err != nil {
+		return nil, err
+	}
+	tagIndex, err := storage.GetAllNoteTags()
+	if err != nil {
+		return nil, err
+	}
+	fieldIndex, err := storage.GetAllNoteFields()
+	if err != nil {
+		return nil, err
+	}
+
+	included := make(map[string]bool)
+	if tag == "" {
+		for _, noteID := range allNoteIDs {
+			included[noteID] = !IsAutogenerated(noteID)
+		}
+	} else {
+		for _, noteID := range TaggedNoteIDs(tagIndex, tag) {
+			included[noteID] = !IsAutogenerated(noteID)
+		}
+	}
+	noteTags := make(map[string][]string)
+	for t, noteIDs := range tagIndex {
+		for _, noteID := range noteIDs {
+			noteTags[noteID] = append(noteTags[noteID], t)
+		}
+	}
+	aliases := make(map[string]string)
+	for alias, noteIDs := range fieldIndex[aliasesField] {
+		if len(noteIDs) > 0 {
+			aliases[strings.ToLower(alias)] = noteIDs[0]
+		}
+	}
+
+	graph := &Graph{Nodes: make([]GraphNode, 0), Links: make([]GraphLink, 0)}
+	for _, noteID := range allNoteIDs {
+		if !included[noteID] {
+			continue
+		}
+		links, found := linkIndex[noteID]
+		if !found {
+			note, err := storage.LoadNote(noteID)
+			if err != nil {
+				return nil, err
+			}
+			parsed := note.ParseNoteLinks()
+			if parsed == nil {
+				continue
+			}
+			if err := storage.SetNoteLinks(noteID, parsed); err != nil {
+				return nil, err
+			}
+			links = *parsed
+		}
+		tags := noteTags[noteID]
+		if tags == nil {
+			tags = make([]string, 0)
+		}
+		sort.Strings(tags)
+		graph.Nodes = append(graph.Nodes, GraphNode{ID: noteID, Title: links.Title, Tags: tags})
+
+		linked := make(map[string]bool)
+		for _, target := range links.Targets {
+			if !included[target] {
+				if aliased, found := aliases[strings.ToLower(target)]; found && included[aliased] {
+					target = aliased
+				} else {
+					continue
+				}
+			}
+			if target != noteID && !linked[target] {
+				linked[target] = true
+				graph.Links = append(graph.Links, GraphLink{Source: noteID, Target: target})
+			}
+		}
+	}
+	nodes := make(map[string]*GraphNode)
+	for i := range graph.Nodes {
+		nodes[graph.Nodes[i].ID] = &graph.Nodes[i]
+	}
+	for _, link := range graph.Links {
+		nodes[link.Source].Degree++
+		nodes[link.Target].Degree++
+	}
+	graph.cluster()
+	return graph, nil
+}
+
+// neighbours returns the IDs of the notes linked from or to each note.
+func (graph *Graph) neighbours() map[string][]string {
+	neighbours := make(map[string][]string)
+	for _, link := range graph.Links {
+		neighbours[link.Source] = append(neighbours[link.Source], link.Target)
+		neighbours[link.Target] = append(neighbours[link.Target], link.Source)
+	}
+	return neighbours
+}
+
+// cluster numbers the connected groups of nodes by size, biggest first,
+// and among groups of the same size by the smallest note ID in them.
+func (graph *Graph) cluster() {
+	neighbours := graph.neighbours()
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].ID < graph.Nodes[j].ID })
+	index := make(map[string]int)
+	for i, node := range graph.Nodes {
+		index[node.ID] = i
+	}
+
+	clusters := make([][]int, 0)
+	visited := make(map[string]bool)
+	for i, node := range graph.Nodes {
+		if visited[node.ID] {
+			continue
+		}
+		visited[node.ID] = true
+		members := []int{i}
+		for next := 0; next < len(members); next++ {
+			for _, neighbour := range neighbours[graph.Nodes[members[next]].ID] {
+				if !visited[neighbour] {
+					visited[neighbour] = true
+					members = append(members, index[neighbour])
+				}
+			}
+		}
+		clusters = append(clusters, members)
+	}
+	// the stable sort keeps groups of the same size in the order of their first note
+	sort.SliceStable(clusters, func(i, j int) bool { return len(clusters[i]) > len(clusters[j]) })
+	for number, members := range clusters {
+		for _, i := range members {
+			graph.Nodes[i].Cluster = number
+		}
+	}
+}
+
+// Neighbourhood returns the part of the graph within depth links of the
+// note with the given id, following links in both directions. The nodes
+// keep their degrees and clusters in the whole graph. It reports false if
+// the note is not in the graph.
+func (graph *Graph) Neighbourhood(id string, depth int) (*Graph, bool) {
+	distance := map[string]int{id: 0}
+	found := false
+	for _, node := range graph.Nodes {
+		found = found || node.ID == id
+	}
+	if !found {
+		return nil, false
+	}
+	neighbours := graph.neighbours()
+	for queue := []string{id}; len(queue) > 0; queue = queue[1:] {
+		if distance[queue[0]] >= depth {
+			continue
+		}
+		for _, neighbour := range neighbours[queue[0]] {
+			if _, seen := distance[neighbour]; !seen {
+				distance[neighbour] = distance[queue[0]] + 1
+				queue = append(queue, neighbour)
+			}
+		}
+	}
+
+	result := &Graph{Nodes: make([]GraphNode, 0), Links: make([]GraphLink, 0)}
+	for _, node := range graph.Nodes {
+		if _, within := distance[node.ID]; within {
+			result.Nodes = append(result.Nodes, node)
+		}
+	}
+	for _, link := range graph.Links {
+		_, source := distance[link.Source]
+		_, target := distance[link.Target]
+		if source && target {
+			result.Links = append(result.Links, link)
+		}
+	}
+	return result, true
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	note := &Note{ID: "a", Contents: "# A\n\nSee [b](/b#part), [[c|the c]], ![[d#Heading]] and [[#local]].\n" +
+		"[self](/a) [blob](/api/blob/x/y.png) [web](https://example.com) [[b]] [nested](/journal/2024-05-31)\n\n" +
+		"`[[code]]`\n\n```\n[[fenced]]\n```\n"}
+	if links := note.ParseLinks(); !reflect.DeepEqual(links, []string{"b", "c", "d", "journal/2024-05-31"}) {
+		t.Error("wrong links:", links)
+	}
+}
+
+func TestBuildGraph(t *testing.T) {
+	st := NewDiskStorage(t.TempDir())
+
+	for id, contents := range map[string]string{
+		"a":    "# A\n#work\n\n[[b]] and [[trip]] and [[missing]]",
+		"b":    "---\naliases: [trip]\n---\n# B\n#work\n\n[a](/a)",
+		"c":    "# C\n#work/project\n\n[[b]]",
+		"d":    "# D\n\n[[e]]",
+		"e":    "# E",
+		"lone": "# Lone\n#work",
+		"tmpl": "---\ntemplate: true\n---\n# Template\n#work\n\n[[a]]",
+	} {
+		if err := UpdateNote(st, &Note{ID: id, Contents: contents}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	graph, err := BuildGraph(st, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLinks := []GraphLink{{"a", "b"}, {"b", "a"}, {"c", "b"}, {"d", "e"}}
+	if !reflect.DeepEqual(graph.Links, expectedLinks) {
+		t.Error("wrong links:", graph.Links)
+	}
+	expectedNodes := map[string][2]int{"a": {2, 0}, "b": {3, 0}, "c": {1, 0}, "d": {1, 1}, "e": {1, 1}, "lone": {0, 2}}
+	if len(graph.Nodes) != len(expectedNodes) {
+		t.Fatal("wrong nodes:", graph.Nodes)
+	}
+	for _, node := range graph.Nodes {
+		if expected := expectedNodes[node.ID]; node.Degree != expected[0] || node.Cluster != expected[1] {
+			t.Errorf("wrong degree or cluster: %+v", node)
+		}
+	}
+	if graph.Nodes[2].Title != "C" || !reflect.DeepEqual(graph.Nodes[2].Tags, []string{"work/project"}) {
+		t.Errorf("wrong node: %+v", graph.Nodes[2])
+	}
+
+	neighbourhood, found := graph.Neighbourhood("c", 1)
+	if !found || len(neighbourhood.Nodes) != 2 || !reflect.DeepEqual(neighbourhood.Links, []GraphLink{{"c", "b"}}) {
+		t.Error("wrong neighbourhood:", neighbourhood)
+	}
+	if neighbourhood, _ := graph.Neighbourhood("c", 2); len(neighbourhood.Nodes) != 3 || len(neighbourhood.Links) != 3 {
+		t.Error("wrong neighbourhood:", neighbourhood)
+	}
+	if _, found := graph.Neighbourhood("missing", 1); found {
+		t.Error("neighbourhood of a missing note")
+	}
+
+	tagged, err := BuildGraph(st, "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tagged.Nodes) != 4 || len(tagged.Links) != 3 {
+		t.Error("wrong graph of tag:", tagged)
+	}
+
+	// notes missing from the link index are indexed on the way
+	if err := st.SaveNote(&Note{ID: "f", Contents: "# F\n\n[[e]]"}); err != nil {
+		t.Fatal(err)
+	}
+	if graph, err := BuildGraph(st, ""); err != nil || len(graph.Links) != 5 {
+		t.Error("wrong graph with an unindexed note:", graph, err)
+	}
+	links, err := st.GetAllNoteLinks()
+	if err != nil || !reflect.DeepEqual(links["f"], NoteLinks{Title: "F", Targets: []string{"e"}}) {
+		t.Error("unindexed note was not indexed:", links, err)
+	}
+	if _, found := links["tmpl"]; found {
+		t.Error("template was indexed:", links)
+	}
+}
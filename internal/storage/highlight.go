@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// DefaultHighlightStyle is the color theme of highlighted code
+// unless another one is configured.
+const DefaultHighlightStyle = "github"
+
+// CodeCache keeps highlighted code blocks between renders. Keys are
+// derived from the language, the highlighted lines and the code.
+type CodeCache interface {
+	Get(key string) (string, bool)
+	Set(key string, html string)
+}
+
+// codeFormatter writes CSS classes rather than inline styles, so the
+// highlighted html does not depend on the theme.
+func codeFormatter(highlightLines [][2]int) *chromahtml.Formatter {
+	return chromahtml.New(
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(true),
+		chromahtml.HighlightLines(highlightLines),
+		chromahtml.TabWidth(4),
+	)
+}
+
+// HighlightStyles returns the names of the available themes.
+func HighlightStyles() []string {
+	names := styles.Names()
+	sort.Strings(names)
+	return names
+}
+
+// HighlightCSS returns the stylesheet of the theme named style.
+func HighlightCSS(style string) (string, error) {
+	theme, found := styles.Registry[style]
+	if !found {
+		return "", fmt.Errorf("unknown highlight style %q", style)
+	}
+	var b strings.Builder
+	if err := codeFormatter(nil).WriteCSS(&b, theme); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// fenceInfo matches the info string of a fenced code block: the
+// language followed by the lines to highlight, e.g. "go {3-5,8}".
+var fenceInfo = regexp.MustCompile(`^\s*([^\s{]*)\s*(?:\{([0-9,\s-]*)\})?`)
+
+// parseFenceInfo returns the language and the highlighted line ranges of a fence.
+func parseFenceInfo(info string) (string, [][2]int) {
+	parts := fenceInfo.FindStringSubmatch(info)
+	ranges := make([][2]int, 0)
+	for _, field := range strings.Split(parts[2], ",") {
+		bounds := strings.SplitN(strings.TrimSpace(field), "-", 2)
+		start, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			continue
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || end < start {
+				continue
+			}
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return strings.ToLower(parts[1]), ranges
+}
+
+// highlightCode highlights code written in lang. Code in unknown
+// languages is still numbered and its lines can be highlighted.
+func highlightCode(lang string, code string, highlightLines [][2]int) (string, error) {
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	// the theme only matters for the stylesheet, not for the classes
+	if err := codeFormatter(highlightLines).Format(&b, styles.Fallback, iterator); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// codeBlockHook is a render hook that highlights fenced code blocks,
+// fences without a language with the fallback lexer. Indented code blocks
+// are left to the html renderer. codeCache may be nil.
+func codeBlockHook(codeCache CodeCache) func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	return func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		block, ok := node.(*ast.CodeBlock)
+		if !ok || !block.IsFenced {
+			return ast.GoToNext, false
+		}
+		lang, highlightLines := parseFenceInfo(string(block.Info))
+
+		sum := sha256.Sum256([]byte(fmt.Sprint(lang, highlightLines, "\x00", string(block.Literal))))
+		key := hex.EncodeToString(sum[:])
+		if codeCache != nil {
+			if html, found := codeCache.Get(key); found {
+				io.WriteString(w, html+"\n")
+				return ast.GoToNext, true
+			}
+		}
+		html, err := highlightCode(lang, string(block.Literal), highlightLines)
+		if err != nil {
+			// let the html renderer write the block as plain code
+			return ast.GoToNext, false
+		}
+		if codeCache != nil {
+			codeCache.Set(key, html)
+		}
+		io.WriteString(w, html+"\n")
+		return ast.GoToNext, true
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFenceInfo(t *testing.T) {
+	cases := []struct {
+		info  string
+		lang  string
+		lines [][2]int
+	}{
+		{"go", "go", [][2]int{}},
+		{"Python{3-5}", "python", [][2]int{{3, 5}}},
+		{"js{1, 4-6,9}", "js", [][2]int{{1, 1}, {4, 6}, {9, 9}}},
+		{"sh{5-2}", "sh", [][2]int{}},
+	}
+	for _, c := range cases {
+		lang, lines := parseFenceInfo(c.info)
+		if lang != c.lang || !reflect.DeepEqual(lines, c.lines) {
+			t.Errorf("%q: got %q %v, expected %q %v", c.info, lang, lines, c.lang, c.lines)
+		}
+	}
+}
+
+type mapCache map[string]string
+
+func (c mapCache) Get(key string) (string, bool) {
+	html, found := c[key]
+	return html, found
+}
+
+func (c mapCache) Set(key string, html string) {
+	c[key] = html
+}
+
+func TestRenderHighlightedCode(t *testing.T) {
+	note := &Note{Contents: "```go {2}\npackage main\n\nfunc main() {}\n```\n\n    indented code\n\n```\nplain fence\n```\n"}
+	codeCache := make(mapCache)
+
+	html := note.RenderHTMLCached(codeCache)
+	for _, expected := range []string{
+		`<pre tabindex="0" class="chroma">`,
+		`<span class="kn">package</span>`,
+		`<span class="line hl"><span class="ln">2</span>`,
+		`<span class="ln">3</span>`,
+		"<pre><code>indented code\n</code></pre>",
+		`<span class="ln">1</span><span class="cl">plain fence`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("%s not found in:\n%s", expected, html)
+		}
+	}
+	if len(codeCache) != 2 {
+		t.Fatal("highlighted block not cached:", codeCache)
+	}
+
+	for key := range codeCache {
+		codeCache[key] = "<pre>cached</pre>"
+	}
+	if html := note.RenderHTMLCached(codeCache); !strings.Contains(html, "<pre>cached</pre>") {
+		t.Error("cached block not used:", html)
+	}
+}
+
+func TestHighlightCSS(t *testing.T) {
+	css, err := HighlightCSS(DefaultHighlightStyle)
+	if err != nil || !strings.Contains(css, ".chroma .hl") {
+		t.Error("wrong stylesheet:", css, err)
+	}
+	if _, err := HighlightCSS("no-such-style"); err == nil {
+		t.Error("unknown style accepted")
+	}
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JournalPrefix is the prefix of the IDs of journal entries, which are
+// followed by the date of the entry (journal/2024-05-31).
+const JournalPrefix = "journal/"
+
+const journalDateFormat = "2006-01-02"
+
+// JournalID returns the ID of the journal entry of date.
+func JournalID(date time.Time) string {
+	return JournalPrefix + date.Format(journalDateFormat)
+}
+
+// JournalTitle returns the title of a new journal entry of date.
+func JournalTitle(date time.Time) string {
+	return date.Format("Monday, 2 January 2006")
+}
+
+// JournalDate returns the date of the journal entry with the given id, it
+// reports false if id is not the ID of a journal entry.
+func JournalDate(id string) (time.Time, bool) {
+	if !strings.HasPrefix(id, JournalPrefix) {
+		return time.Time{}, false
+	}
+	date, err := time.ParseInLocation(journalDateFormat, strings.TrimPrefix(id, JournalPrefix), time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// JournalEntries returns the IDs of the journal entries among noteIDs, by date.
+func JournalEntries(noteIDs []string) []string {
+	entries := make([]string, 0)
+	for _, noteID := range noteIDs {
+		if _, ok := JournalDate(noteID); ok {
+			entries = append(entries, noteID)
+		}
+	}
+	// the dates are zero padded, so the IDs sort by date
+	sort.Strings(entries)
+	return entries
+}
+
+// JournalNavigation returns the links from the journal entry with the
+// given id to the previous and the next entries among noteIDs and to the
+// calendar, or "" if id is not the ID of a journal entry.
+func JournalNavigation(noteIDs []string, id string) string {
+	if _, ok := JournalDate(id); !ok {
+		return ""
+	}
+	entries := JournalEntries(noteIDs)
+	i := sort.SearchStrings(entries, id)
+	link := func(class string, id string, label string) string {
+		return fmt.Sprintf(`<a class="%s" href="/%s">%s</a>`, class, html.EscapeString(id), html.EscapeString(label))
+	}
+
+	links := make([]string, 0, 3)
+	if i > 0 {
+		links = append(links, link("journal-previous", entries[i-1], "← "+strings.TrimPrefix(entries[i-1], JournalPrefix)))
+	}
+	links = append(links, link("journal-calendar", "lsjournal", "calendar"))
+	if i < len(entries) && entries[i] == id {
+		i++
+	}
+	if i < len(entries) {
+		links = append(links, link("journal-next", entries[i], strings.TrimPrefix(entries[i], JournalPrefix)+" →"))
+	}
+	return `<nav class="journal-nav">` + strings.Join(links, " ") + "</nav>\n"
+}
+
+// GenerateLsJournal generates the note showing a calendar of each month
+// having journal entries, newest first, with links to the entries.
+func (note *Note) GenerateLsJournal(storage Storage) error {
+	note.ID = "lsjournal"
+	note.Title = "lsjournal"
+	note.LastEdit = time.Now()
+
+	allNoteIDs, err := storage.GetAllNoteIDs()
+	if err != nil {
+		return err
+	}
+	entries := JournalEntries(allNoteIDs)
+
+	// generate contents
+	lsJournalAsMarkdown := "# Journal\n"
+	lsJournalAsMarkdown += "This note is autogenerated, any user changes to it will be ignored.\n\n"
+	if len(entries) == 0 {
+		lsJournalAsMarkdown += "There are no journal entries yet.\n"
+	}
+	written := make(map[string]bool)
+	for i := len(entries) - 1; i >= 0; i-- {
+		date, _ := JournalDate(entries[i])
+		month := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.Local)
+		if written[month.Format("2006-01")] {
+			continue
+		}
+		written[month.Format("2006-01")] = true
+		lsJournalAsMarkdown += "\n" + calendarMonth(month, entries)
+	}
+
+	note.Contents = lsJournalAsMarkdown
+	return nil
+}
+
+// calendarMonth renders the month starting at month as a table of weeks
+// starting on Monday, the days having entries link to them.
+func calendarMonth(month time.Time, entries []string) string {
+	result := "## " + month.Format("January 2006") + "\n\n"
+	result += "|Mo|Tu|We|Th|Fr|Sa|Su|\n"
+	result += "|---|---|---|---|---|---|---|\n"
+	// the week of the first day is padded from Monday on
+	week := strings.Repeat("| ", (int(month.Weekday())+6)%7)
+	for day := month; day.Month() == month.Month(); day = day.AddDate(0, 0, 1) {
+		id := JournalID(day)
+		if i := sort.SearchStrings(entries, id); i < len(entries) && entries[i] == id {
+			week += fmt.Sprintf("|[%d](/%s)", day.Day(), id)
+		} else {
+			week += fmt.Sprintf("|%d", day.Day())
+		}
+		if day.Weekday() == time.Sunday {
+			result += week + "|\n"
+			week = ""
+		}
+	}
+	if week != "" {
+		// and the week of the last day up to Sunday
+		last := month.AddDate(0, 1, -1)
+		result += week + strings.Repeat("| ", 6-(int(last.Weekday())+6)%7) + "|\n"
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalIDs(t *testing.T) {
+	date := time.Date(2024, 5, 31, 22, 0, 0, 0, time.Local)
+	if id := JournalID(date); id != "journal/2024-05-31" {
+		t.Error("wrong journal ID:", id)
+	}
+	if parsed, ok := JournalDate("journal/2024-05-31"); !ok || !parsed.Equal(time.Date(2024, 5, 31, 0, 0, 0, 0, time.Local)) {
+		t.Error("wrong journal date:", parsed, ok)
+	}
+	for _, id := range []string{"2024-05-31", "journal/2024-05-32", "journal/notes"} {
+		if _, ok := JournalDate(id); ok {
+			t.Error("not a journal entry:", id)
+		}
+	}
+	if title := JournalTitle(date); title != "Friday, 31 May 2024" {
+		t.Error("wrong title:", title)
+	}
+}
+
+func TestJournalNavigation(t *testing.T) {
+	noteIDs := []string{"journal/2024-06-02", "ls", "journal/2024-05-31", "journal/notes", "journal/2024-06-10"}
+	if entries := JournalEntries(noteIDs); !reflect.DeepEqual(entries, []string{"journal/2024-05-31", "journal/2024-06-02", "journal/2024-06-10"}) {
+		t.Error("wrong entries:", entries)
+	}
+
+	html := JournalNavigation(noteIDs, "journal/2024-06-02")
+	expected := `<nav class="journal-nav"><a class="journal-previous" href="/journal/2024-05-31">← 2024-05-31</a> ` +
+		`<a class="journal-calendar" href="/lsjournal">calendar</a> ` +
+		`<a class="journal-next" href="/journal/2024-06-10">2024-06-10 →</a></nav>` + "\n"
+	if html != expected {
+		t.Error("wrong navigation:", html)
+	}
+	// an entry that is not stored yet sits between its neighbours
+	if html := JournalNavigation(noteIDs, "journal/2024-06-05"); !strings.Contains(html, "← 2024-06-02") || !strings.Contains(html, "2024-06-10 →") {
+		t.Error("wrong navigation:", html)
+	}
+	if html := JournalNavigation(noteIDs, "journal/2024-05-31"); strings.Contains(html, "journal-previous") {
+		t.Error("first entry has a previous entry:", html)
+	}
+	if html := JournalNavigation(noteIDs, "ls"); html != "" {
+		t.Error("navigation of a note that is not an entry:", html)
+	}
+}
+
+func TestGenerateLsJournal(t *testing.T) {
+	dir := t.TempDir()
+	st := NewDiskStorage(dir)
+
+	for _, id := range []string{"journal/2024-05-31", "journal/2024-06-02", "journal/2024-06-03", "other"} {
+		if err := st.SaveNote(&Note{ID: id, Contents: "# " + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.SaveNote(&Note{ID: "journal//x"}); err == nil {
+		t.Error("note with an empty segment saved")
+	}
+	if _, err := st.LoadNote("../tagidx"); err == nil {
+		t.Error("note outside of the notes loaded")
+	}
+
+	note := new(Note)
+	if err := note.GenerateLsJournal(st); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"## June 2024\n\n|Mo|Tu|We|Th|Fr|Sa|Su|\n|---|---|---|---|---|---|---|\n" +
+			"| | | | | |1|[2](/journal/2024-06-02)|\n|[3](/journal/2024-06-03)|4|5|6|7|8|9|\n",
+		"|24|25|26|27|28|29|30|\n\n## May 2024",
+		"|27|28|29|30|[31](/journal/2024-05-31)| | |\n",
+	} {
+		if !strings.Contains(note.Contents, expected) {
+			t.Errorf("missing %q in %s", expected, note.Contents)
+		}
+	}
+	if html := note.RenderHTML(); !strings.Contains(html, `<td><a href="/journal/2024-06-03">3</a></td>`) {
+		t.Error("calendar is not a table:", html)
+	}
+
+	if err := st.DeleteNote("journal/2024-05-31"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"journal/2024-06-02", "journal/2024-06-03"} {
+		if err := st.DeleteNote(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes", "journal")); !os.IsNotExist(err) {
+		t.Error("empty directory of nested notes kept:", err)
+	}
+	if noteIDs, err := st.GetAllNoteIDs(); err != nil || !reflect.DeepEqual(noteIDs, []string{"other"}) {
+		t.Error("wrong notes:", noteIDs, err)
+	}
+}
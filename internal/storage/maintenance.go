@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sbrki/snote/internal/util"
+)
+
+// AutogeneratedNoteIDs are the IDs of notes that are generated on the fly
+// (see Note.GenerateLs, Note.GenerateLsTag, Note.GenerateLsTodo and
+// Note.GenerateLsJournal) and are never stored.
+var AutogeneratedNoteIDs = []string{"ls", "lstag", "lstodo", "lsjournal"}
+
+// IsAutogenerated reports whether id is the ID of an autogenerated note.
+func IsAutogenerated(id string) bool {
+	return util.SliceContainsString(AutogeneratedNoteIDs, id)
+}
+
+// ReservedNoteIDs are the IDs of the pages of the web interface, like
+// /today. Their routes come before the one of notes, so notes with these
+// IDs could not be opened.
+var ReservedNoteIDs = []string{"today", "review", "graph"}
+
+// IsReserved reports whether id can not be used for a stored note because
+// it is the ID of an autogenerated note or of a page.
+func IsReserved(id string) bool {
+	return IsAutogenerated(id) || util.SliceContainsString(ReservedNoteIDs, id)
+}
+
+// GenerateNote generates the autogenerated note with the given id.
+func GenerateNote(storage Storage, id string) (*Note, error) {
+	note := new(Note)
+	var err error
+	switch id {
+	case "ls":
+		err = note.GenerateLs(storage)
+	case "lstag":
+		err = note.GenerateLsTag(storage)
+	case "lstodo":
+		err = note.GenerateLsTodo(storage)
+	case "lsjournal":
+		err = note.GenerateLsJournal(storage)
+	default:
+		return nil, fmt.Errorf("%s is not an autogenerated note", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// UpdateNote saves an edited note: its title is parsed from the contents,
+// its last edit time is set to now, and its tags and front matter fields
+// are updated in the indexes. The creation time is taken from the front
+// matter. Otherwise, if note does not carry a creation time, the one of
+// the stored note is kept, or it is set to now for new notes.
+func UpdateNote(storage Storage, note *Note) error {
+	note.LastEdit = time.Now()
+	note.Title = note.ParseTitle()
+	if fm, err := note.ParseFrontMatter(); err == nil && fm != nil && !fm.Created.IsZero() {
+		note.Created = fm.Created
+	}
+	if note.Created.IsZero() {
+		if existing, err := storage.LoadNote(note.ID); err == nil {
+			note.Created = existing.Created
+		} else {
+			note.Created = note.LastEdit
+		}
+	}
+
+	if err := storage.SaveNote(note); err != nil {
+		return err
+	}
+	return IndexNote(storage, note)
+}
+
+// IndexNote updates the tag, field, block, card and link indexes with the
+// tags, the front matter fields, the blocks, the flashcards and the links
+// parsed from note. Templates have no cards or links in the indexes.
+// A note with invalid front matter keeps the tags of its `tags:` code span
+// and has no fields.
+func IndexNote(storage Storage, note *Note) error {
+	if err := storage.SetNoteTags(note.ID, note.ParseTags()); err != nil {
+		return err
+	}
+	if err := storage.SetNoteFields(note.ID, note.ParseFields()); err != nil {
+		return err
+	}
+	if err := storage.SetNoteBlocks(note.ID, note.ParseBlockIDs()); err != nil {
+		return err
+	}
+	if err := storage.SetNoteCards(note.ID, note.IndexedCards()); err != nil {
+		return err
+	}
+	return storage.SetNoteLinks(note.ID, note.ParseNoteLinks())
+}
+
+// UnindexNote removes the note with the given id from the tag, field, block,
+// card and link indexes, it is used when the note is deleted.
+func UnindexNote(storage Storage, id string) error {
+	if err := storage.SetNoteTags(id, []string{}); err != nil {
+		return err
+	}
+	if err := storage.SetNoteFields(id, map[string][]string{}); err != nil {
+		return err
+	}
+	if err := storage.SetNoteBlocks(id, []string{}); err != nil {
+		return err
+	}
+	if err := storage.SetNoteCards(id, []Card{}); err != nil {
+		return err
+	}
+	return storage.SetNoteLinks(id, nil)
+}
+
+// RenameNote moves the note oldID to newID. The IDs of flashcards depend on
+// the ID of their note (see cardID), so the review state of each card is
+// carried over to the card with the same question in the renamed note.
+func RenameNote(storage Storage, oldID string, newID string) error {
+	note, err := storage.LoadNote(oldID)
+	if err != nil {
+		return err
+	}
+	cards, err := storage.GetAllCards()
+	if err != nil {
+		return err
+	}
+	reviews := make(map[string]Review)
+	for _, card := range cards {
+		if card.NoteID == oldID {
+			reviews[card.Question] = card.Review
+		}
+	}
+
+	note.ID = newID
+	if err := UpdateNote(storage, note); err != nil {
+		return err
+	}
+	for _, card := range note.IndexedCards() {
+		if review, found := reviews[card.Question]; found {
+			if err := storage.SetCardReview(card.ID, review); err != nil {
+				return err
+			}
+		}
+	}
+	if err := storage.DeleteNote(oldID); err != nil {
+		return err
+	}
+	return UnindexNote(storage, oldID)
+}
+
+// Reindex rebuilds the tag, field, block, card and link indexes of storage from
+// each stored note. Index entries pointing at notes that no longer exist
+// are removed.
+// It returns the number of reindexed notes.
+func Reindex(storage Storage) (int, error) {
+	allNoteIDs, err := storage.GetAllNoteIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, noteID := range allNoteIDs {
+		note, err := storage.LoadNote(noteID)
+		if err != nil {
+			return 0, err
+		}
+		if err := IndexNote(storage, note); err != nil {
+			return 0, err
+		}
+	}
+
+	// drop index entries of notes that are gone
+	tagIndex, err := storage.GetAllNoteTags()
+	if err != nil {
+		return 0, err
+	}
+	fieldIndex, err := storage.GetAllNoteFields()
+	if err != nil {
+		return 0, err
+	}
+	blockIndex, err := storage.GetAllNoteBlocks()
+	if err != nil {
+		return 0, err
+	}
+	indexedNoteIDs := make([][]string, 0)
+	for _, noteIDs := range tagIndex {
+		indexedNoteIDs = append(indexedNoteIDs, noteIDs)
+	}
+	for _, noteIDs := range blockIndex {
+		indexedNoteIDs = append(indexedNoteIDs, noteIDs)
+	}
+	cards, err := storage.GetAllCards()
+	if err != nil {
+		return 0, err
+	}
+	for _, card := range cards {
+		indexedNoteIDs = append(indexedNoteIDs, []string{card.NoteID})
+	}
+	for _, values := range fieldIndex {
+		for _, noteIDs := range values {
+			indexedNoteIDs = append(indexedNoteIDs, noteIDs)
+		}
+	}
+	linkIndex, err := storage.GetAllNoteLinks()
+	if err != nil {
+		return 0, err
+	}
+	for noteID := range linkIndex {
+		indexedNoteIDs = append(indexedNoteIDs, []string{noteID})
+	}
+	staleNoteIDs := make([]string, 0)
+	for _, noteIDs := range indexedNoteIDs {
+		for _, noteID := range noteIDs {
+			if !util.SliceContainsString(allNoteIDs, noteID) &&
+				!IsAutogenerated(noteID) &&
+				!util.SliceContainsString(staleNoteIDs, noteID) {
+				staleNoteIDs = append(staleNoteIDs, noteID)
+			}
+		}
+	}
+	for _, noteID := range staleNoteIDs {
+		if err := UnindexNote(storage, noteID); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(allNoteIDs), nil
+}
+
+// UnusedBlobIDs returns the IDs of all blobs in storage that are
+// not referenced by any note.
+func UnusedBlobIDs(storage Storage) ([]string, error) {
+	usedBlobIDs := make([]string, 0)
+	allNoteIDs, err := storage.GetAllNoteIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	// collect all user-uploaded blob IDs from all notes in storage
+	for _, noteID := range allNoteIDs {
+		note, err := storage.LoadNote(noteID)
+		if err != nil {
+			return nil, err
+		}
+		usedBlobIDs = append(usedBlobIDs, note.ParseBlobIDs()...)
+	}
+
+	// if there are no user-uploaded blobs in any of the notes, report nothing.
+	// acts as a sanity check, if for some reason note loading/parsing
+	// failed, prevent deleting all of the blobs currently stored in storage.
+	if len(usedBlobIDs) == 0 {
+		return []string{}, nil
+	}
+
+	// collect all blob IDs across all blobs currently stored in storage
+	allStorageBlobIDs, err := storage.GetAllBlobIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	// unused blobs are present in allStorageBlobIDs but not in usedBlobIDs.
+	unusedBlobIDs := make([]string, 0)
+	for _, storageBlobID := range allStorageBlobIDs {
+		if !util.SliceContainsString(usedBlobIDs, storageBlobID) {
+			unusedBlobIDs = append(unusedBlobIDs, storageBlobID)
+		}
+	}
+	return unusedBlobIDs, nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"html"
+	"io"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/sbrki/snote/internal/mathml"
+)
+
+// mathHook is a render hook that converts $inline$ and $$display$$ math
+// to MathML. Formulas that cannot be converted are shown as written,
+// marked with the error, so that a typo does not break the whole note.
+func mathHook(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	var tex, delimiter string
+	display := false
+	switch n := node.(type) {
+	case *ast.Math:
+		tex, delimiter = string(n.Literal), "$"
+	case *ast.MathBlock:
+		if !entering {
+			return ast.GoToNext, true
+		}
+		tex, delimiter, display = string(n.Literal), "$$", true
+	default:
+		return ast.GoToNext, false
+	}
+
+	converted, err := mathml.Convert(tex, display)
+	if err != nil {
+		converted = `<code class="math-error" title="` + html.EscapeString(err.Error()) + `">` +
+			html.EscapeString(delimiter+tex+delimiter) + "</code>"
+	}
+	if display {
+		converted = `<div class="math display">` + converted + "</div>\n"
+	}
+	io.WriteString(w, converted)
+	return ast.GoToNext, true
+}
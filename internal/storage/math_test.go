@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMath(t *testing.T) {
+	note := &Note{Contents: "Euler: $e^{i\\pi} + 1 = 0$ and $\\oops$.\n\n$$\n\\frac{a}{b}\n$$\n"}
+
+	html := note.RenderHTML()
+	for _, expected := range []string{
+		`<math xmlns="http://www.w3.org/1998/Math/MathML" display="inline"><semantics><mrow><msup><mi>e</mi>`,
+		`<div class="math display"><math xmlns="http://www.w3.org/1998/Math/MathML" display="block"><semantics><mfrac>`,
+		`<code class="math-error" title="unknown command \oops at position 1">$\oops$</code>`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("%s not found in:\n%s", expected, html)
+		}
+	}
+}
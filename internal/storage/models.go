@@ -2,13 +2,15 @@ package storage
 
 import (
 	"fmt"
-	"sort"
+	"io"
+	"regexp"
 	"strings"
 	"time"
 
-	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
+	"github.com/sbrki/snote/internal/util"
 )
 
 type Note struct {
@@ -16,19 +18,73 @@ type Note struct {
 	Title    string    `json:"title"`
 	Contents string    `json:"contents"`
 	LastEdit time.Time `json:"last_edit"`
+	// Created is zero for notes created before it was recorded.
+	Created time.Time `json:"created"`
 }
 
-func (note *Note) RenderHTML() string {
+// NoteInfo summarizes a note without its contents,
+// it is used for listing notes.
+type NoteInfo struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Size     int       `json:"size"`
+	LastEdit time.Time `json:"last_edit"`
+	Tags     []string  `json:"tags"`
+	// Fields are the front matter fields of the note, see FrontMatter.Fields.
+	Fields map[string][]string `json:"fields,omitempty"`
+}
+
+// fenceLineRanges matches fence lines with highlighted lines ("```go {3-5}"),
+// which the parser only accepts without the space before the braces.
+var fenceLineRanges = regexp.MustCompile("(?m)^( {0,3}(?:`{3,}|~{3,})[ \t]*[^\\s{`~]+)[ \t]+(\\{[0-9, -]*\\})[ \t]*$")
+
+// ParseMarkdown parses the contents of a note with the extensions
+// used everywhere notes are rendered. The front matter is left out.
+func ParseMarkdown(contents string) ast.Node {
+	contents = stripFrontMatter(contents)
+	contents = fenceLineRanges.ReplaceAllString(contents, "$1$2")
 	parser := parser.NewWithExtensions(parser.CommonExtensions)
-	html := markdown.ToHTML([]byte(note.Contents), parser, nil)
-	return string(html)
+	return parser.Parse([]byte(contents))
+}
+
+// RenderHTML renders the note to html. The front matter is shown as a
+// metadata panel above the contents, inline tags link to /lstag and
+// embedded notes are shown as links (see RenderHTMLEmbedding). Every heading gets a slug anchor
+// and a permalink, a [TOC] paragraph becomes the table of contents,
+// task list items get checkboxes, fenced code blocks are highlighted and
+// math is converted to MathML.
+func (note *Note) RenderHTML() string {
+	return note.RenderHTMLCached(nil)
 }
 
+// RenderHTMLCached renders the note like RenderHTML, reusing the
+// highlighted code blocks kept in codeCache.
+func (note *Note) RenderHTMLCached(codeCache CodeCache) string {
+	html, _ := note.RenderHTMLEmbedding(nil, codeCache)
+	return html
+}
+
+// renderHooks combines render hooks, the first hook handling a node wins.
+func renderHooks(hooks ...html.RenderNodeFunc) html.RenderNodeFunc {
+	return func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		for _, hook := range hooks {
+			if status, handled := hook(w, node, entering); handled {
+				return status, true
+			}
+		}
+		return ast.GoToNext, false
+	}
+}
+
+// ParseTitle returns the title of the front matter, or else the
+// contents of the first heading.
 func (note *Note) ParseTitle() string {
+	if fm, err := note.ParseFrontMatter(); err == nil && fm != nil && fm.Title != "" {
+		return fm.Title
+	}
 	title := ""
 
-	parser := parser.NewWithExtensions(parser.CommonExtensions)
-	rootNode := parser.Parse([]byte(note.Contents))
+	rootNode := ParseMarkdown(note.Contents)
 
 	// return contents of first matched heading
 	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
@@ -44,12 +100,23 @@ func (note *Note) ParseTitle() string {
 	return title
 }
 
+// ParseTags returns the tags of the front matter, the ones listed in code
+// spans starting with "tags:" and the inline #tags outside of code.
+// Tags can be hierarchical ("area/sub/topic").
 func (note *Note) ParseTags() []string {
-	// TODO
 	tags := make([]string, 0)
+	add := func(tag string) {
+		if tag != "" && !util.SliceContainsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if fm, err := note.ParseFrontMatter(); err == nil && fm != nil {
+		for _, tag := range fm.Tags {
+			add(tag)
+		}
+	}
 
-	parser := parser.NewWithExtensions(parser.CommonExtensions)
-	rootNode := parser.Parse([]byte(note.Contents))
+	rootNode := ParseMarkdown(note.Contents)
 
 	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
 		if entering {
@@ -61,9 +128,13 @@ func (note *Note) ParseTags() []string {
 					)[1]
 					rawTagsAsSlice := strings.Split(rawTags, ",")
 					for _, tag := range rawTagsAsSlice {
-						tags = append(tags, strings.ToLower(strings.TrimSpace(tag)))
+						add(normalizeTag(tag))
 					}
-					return ast.Terminate // terminate on first parsed heading
+				}
+			case *ast.Text:
+				inlineTags, _ := hashtags(n.Literal)
+				for _, tag := range inlineTags {
+					add(tag)
 				}
 			}
 		}
@@ -75,8 +146,7 @@ func (note *Note) ParseTags() []string {
 
 func (note *Note) ParseBlobIDs() []string {
 
-	parser := parser.NewWithExtensions(parser.CommonExtensions)
-	rootNode := parser.Parse([]byte(note.Contents))
+	rootNode := ParseMarkdown(note.Contents)
 
 	// candidateURLs will contain all parsed URLs of all Images and Links. Some of them
 	// will probably point to non-user-uploaded blobs, so they will have to be filtered.
@@ -127,7 +197,9 @@ func (note *Note) GenerateLs(storage Storage) error {
 	for _, noteID := range allNoteIDs {
 		note, err := storage.LoadNote(noteID)
 		if err != nil {
-			return err
+			// one corrupt note does not hide all others, `snote fsck` tells more
+			lsAsMarkdown += fmt.Sprintf("|*unreadable*|[/%s](/%s)|%s|||\n", noteID, noteID, noteID)
+			continue
 		}
 		noteLine := "|"
 		noteLine += "**" + note.Title + "** |"
@@ -141,36 +213,23 @@ func (note *Note) GenerateLs(storage Storage) error {
 	return nil
 }
 
+// GenerateLsTag generates the note listing all tags as a tree following
+// their "/" separated hierarchy. Every tag shows the number of notes tagged
+// with it or with one of its descendants, and expands to its notes.
 func (note *Note) GenerateLsTag(storage Storage) error {
 	note.ID = "lstag"
 	note.Title = "lstag"
 	note.LastEdit = time.Now()
 
-	// generate contents
-	lsTagAsMarkdown := "# All note tags\n"
-	lsTagAsMarkdown += "This note is autogenerated, any user changes to it will be ignored.\n\n"
-	lsTagAsMarkdown += "|tag|note urls|\n"
-	lsTagAsMarkdown += "|---|---------|\n"
-
 	tagIndex, err := storage.GetAllNoteTags()
 	if err != nil {
 		return err
 	}
 
-	allNoteTags := make([]string, 0)
-	for tag := range tagIndex {
-		allNoteTags = append(allNoteTags, tag)
-	}
-
-	sort.Strings(allNoteTags)
-
-	for _, tag := range allNoteTags {
-		lsTagAsMarkdown += "|`" + tag + "`|"
-		for _, noteID := range tagIndex[tag] {
-			lsTagAsMarkdown += fmt.Sprintf("[%s](/%s) ", noteID, noteID)
-		}
-		lsTagAsMarkdown += "|\n"
-	}
+	// generate contents
+	lsTagAsMarkdown := "# All note tags\n"
+	lsTagAsMarkdown += "This note is autogenerated, any user changes to it will be ignored.\n\n"
+	lsTagAsMarkdown += "<ul class=\"tag-tree\">\n" + tagTree(tagIndex).html(0) + "</ul>\n"
 
 	note.Contents = lsTagAsMarkdown
 	return nil
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestGenerateLsUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	st := NewDiskStorage(dir)
+	if err := UpdateNote(st, &Note{ID: "good", Title: "Good", Contents: "# Good\n\n- [ ] task\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "notes", "corrupt.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ls := new(Note)
+	if err := ls.GenerateLs(st); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ls.Contents, "**Good** |[/good](/good)") || !strings.Contains(ls.Contents, "|*unreadable*|[/corrupt](/corrupt)|") {
+		t.Error("wrong listing:", ls.Contents)
+	}
+	todo := new(Note)
+	if err := todo.GenerateLsTodo(st); err != nil || !strings.Contains(todo.Contents, "task") {
+		t.Error("wrong todo listing:", todo.Contents, err)
+	}
+}
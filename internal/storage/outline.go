@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// Heading is a heading of a note, with the headings of its section as children.
+type Heading struct {
+	Level    int        `json:"level"`
+	Text     string     `json:"text"`
+	Slug     string     `json:"slug"`
+	Children []*Heading `json:"children"`
+}
+
+// tocMarker is replaced by the table of contents when rendering.
+const tocMarker = "[TOC]"
+
+// Slug turns heading text into an anchor the way GitHub does: lowercase,
+// with spaces replaced by dashes and punctuation removed.
+func Slug(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// headingText concatenates the text of all leaves below a heading.
+func headingText(heading *ast.Heading) string {
+	var b strings.Builder
+	ast.WalkFunc(heading, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch n := node.(type) {
+		case *ast.Text:
+			b.Write(n.Literal)
+		case *ast.Code:
+			b.Write(n.Literal)
+		}
+		return ast.GoToNext
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// assignHeadingIDs gives every heading below rootNode a slug anchor, unless
+// it has an explicit one ({#id}). Repeated slugs are numbered, like the
+// html renderer does. It returns the headings in document order.
+func assignHeadingIDs(rootNode ast.Node) []*Heading {
+	headings := make([]*Heading, 0)
+	used := make(map[string]int)
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		n, ok := node.(*ast.Heading)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		text := headingText(n)
+		if n.HeadingID == "" {
+			slug := Slug(text)
+			if slug == "" {
+				slug = "section"
+			}
+			if count, found := used[slug]; found {
+				used[slug] = count + 1
+				slug = fmt.Sprintf("%s-%d", slug, count+1)
+			}
+			n.HeadingID = slug
+		}
+		used[n.HeadingID] = 0
+		headings = append(headings, &Heading{Level: n.Level, Text: text, Slug: n.HeadingID, Children: []*Heading{}})
+		return ast.SkipChildren
+	})
+	return headings
+}
+
+// headingTree nests each heading under the closest preceding heading of a higher level.
+func headingTree(headings []*Heading) []*Heading {
+	roots := make([]*Heading, 0)
+	stack := make([]*Heading, 0)
+	for _, heading := range headings {
+		for len(stack) > 0 && stack[len(stack)-1].Level >= heading.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, heading)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, heading)
+		}
+		stack = append(stack, heading)
+	}
+	return roots
+}
+
+// ParseOutline returns the heading tree of the note.
+func (note *Note) ParseOutline() []*Heading {
+	return headingTree(assignHeadingIDs(ParseMarkdown(note.Contents)))
+}
+
+// addPermalinks appends a link to its own anchor to every heading.
+func addPermalinks(rootNode ast.Node) {
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		if n, ok := node.(*ast.Heading); ok && entering {
+			permalink := &ast.HTMLSpan{}
+			permalink.Literal = []byte(`<a class="permalink" href="#` + html.EscapeString(n.HeadingID) + `" aria-hidden="true">¶</a>`)
+			ast.AppendChild(n, permalink)
+			return ast.SkipChildren
+		}
+		return ast.GoToNext
+	})
+}
+
+// expandTOC replaces paragraphs consisting of only the [TOC] marker with
+// a nested list of links to the headings.
+func expandTOC(rootNode ast.Node, outline []*Heading) {
+	markers := make([]ast.Node, 0)
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		if n, ok := node.(*ast.Paragraph); ok && entering {
+			children := n.GetChildren()
+			if len(children) == 1 {
+				if text, ok := children[0].(*ast.Text); ok && strings.TrimSpace(string(text.Literal)) == tocMarker {
+					markers = append(markers, n)
+				}
+			}
+			return ast.SkipChildren
+		}
+		return ast.GoToNext
+	})
+
+	for _, marker := range markers {
+		toc := &ast.HTMLBlock{}
+		toc.Literal = []byte(`<nav class="toc">` + tocList(outline) + "</nav>")
+		parent := marker.GetParent()
+		children := parent.GetChildren()
+		for i, child := range children {
+			if child == marker {
+				children[i] = toc
+			}
+		}
+		toc.SetParent(parent)
+	}
+}
+
+func tocList(headings []*Heading) string {
+	if len(headings) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<ul>")
+	for _, heading := range headings {
+		b.WriteString(`<li><a href="#` + html.EscapeString(heading.Slug) + `">` + html.EscapeString(heading.Text) + "</a>")
+		b.WriteString(tocList(heading.Children))
+		b.WriteString("</li>")
+	}
+	b.WriteString("</ul>")
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOutline(t *testing.T) {
+	note := &Note{Contents: "# Guide\n\n[TOC]\n\n## Setup & `install`\n### Linux\n## Usage\n## Usage\n# Appendix {#extra}\n"}
+
+	outline := note.ParseOutline()
+	if len(outline) != 2 || outline[0].Slug != "guide" || outline[1].Slug != "extra" {
+		t.Fatal("wrong top level:", outline)
+	}
+	sections := outline[0].Children
+	if len(sections) != 3 {
+		t.Fatal("wrong sections:", sections)
+	}
+	if sections[0].Text != "Setup & install" || sections[0].Slug != "setup--install" {
+		t.Error("wrong heading:", sections[0])
+	}
+	if len(sections[0].Children) != 1 || sections[0].Children[0].Slug != "linux" {
+		t.Error("subsection not nested:", sections[0].Children)
+	}
+	if sections[1].Slug != "usage" || sections[2].Slug != "usage-1" {
+		t.Error("repeated headings not numbered:", sections[1], sections[2])
+	}
+
+	html := note.RenderHTML()
+	for _, expected := range []string{
+		`<h2 id="setup--install">`,
+		`<a class="permalink" href="#linux" aria-hidden="true">`,
+		`<nav class="toc"><ul><li><a href="#guide">Guide</a><ul><li><a href="#setup--install">Setup &amp; install</a>`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("%s not found in:\n%s", expected, html)
+		}
+	}
+	if strings.Contains(html, "[TOC]") {
+		t.Error("toc marker not replaced")
+	}
+}
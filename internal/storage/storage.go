@@ -2,8 +2,43 @@ package storage
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
 )
 
+// ErrInvalidNoteID is returned for note IDs that cannot be stored: IDs
+// with empty segments (like "a//b") or segments starting with a dot.
+var ErrInvalidNoteID = errors.New("invalid note ID")
+
+// ValidNoteID reports whether id can be stored, see ErrInvalidNoteID.
+func ValidNoteID(id string) bool {
+	for _, segment := range strings.Split(id, "/") {
+		// hidden files are temporary files of writeFileAtomic
+		if segment == "" || strings.HasPrefix(segment, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+var nonIDChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// SlugID derives a note ID from text, like a title or a file path, for
+// importers: it is lowercased and everything but letters, digits, dots
+// and underscores is replaced by dashes, e.g. "Trip to Split" becomes
+// "trip-to-split". Text without any of these becomes "note".
+func SlugID(text string) string {
+	id := nonIDChars.ReplaceAllString(strings.ToLower(text), "-")
+	id = strings.Trim(id, "-.")
+	if id == "" {
+		id = "note"
+	}
+	return id
+}
+
 // Storage interface represents storage for both notes and user-uploaded blobs.
 // In order to add a new storage type to snote, this interface has to be
 // implemented. Performance wise this interface is not optimal,as it aims to be
@@ -16,7 +51,8 @@ type Storage interface {
 	SaveNote(note *Note) error
 	// DeleteNote removes a Note from storage.
 	DeleteNote(id string) error
-	// GetAllNoteIDs fetches IDs of all  notes currently in storage.
+	// GetAllNoteIDs fetches IDs of all  notes currently in storage. IDs can
+	// contain slashes, e.g. journal/2024-05-31.
 	// It is primarily used to display all notes.
 	GetAllNoteIDs() ([]string, error)
 
@@ -44,4 +80,70 @@ type Storage interface {
 	SetNoteTags(id string, tags []string) error
 	// GetAllNoteTags fetches all tags along with all the corresponding note IDs.
 	GetAllNoteTags() (map[string][]string, error)
+
+	// SetNoteFields sets the front matter fields of a particular note
+	// (see Note.ParseFrontMatter), replacing the ones it had before, like
+	// SetNoteTags. Fields are kept in an index of their own.
+	SetNoteFields(id string, fields map[string][]string) error
+	// GetAllNoteFields fetches all fields, with all their values along with
+	// the IDs of the notes having them.
+	GetAllNoteFields() (map[string]map[string][]string, error)
+
+	// SetNoteBlocks sets the IDs of the blocks (see Note.ParseBlocks) of a
+	// particular note, replacing the ones it had before, like SetNoteTags.
+	SetNoteBlocks(id string, blockIDs []string) error
+	// GetAllNoteBlocks fetches all block IDs along with the IDs of the notes
+	// holding them.
+	GetAllNoteBlocks() (map[string][]string, error)
+
+	// SetNoteCards sets the flashcards (see Note.ParseCards) of a particular
+	// note, replacing the ones it had before, like SetNoteTags. Cards the
+	// note already had keep their review state, new cards start with the
+	// review state they carry.
+	SetNoteCards(id string, cards []Card) error
+	// GetAllCards fetches all flashcards by their IDs, with their review state.
+	GetAllCards() (map[string]Card, error)
+	// SetCardReview sets the review state of the card with the given ID.
+	SetCardReview(cardID string, review Review) error
+
+	// SetNoteLinks sets the title and the link targets (see
+	// Note.ParseLinks) of a particular note in the link index, which
+	// BuildGraph uses. A nil links removes the note from the index.
+	SetNoteLinks(id string, links *NoteLinks) error
+	// GetAllNoteLinks fetches the link index entries of all notes by their IDs.
+	GetAllNoteLinks() (map[string]NoteLinks, error)
+}
+
+// Backends returns the names of all storage implementations
+// that can be opened with Open.
+func Backends() []string {
+	return []string{"disk"}
+}
+
+// Open opens the storage implementation called backend at path.
+func Open(backend string, path string) (Storage, error) {
+	switch backend {
+	case "disk":
+		return NewDiskStorage(path), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// OpenExisting is like Open, but fails if there is no storage at path
+// instead of creating one.
+func OpenExisting(backend string, path string) (Storage, error) {
+	switch backend {
+	case "disk":
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", path)
+		}
+		return NewDiskStorage(path), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
 }
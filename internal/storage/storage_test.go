@@ -0,0 +1,19 @@
+package storage
+
+import (
+	"path"
+	"testing"
+)
+
+func TestOpenExisting(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenExisting("disk", path.Join(dir, "typo")); err == nil {
+		t.Error("opened a missing storage")
+	}
+	if _, err := OpenExisting("disk", dir); err != nil {
+		t.Error(err)
+	}
+	if _, err := OpenExisting("s3", dir); err == nil {
+		t.Error("opened an unknown backend")
+	}
+}
@@ -0,0 +1,14 @@
+// Package storagetest provides helpers for tests using a storage.
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/sbrki/snote/internal/storage"
+)
+
+// TempStorage returns a disk storage in a temporary directory that is
+// removed when the test finishes.
+func TempStorage(t testing.TB) *storage.DiskStorage {
+	return storage.NewDiskStorage(t.TempDir())
+}
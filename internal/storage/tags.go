@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/sbrki/snote/internal/util"
+)
+
+// hashtag matches inline tags (#tag, #area/sub/topic) that start a word.
+var hashtag = regexp.MustCompile(`(^|[\s(])#([\p{L}\p{N}_-]+(?:/[\p{L}\p{N}_-]+)*)`)
+
+// allDigits matches hashtags that are issue numbers (#12) rather than tags.
+var allDigits = regexp.MustCompile(`^\d+$`)
+
+// normalizeTag lowercases a tag and strips the leading "#" and the
+// slashes around it.
+func normalizeTag(tag string) string {
+	return strings.Trim(strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#")), "/")
+}
+
+// hashtags returns the tags written as #tag in text, along with their
+// positions ([start, end) of the "#tag").
+func hashtags(text []byte) ([]string, [][2]int) {
+	tags := make([]string, 0)
+	positions := make([][2]int, 0)
+	for _, m := range hashtag.FindAllSubmatchIndex(text, -1) {
+		tag := string(text[m[4]:m[5]])
+		if allDigits.MatchString(tag) {
+			continue
+		}
+		tags = append(tags, normalizeTag(tag))
+		positions = append(positions, [2]int{m[4] - 1, m[5]})
+	}
+	return tags, positions
+}
+
+// TagIncludes reports whether tag is parent or one of its descendants,
+// e.g. "area/sub/topic" is included in "area" and "area/sub".
+func TagIncludes(parent string, tag string) bool {
+	return tag == parent || strings.HasPrefix(tag, parent+"/")
+}
+
+// TaggedNoteIDs returns the sorted IDs of the notes tagged with tag or
+// with one of its descendants.
+func TaggedNoteIDs(tagIndex map[string][]string, tag string) []string {
+	tag = normalizeTag(tag)
+	noteIDs := make([]string, 0)
+	for indexed, ids := range tagIndex {
+		if !TagIncludes(tag, indexed) {
+			continue
+		}
+		for _, id := range ids {
+			if !util.SliceContainsString(noteIDs, id) {
+				noteIDs = append(noteIDs, id)
+			}
+		}
+	}
+	sort.Strings(noteIDs)
+	return noteIDs
+}
+
+// tagAnchor is the id of a tag in the tag tree of /lstag.
+func tagAnchor(tag string) string {
+	return "tag-" + tag
+}
+
+// addHashtagLinks turns the inline tags of the note into links to the tag tree.
+func addHashtagLinks(rootNode ast.Node) {
+	texts := make([]*ast.Text, 0)
+	inLink := 0
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch n := node.(type) {
+		case *ast.Link:
+			if entering {
+				inLink++
+			} else {
+				inLink--
+			}
+		case *ast.Text:
+			if entering && inLink == 0 {
+				texts = append(texts, n)
+			}
+		}
+		return ast.GoToNext
+	})
+
+	for _, text := range texts {
+		literal := text.Literal
+		tags, positions := hashtags(literal)
+		last := 0
+		for i, position := range positions {
+			before := &ast.Text{}
+			before.Literal = literal[last:position[0]]
+			link := &ast.HTMLSpan{}
+			link.Literal = []byte(fmt.Sprintf(`<a class="hashtag" href="/lstag#%s">%s</a>`,
+				html.EscapeString(tagAnchor(tags[i])), html.EscapeString(string(literal[position[0]:position[1]]))))
+			insertBefore(text, before)
+			insertBefore(text, link)
+			last = position[1]
+		}
+		text.Literal = literal[last:]
+	}
+}
+
+// tagTreeNode is a tag of the tag tree of /lstag.
+type tagTreeNode struct {
+	tag      string
+	noteIDs  []string
+	children map[string]*tagTreeNode
+}
+
+// tagTree arranges the tags of tagIndex by their "/" separated hierarchy.
+// Ancestors that do not tag any note themselves are part of the tree too.
+func tagTree(tagIndex map[string][]string) *tagTreeNode {
+	root := &tagTreeNode{children: make(map[string]*tagTreeNode)}
+	for tag, noteIDs := range tagIndex {
+		node := root
+		segments := strings.Split(tag, "/")
+		for i := range segments {
+			child, found := node.children[segments[i]]
+			if !found {
+				child = &tagTreeNode{tag: strings.Join(segments[:i+1], "/"), children: make(map[string]*tagTreeNode)}
+				node.children[segments[i]] = child
+			}
+			node = child
+		}
+		node.noteIDs = append(node.noteIDs, noteIDs...)
+	}
+	return root
+}
+
+// count returns the number of notes tagged with the tag or one of its descendants.
+func (node *tagTreeNode) count() int {
+	noteIDs := make(map[string]bool)
+	var collect func(node *tagTreeNode)
+	collect = func(node *tagTreeNode) {
+		for _, noteID := range node.noteIDs {
+			noteIDs[noteID] = true
+		}
+		for _, child := range node.children {
+			collect(child)
+		}
+	}
+	collect(node)
+	return len(noteIDs)
+}
+
+// html renders the children of node as a list of collapsible tags. Every
+// line is indented, so that the list is a single html block.
+func (node *tagTreeNode) html(depth int) string {
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	indent := strings.Repeat("\t", depth)
+	result := ""
+	for _, name := range names {
+		child := node.children[name]
+		result += fmt.Sprintf("%s\t<li id=\"%s\"><details><summary><code>%s</code> <span class=\"tag-count\">%d</span></summary>\n",
+			indent, html.EscapeString(tagAnchor(child.tag)), html.EscapeString(child.tag), child.count())
+		if len(child.children) > 0 {
+			result += indent + "\t\t<ul>\n" + child.html(depth+2) + indent + "\t\t</ul>\n"
+		}
+		if len(child.noteIDs) > 0 {
+			links := make([]string, 0, len(child.noteIDs))
+			for _, noteID := range child.noteIDs {
+				links = append(links, fmt.Sprintf(`<a href="/%s">%s</a>`, html.EscapeString(noteID), html.EscapeString(noteID)))
+			}
+			result += indent + "\t\t<p class=\"tag-notes\">" + strings.Join(links, " ") + "</p>\n"
+		}
+		result += indent + "\t</details></li>\n"
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const hashtagNote = "# Garden #home\n`tags: Plants, home`\n\nPlanting #area/Garden/roses and (#seeds), not issue #12 or C#.\n\n" +
+	"`#code` and\n\n```\n#fenced\n```\n\n`tags: later`\n"
+
+func TestParseHashtags(t *testing.T) {
+	tags := (&Note{Contents: hashtagNote}).ParseTags()
+	expected := []string{"home", "plants", "area/garden/roses", "seeds", "later"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Error("wrong tags:", tags)
+	}
+}
+
+func TestRenderHashtags(t *testing.T) {
+	html := (&Note{Contents: hashtagNote}).RenderHTML()
+	for _, expected := range []string{
+		`<a class="hashtag" href="/lstag#tag-area/garden/roses">#area/Garden/roses</a>`,
+		`(<a class="hashtag" href="/lstag#tag-seeds">#seeds</a>)`,
+		"issue #12 or C#.",
+		"<code>#code</code>",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("missing %q in %s", expected, html)
+		}
+	}
+}
+
+func TestTagTree(t *testing.T) {
+	tagIndex := map[string][]string{
+		"area/garden/roses": {"roses"},
+		"area/garden":       {"garden", "roses"},
+		"area/house":        {"kitchen"},
+		"misc":              {"misc"},
+	}
+	if noteIDs := TaggedNoteIDs(tagIndex, "#Area/"); !reflect.DeepEqual(noteIDs, []string{"garden", "kitchen", "roses"}) {
+		t.Error("wrong tagged notes:", noteIDs)
+	}
+	if TagIncludes("area/gar", "area/garden") {
+		t.Error("prefix of a segment included")
+	}
+
+	html := tagTree(tagIndex).html(0)
+	for _, expected := range []string{
+		`<li id="tag-area"><details><summary><code>area</code> <span class="tag-count">3</span>`,
+		`<li id="tag-area/garden"><details><summary><code>area/garden</code> <span class="tag-count">2</span>`,
+		`<a href="/kitchen">kitchen</a>`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("missing %q in %s", expected, html)
+		}
+	}
+	if strings.Index(html, "tag-area") > strings.Index(html, "tag-misc") {
+		t.Error("tags not sorted:", html)
+	}
+
+	note := &Note{Contents: "<ul class=\"tag-tree\">\n" + html + "</ul>\n\nafter\n"}
+	if rendered := note.RenderHTML(); !strings.Contains(rendered, "<p>after</p>") || strings.Contains(rendered, "<p>\t") {
+		t.Error("tag tree is not a single html block:", rendered)
+	}
+}
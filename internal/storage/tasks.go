@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// Task is a task list item of a note ("- [ ] open" or "- [x] done").
+type Task struct {
+	// Index is the position of the task among the tasks of its note.
+	Index int `json:"index"`
+	// Line is the 1-based line of the task in the contents of the note.
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+	// Due is the date of a due:YYYY-MM-DD annotation, if the task has one.
+	Due string `json:"due,omitempty"`
+}
+
+// dueDateFormat is the layout of due dates.
+const dueDateFormat = "2006-01-02"
+
+// Overdue reports whether the task is open and was due before today.
+func (task Task) Overdue(today time.Time) bool {
+	return !task.Done && task.Due != "" && task.Due < today.Format(dueDateFormat)
+}
+
+var (
+	// taskLine matches list items starting with a checkbox, also when nested or quoted.
+	taskLine = regexp.MustCompile(`^(\s*(?:>\s*)*(?:[-*+]|\d+[.)])\s+)\[([ xX])\](?:\s+(.*?))?\s*$`)
+	// taskMarker matches the checkbox at the start of the text of a list item.
+	taskMarker = regexp.MustCompile(`^\[([ xX])\](?:\s+|$)`)
+	dueDate    = regexp.MustCompile(`\bdue:(\d{4}-\d{2}-\d{2})\b`)
+	fenceStart = regexp.MustCompile("^\\s*(`{3,}|~{3,})")
+)
+
+// proseLines returns the indexes of the lines outside of the front matter
+// and of fenced code blocks.
+func proseLines(lines []string) []int {
+	indexes := make([]int, 0)
+	fence := ""
+	skip := 0
+	if block, _, found := SplitFrontMatter(strings.Join(lines, "\n")); found {
+		skip = strings.Count(block, "\n") + 2
+	}
+	for i, line := range lines {
+		if i < skip {
+			continue
+		}
+		if m := fenceStart.FindStringSubmatch(line); m != nil {
+			if fence == "" {
+				fence = m[1]
+			} else if m[1][0] == fence[0] && len(m[1]) >= len(fence) && strings.TrimSpace(line) == m[1] {
+				fence = ""
+			}
+			continue
+		}
+		if fence == "" {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// lineMark marks the line a task candidate is on, see findTasks.
+var lineMark = regexp.MustCompile(`^snotetaskline(\d+)z`)
+
+// taskItem is a task along with the position of its list item among all
+// list items of the parsed contents of its note.
+type taskItem struct {
+	Task
+	item int
+}
+
+// findTasks returns the tasks of contents in order. Tasks are the list
+// items of the markdown starting with a checkbox, but list items hold no
+// line numbers: each line that looks like a task gets a mark behind its
+// checkbox, and only the marks that end up at the start of a list item
+// are tasks. The others are in indented code, html blocks and the like.
+func findTasks(contents string) []taskItem {
+	lines := strings.Split(contents, "\n")
+	marked := append([]string{}, lines...)
+	for _, i := range proseLines(lines) {
+		if m := taskLine.FindStringSubmatchIndex(lines[i]); m != nil {
+			// the mark goes behind the "]" of the checkbox
+			marked[i] = lines[i][:m[5]+1] + fmt.Sprintf(" snotetaskline%dz", i) + lines[i][m[5]+1:]
+		}
+	}
+
+	tasks := make([]taskItem, 0)
+	for item, listItem := range listItems(ParseMarkdown(strings.Join(marked, "\n"))) {
+		text, m := taskText(listItem)
+		if m == nil {
+			continue
+		}
+		mark := lineMark.FindSubmatch(text.Literal[len(m[0]):])
+		if mark == nil {
+			continue
+		}
+		i, _ := strconv.Atoi(string(mark[1]))
+		line := taskLine.FindStringSubmatch(lines[i])
+		task := Task{Index: len(tasks), Line: i + 1, Text: line[3], Done: line[2] != " "}
+		if due := dueDate.FindStringSubmatch(line[3]); due != nil {
+			task.Due = due[1]
+		}
+		tasks = append(tasks, taskItem{task, item})
+	}
+	return tasks
+}
+
+// listItems returns the list items below rootNode in document order.
+func listItems(rootNode ast.Node) []*ast.ListItem {
+	items := make([]*ast.ListItem, 0)
+	ast.WalkFunc(rootNode, func(node ast.Node, entering bool) ast.WalkStatus {
+		if n, ok := node.(*ast.ListItem); ok && entering {
+			items = append(items, n)
+		}
+		return ast.GoToNext
+	})
+	return items
+}
+
+// taskText returns the text starting the list item along with the match
+// of the checkbox at its start, which is nil if the item has none.
+func taskText(item *ast.ListItem) (*ast.Text, [][]byte) {
+	if len(item.Children) == 0 {
+		return nil, nil
+	}
+	paragraph, ok := item.Children[0].(*ast.Paragraph)
+	if !ok || len(paragraph.Children) == 0 {
+		return nil, nil
+	}
+	text, ok := paragraph.Children[0].(*ast.Text)
+	if !ok {
+		return nil, nil
+	}
+	return text, taskMarker.FindSubmatch(text.Literal)
+}
+
+// ParseTasks returns the tasks of the note in order.
+func (note *Note) ParseTasks() []Task {
+	tasks := make([]Task, 0)
+	for _, task := range findTasks(note.Contents) {
+		tasks = append(tasks, task.Task)
+	}
+	return tasks
+}
+
+// ToggleTask marks the task at index as done, or as open again if it is
+// done. Only the checkbox on the line of the task is changed.
+func (note *Note) ToggleTask(index int) (Task, error) {
+	tasks := findTasks(note.Contents)
+	if index < 0 || index >= len(tasks) {
+		return Task{}, fmt.Errorf("note %s has no task %d", note.ID, index)
+	}
+	lines := strings.Split(note.Contents, "\n")
+	i := tasks[index].Line - 1
+	m := taskLine.FindStringSubmatchIndex(lines[i])
+	checked := "x"
+	if tasks[index].Done {
+		checked = " "
+	}
+	lines[i] = lines[i][:m[4]] + checked + lines[i][m[5]:]
+	note.Contents = strings.Join(lines, "\n")
+	return note.ParseTasks()[index], nil
+}
+
+// taskCheckbox is the html of the checkbox of a task. Checkboxes are
+// disabled until the page enables them (see static/js/tasks.js). noteID
+// is set for tasks shown outside of their note.
+func taskCheckbox(noteID string, index int, done bool) string {
+	checkbox := `<input type="checkbox" class="task"`
+	if noteID != "" {
+		checkbox += ` data-note="` + html.EscapeString(noteID) + `"`
+	}
+	checkbox += fmt.Sprintf(` data-task="%d"`, index)
+	if done {
+		checkbox += " checked"
+	}
+	return checkbox + " disabled>"
+}
+
+// dueSpan is the html of a due date annotation.
+func dueSpan(date string, done bool, today time.Time) string {
+	class := "due"
+	if (Task{Done: done, Due: date}).Overdue(today) {
+		class += " overdue"
+	}
+	return `<span class="` + class + `">due:` + date + "</span>"
+}
+
+// addTaskCheckboxes turns the checkbox markers of the tasks (see
+// findTasks) of contents, parsed as rootNode, into checkboxes and
+// highlights their due dates. noteID is set for the tasks of embedded
+// notes, see taskCheckbox.
+func addTaskCheckboxes(rootNode ast.Node, contents string, noteID string, today time.Time) {
+	items := listItems(rootNode)
+	for _, task := range findTasks(contents) {
+		text, m := taskText(items[task.item])
+		if m == nil {
+			continue
+		}
+		text.Literal = text.Literal[len(m[0]):]
+		checkbox := &ast.HTMLSpan{}
+		checkbox.Literal = []byte(taskCheckbox(noteID, task.Index, task.Done) + " ")
+		insertBefore(text, checkbox)
+
+		paragraph := text.GetParent()
+		for _, child := range append([]ast.Node{}, paragraph.GetChildren()...) {
+			if text, ok := child.(*ast.Text); ok {
+				highlightDueDates(text, task.Done, today)
+			}
+		}
+	}
+}
+
+// highlightDueDates splits text around its due dates, which are wrapped in spans.
+func highlightDueDates(text *ast.Text, done bool, today time.Time) {
+	literal := text.Literal
+	matches := dueDate.FindAllSubmatchIndex(literal, -1)
+	if matches == nil {
+		return
+	}
+	last := 0
+	for _, m := range matches {
+		before := &ast.Text{}
+		before.Literal = literal[last:m[0]]
+		span := &ast.HTMLSpan{}
+		span.Literal = []byte(dueSpan(string(literal[m[2]:m[3]]), done, today))
+		insertBefore(text, before)
+		insertBefore(text, span)
+		last = m[1]
+	}
+	text.Literal = literal[last:]
+}
+
+// insertBefore inserts node into the parent of sibling, right before it.
+func insertBefore(sibling ast.Node, node ast.Node) {
+	parent := sibling.GetParent()
+	children := parent.GetChildren()
+	for i, child := range children {
+		if child == sibling {
+			children = append(children[:i], append([]ast.Node{node}, children[i:]...)...)
+			break
+		}
+	}
+	parent.SetChildren(children)
+	node.SetParent(parent)
+}
+
+// openTask is an open task listed by GenerateLsTodo.
+type openTask struct {
+	Task
+	note *Note
+	tags []string
+}
+
+// GenerateLsTodo generates the note listing the open tasks of all notes,
+// grouped by note and by tag. Overdue tasks are highlighted, templates
+// are left out.
+func (note *Note) GenerateLsTodo(storage Storage) error {
+	note.ID = "lstodo"
+	note.Title = "lstodo"
+	note.LastEdit = time.Now()
+	today := note.LastEdit
+
+	tagIndex, err := storage.GetAllNoteTags()
+	if err != nil {
+		return err
+	}
+	noteTags := make(map[string][]string)
+	for tag, noteIDs := range tagIndex {
+		for _, noteID := range noteIDs {
+			noteTags[noteID] = append(noteTags[noteID], tag)
+		}
+	}
+	allNoteIDs, err := storage.GetAllNoteIDs()
+	if err != nil {
+		return err
+	}
+
+	byNote := make([][]*openTask, 0)
+	byTag := make(map[string][]*openTask)
+	for _, noteID := range allNoteIDs {
+		if IsAutogenerated(noteID) {
+			continue
+		}
+		stored, err := storage.LoadNote(noteID)
+		if err != nil {
+			// unreadable notes are reported by fsck and listed by /ls
+			continue
+		}
+		if stored.IsTemplate() {
+			continue
+		}
+		tasks := make([]*openTask, 0)
+		for _, task := range stored.ParseTasks() {
+			if task.Done {
+				continue
+			}
+			t := &openTask{task, stored, noteTags[noteID]}
+			tasks = append(tasks, t)
+			for _, tag := range t.tags {
+				byTag[tag] = append(byTag[tag], t)
+			}
+		}
+		if len(tasks) > 0 {
+			byNote = append(byNote, tasks)
+		}
+	}
+
+	lsTodoAsMarkdown := "# Open tasks\n"
+	lsTodoAsMarkdown += "This note is autogenerated, any user changes to it will be ignored.\n\n"
+	lsTodoAsMarkdown += "## By note\n"
+	for _, tasks := range byNote {
+		lsTodoAsMarkdown += fmt.Sprintf("### [%s](/%s)\n", noteLabel(tasks[0].note), tasks[0].note.ID)
+		for _, task := range tasks {
+			lsTodoAsMarkdown += task.markdown(today, false)
+		}
+		lsTodoAsMarkdown += "\n"
+	}
+
+	tags := make([]string, 0, len(byTag))
+	for tag := range byTag {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	lsTodoAsMarkdown += "## By tag\n"
+	for _, tag := range tags {
+		tasks := byTag[tag]
+		// tasks with the earliest due dates first
+		sort.SliceStable(tasks, func(i, j int) bool {
+			if (tasks[i].Due == "") != (tasks[j].Due == "") {
+				return tasks[i].Due != ""
+			}
+			return tasks[i].Due < tasks[j].Due
+		})
+		lsTodoAsMarkdown += "### `" + tag + "`\n"
+		for _, task := range tasks {
+			lsTodoAsMarkdown += task.markdown(today, true)
+		}
+		lsTodoAsMarkdown += "\n"
+	}
+
+	note.Contents = lsTodoAsMarkdown
+	return nil
+}
+
+func noteLabel(note *Note) string {
+	if note.Title != "" {
+		return note.Title
+	}
+	return note.ID
+}
+
+// markdown returns the list item of the task, with a checkbox toggling
+// the task in its note.
+func (t *openTask) markdown(today time.Time, withNote bool) string {
+	text := strings.TrimSpace(dueDate.ReplaceAllString(t.Text, ""))
+	item := "- " + taskCheckbox(t.note.ID, t.Index, false) + " " + text
+	if t.Due != "" {
+		item += " " + dueSpan(t.Due, false, today)
+	}
+	if withNote {
+		item += fmt.Sprintf(" ([%s](/%s))", noteLabel(t.note), t.note.ID)
+	}
+	return item + "\n"
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const taskNote = "# Chores\n\n- [ ] buy *milk* due:2020-01-02\n- [x] wash car\n  1. [ ] nested\n\n```\n- [ ] not a task\n```\n\n> - [X] quoted\n- no task\n"
+
+func TestParseTasks(t *testing.T) {
+	note := &Note{Contents: taskNote}
+	tasks := note.ParseTasks()
+	if len(tasks) != 4 {
+		t.Fatal("wrong tasks:", tasks)
+	}
+	expected := Task{Index: 0, Line: 3, Text: "buy *milk* due:2020-01-02", Due: "2020-01-02"}
+	if tasks[0] != expected {
+		t.Error("wrong task:", tasks[0])
+	}
+	if !tasks[1].Done || tasks[2].Done || tasks[2].Line != 5 || !tasks[3].Done || tasks[3].Text != "quoted" {
+		t.Error("wrong tasks:", tasks[1:])
+	}
+	if !tasks[0].Overdue(time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)) || tasks[0].Overdue(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Error("wrong overdue state")
+	}
+}
+
+func TestToggleTask(t *testing.T) {
+	note := &Note{ID: "chores", Contents: taskNote}
+	task, err := note.ToggleTask(2)
+	if err != nil || !task.Done || task.Text != "nested" {
+		t.Fatal("wrong toggled task:", task, err)
+	}
+	if note.Contents != strings.Replace(taskNote, "1. [ ] nested", "1. [x] nested", 1) {
+		t.Error("wrong contents:", note.Contents)
+	}
+	if task, _ := note.ToggleTask(3); task.Done {
+		t.Error("task not reopened")
+	}
+	if _, err := note.ToggleTask(4); err == nil {
+		t.Error("missing task toggled")
+	}
+}
+
+func TestTasksOutsideOfLists(t *testing.T) {
+	contents := "Example:\n\n    - [ ] not a task\n\n<div>\n- [ ] html\n</div>\n\n- [ ] real one\n"
+	note := &Note{ID: "example", Contents: contents}
+	if tasks := note.ParseTasks(); len(tasks) != 1 || tasks[0].Line != 9 || tasks[0].Text != "real one" {
+		t.Fatal("wrong tasks:", tasks)
+	}
+	if html := note.RenderHTML(); !strings.Contains(html, `data-task="0" disabled> real one`) || strings.Contains(html, "data-task=\"1\"") || strings.Contains(html, "snotetaskline") {
+		t.Error("wrong checkboxes:", html)
+	}
+	if _, err := note.ToggleTask(0); err != nil || note.Contents != strings.Replace(contents, "- [ ] real", "- [x] real", 1) {
+		t.Errorf("wrong task toggled: %q %v", note.Contents, err)
+	}
+}
+
+func TestRenderTasks(t *testing.T) {
+	note := &Note{Contents: taskNote}
+	html := note.RenderHTML()
+	for _, expected := range []string{
+		`<li><input type="checkbox" class="task" data-task="0" disabled> buy <em>milk</em> <span class="due overdue">due:2020-01-02</span></li>`,
+		`<input type="checkbox" class="task" data-task="1" checked disabled> wash car`,
+		`<input type="checkbox" class="task" data-task="3" checked disabled> quoted`,
+		"- [ ] not a task",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("%s not found in:\n%s", expected, html)
+		}
+	}
+}
+
+func TestGenerateLsTodo(t *testing.T) {
+	st := NewDiskStorage(t.TempDir())
+	st.SaveNote(&Note{ID: "chores", Title: "Chores", Contents: taskNote})
+	st.SaveNote(&Note{ID: "work", Title: "Work", Contents: "- [ ] report due:2999-01-01\n- [ ] mail"})
+	st.SaveNote(&Note{ID: "done", Title: "Done", Contents: "- [x] all done"})
+	st.SaveNote(&Note{ID: "standup", Title: "Standup", Contents: "---\ntemplate: true\n---\n- [ ] template task"})
+	st.SetNoteTags("chores", []string{"home"})
+	st.SetNoteTags("work", []string{"home", "job"})
+
+	note := new(Note)
+	if err := note.GenerateLsTodo(st); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"### [Chores](/chores)\n" +
+			`- <input type="checkbox" class="task" data-note="chores" data-task="0" disabled> buy *milk* <span class="due overdue">due:2020-01-02</span>` + "\n" +
+			`- <input type="checkbox" class="task" data-note="chores" data-task="2" disabled> nested` + "\n",
+		"### `home`\n" +
+			`- <input type="checkbox" class="task" data-note="chores" data-task="0" disabled> buy *milk* <span class="due overdue">due:2020-01-02</span> ([Chores](/chores))` + "\n" +
+			`- <input type="checkbox" class="task" data-note="work" data-task="0" disabled> report <span class="due">due:2999-01-01</span> ([Work](/work))` + "\n",
+		"### `job`\n",
+	} {
+		if !strings.Contains(note.Contents, expected) {
+			t.Errorf("%s not found in:\n%s", expected, note.Contents)
+		}
+	}
+	if strings.Contains(note.Contents, "Done") {
+		t.Error("note without open tasks listed")
+	}
+	if strings.Contains(note.Contents, "template task") {
+		t.Error("task of a template listed")
+	}
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TemplateField is the front matter field marking a note as a template.
+// Its value is true, or the tags the template is the default template of:
+//
+//	---
+//	template: [meeting, area/work]
+//	---
+const TemplateField = "template"
+
+// TemplateVars are the values of the placeholders of a template.
+type TemplateVars struct {
+	ID    string
+	Title string
+	Date  time.Time
+}
+
+// placeholder matches {{date}}, {{id}}, {{title}} and {{cursor}}.
+var placeholder = regexp.MustCompile(`\{\{\s*(date|id|title|cursor)\s*\}\}`)
+
+// IsTemplate reports whether the note is a template.
+func (note *Note) IsTemplate() bool {
+	_, found := note.ParseFields()[TemplateField]
+	return found
+}
+
+// ExpandTemplate returns the contents of a new note made from the template
+// note: the template field is dropped from its front matter and the
+// placeholders are replaced with vars. Values replacing placeholders in
+// the front matter are escaped for YAML, see yamlEscape. It also returns
+// the byte offset of the first {{cursor}} placeholder in the contents, or
+// -1 if it has none.
+func (note *Note) ExpandTemplate(vars TemplateVars) (string, int) {
+	contents := dropFrontMatterField(note.Contents, TemplateField)
+	// dropFrontMatterField leaves the front matter with "\n" line endings
+	frontMatterEnd := 0
+	if block, _, found := SplitFrontMatter(contents); found {
+		frontMatterEnd = len("---\n") + len(block)
+	}
+	cursor := -1
+	var b strings.Builder
+	last := 0
+	for _, m := range placeholder.FindAllStringSubmatchIndex(contents, -1) {
+		b.WriteString(contents[last:m[0]])
+		last = m[1]
+		escape := func(value string) string { return value }
+		if m[0] < frontMatterEnd {
+			line := contents[strings.LastIndex(contents[:m[0]], "\n")+1 : m[0]]
+			escape = func(value string) string { return yamlEscape(value, line) }
+		}
+		switch contents[m[2]:m[3]] {
+		case "date":
+			b.WriteString(escape(vars.Date.Format("2006-01-02")))
+		case "id":
+			b.WriteString(escape(vars.ID))
+		case "title":
+			b.WriteString(escape(vars.Title))
+		case "cursor":
+			if cursor < 0 {
+				cursor = b.Len()
+			}
+		}
+	}
+	b.WriteString(contents[last:])
+	return b.String(), cursor
+}
+
+// yamlPlain matches values that can be written as, or within, a plain YAML
+// scalar without changing its meaning.
+var yamlPlain = regexp.MustCompile(`^[\p{L}\p{N}_](?:[\p{L}\p{N} _./()-]*[\p{L}\p{N}_./()-])?$`)
+
+// yamlEscape escapes value for the front matter line it is written at the
+// end of: inside a double or single quoted scalar it is escaped for that
+// quoting, otherwise it is written as it is if that is safe, or else as a
+// double quoted scalar.
+func yamlEscape(value string, line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\'') && startsScalar(line[:i]):
+			quote = c
+		}
+	}
+	switch {
+	case quote == '"':
+		quoted := strconv.Quote(value)
+		return quoted[1 : len(quoted)-1]
+	case quote == '\'':
+		return strings.ReplaceAll(strings.ReplaceAll(value, "'", "''"), "\n", " ")
+	case yamlPlain.MatchString(value):
+		return value
+	default:
+		return strconv.Quote(value)
+	}
+}
+
+// startsScalar reports whether a scalar starts after the front matter text
+// before, that is after a key, a list item dash or the start of a flow
+// collection or of one of its items.
+func startsScalar(before string) bool {
+	before = strings.TrimRight(before, " \t")
+	return before == "" || strings.ContainsAny(before[len(before)-1:], ":-[{,")
+}
+
+// dropFrontMatterField removes the top level field name, along with its
+// nested lines, from the front matter of contents. The front matter is
+// removed altogether if nothing else is left in it.
+func dropFrontMatterField(contents string, name string) string {
+	block, body, found := SplitFrontMatter(contents)
+	if !found {
+		return contents
+	}
+	kept := make([]string, 0)
+	dropping := false
+	for _, line := range strings.SplitAfter(block, "\n") {
+		if line == "" {
+			continue
+		}
+		topLevel := !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t")
+		if topLevel && !strings.HasPrefix(line, "- ") {
+			key := strings.TrimSpace(strings.SplitN(line, ":", 2)[0])
+			dropping = strings.EqualFold(strings.Trim(key, `"'`), name)
+		}
+		if !dropping {
+			kept = append(kept, line)
+		}
+	}
+	if strings.TrimSpace(strings.Join(kept, "")) == "" {
+		return strings.TrimLeft(body, "\n")
+	}
+	return "---\n" + strings.Join(kept, "") + "---\n" + body
+}
+
+// DefaultTemplate returns the ID of the default template of tag: the
+// template listing the tag, or else its closest ancestor, in its template
+// field. Among several templates of a tag the first by ID wins.
+func DefaultTemplate(storage Storage, tag string) (string, bool, error) {
+	fieldIndex, err := storage.GetAllNoteFields()
+	if err != nil {
+		return "", false, err
+	}
+	defaults := make(map[string][]string)
+	for value, noteIDs := range fieldIndex[TemplateField] {
+		normalized := normalizeTag(value)
+		if normalized == "true" {
+			continue
+		}
+		defaults[normalized] = append(defaults[normalized], noteIDs...)
+	}
+	for tag = normalizeTag(tag); tag != ""; tag = parentTag(tag) {
+		if noteIDs := defaults[tag]; len(noteIDs) > 0 {
+			sort.Strings(noteIDs)
+			return noteIDs[0], true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// parentTag returns the parent of a hierarchical tag, or "" for a top level tag.
+func parentTag(tag string) string {
+	if i := strings.LastIndex(tag, "/"); i >= 0 {
+		return tag[:i]
+	}
+	return ""
+}
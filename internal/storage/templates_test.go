@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const meetingTemplate = "---\ntemplate:\n  - meeting\n  - area/work\ntitle: \"{{title}}\"\ncreated: \"{{date}}\"\n---\n" +
+	"# {{ title }}\n\nAgenda: {{cursor}}\n\nSee [[{{id}}-notes]], {{unknown}} and {{cursor}}.\n"
+
+func TestExpandTemplate(t *testing.T) {
+	template := &Note{ID: "meeting-template", Contents: meetingTemplate}
+	if !template.IsTemplate() || (&Note{Contents: "---\ntitle: x\n---\n"}).IsTemplate() {
+		t.Error("templates not told apart")
+	}
+
+	contents, cursor := template.ExpandTemplate(TemplateVars{ID: "standup", Title: "Standup", Date: time.Date(2024, 5, 31, 9, 0, 0, 0, time.UTC)})
+	expected := "---\ntitle: \"Standup\"\ncreated: \"2024-05-31\"\n---\n# Standup\n\nAgenda: \n\nSee [[standup-notes]], {{unknown}} and .\n"
+	if contents != expected {
+		t.Errorf("wrong contents: %q", contents)
+	}
+	if contents[cursor:] != "\n\nSee [[standup-notes]], {{unknown}} and .\n" {
+		t.Error("wrong cursor:", cursor)
+	}
+	note := &Note{ID: "standup", Contents: contents}
+	if note.IsTemplate() || note.ParseTitle() != "Standup" {
+		t.Error("template field kept:", contents)
+	}
+
+	contents, cursor = (&Note{Contents: "---\ntemplate: true\n---\n\n# {{title}}\n"}).ExpandTemplate(TemplateVars{Title: "Plain"})
+	if contents != "# Plain\n" || cursor != -1 {
+		t.Errorf("wrong contents or cursor: %q %d", contents, cursor)
+	}
+}
+
+func TestExpandTemplateEscapes(t *testing.T) {
+	title := `Q&A: "plans" #1, it's {on}`
+	template := &Note{Contents: "---\ntemplate: true\ntitle: {{title}}\naliases: ['{{title}}', \"{{ title }}\", {{id}}]\nstatus: Bob's {{id}}\n---\n# {{title}}\n"}
+	contents, _ := template.ExpandTemplate(TemplateVars{ID: "q-and-a", Title: title})
+	note := &Note{Contents: contents}
+	fm, err := note.ParseFrontMatter()
+	if err != nil {
+		t.Fatal(err, contents)
+	}
+	if fm.Title != title || len(fm.Aliases) != 3 || fm.Aliases[0] != title || fm.Aliases[1] != title || fm.Aliases[2] != "q-and-a" {
+		t.Errorf("wrong front matter: %+v", fm)
+	}
+	if fields := note.ParseFields(); fields["status"][0] != "Bob's q-and-a" {
+		t.Error("wrong fields:", fields)
+	}
+	if !strings.HasSuffix(contents, "\n# "+title+"\n") {
+		t.Errorf("body escaped: %q", contents)
+	}
+}
+
+func TestDefaultTemplate(t *testing.T) {
+	st := NewDiskStorage(t.TempDir())
+
+	for id, contents := range map[string]string{
+		"meeting-template": meetingTemplate,
+		"a-work-template":  "---\ntemplate: [area/work]\n---\n",
+		"plain-template":   "---\ntemplate: true\n---\n",
+	} {
+		if err := UpdateNote(st, &Note{ID: id, Contents: contents}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for tag, expected := range map[string]string{
+		"Meeting":             "meeting-template",
+		"area/work/standups":  "a-work-template",
+		"#area/work":          "a-work-template",
+		"area":                "",
+		"true":                "",
+		"meeting/retrospects": "meeting-template",
+	} {
+		templateID, found, err := DefaultTemplate(st, tag)
+		if err != nil || found != (expected != "") || templateID != expected {
+			t.Errorf("wrong default template of %s: %q %v %v", tag, templateID, found, err)
+		}
+	}
+}
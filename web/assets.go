@@ -0,0 +1,143 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"path"
+	"text/template"
+)
+
+// Assets builds cache-busting URLs for static files, the server serves
+// them (see server.NewServer).
+// Every URL carries a short content hash as a query parameter, so that
+// responses can be cached forever by the browser.
+type Assets struct {
+	fsys   fs.FS
+	dev    bool
+	hashes map[string]string
+	// base is the URL prefix of static files.
+	base string
+	// bust adds content hashes to URLs, it is off for relative URLs.
+	bust bool
+}
+
+// NewAssets creates Assets serving files from fsys (rooted at the static
+// directory). In dev mode, hashes are recomputed on every lookup and
+// responses are never cached, so that edits on disk show up immediately.
+func NewAssets(fsys fs.FS, dev bool) (*Assets, error) {
+	a := new(Assets)
+	a.fsys = fsys
+	a.dev = dev
+	a.hashes = make(map[string]string)
+	a.base = "/static/"
+	a.bust = true
+	if dev {
+		return a, nil
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		hash, err := a.hashFile(name)
+		if err != nil {
+			return err
+		}
+		a.hashes[name] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Assets) hashFile(name string) (string, error) {
+	f, err := a.fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil))[:12], nil
+}
+
+// Hash returns the content hash of the static file with the given name,
+// it reports false if there is no such file.
+func (a *Assets) Hash(name string) (string, bool) {
+	if a.dev {
+		hash, err := a.hashFile(name)
+		return hash, err == nil
+	}
+	hash, found := a.hashes[name]
+	return hash, found
+}
+
+// Relative returns a copy of a whose URLs are relative to base, e.g.
+// "static/", and carry no query parameters. It is used for pages that
+// are opened from the filesystem, where query parameters are not supported.
+func (a *Assets) Relative(base string) *Assets {
+	relative := *a
+	relative.base = base
+	relative.bust = false
+	return &relative
+}
+
+// Dev reports whether a is in dev mode, see NewAssets.
+func (a *Assets) Dev() bool {
+	return a.dev
+}
+
+// FS returns the static files served by a.
+func (a *Assets) FS() fs.FS {
+	return a.fsys
+}
+
+// URL returns the cache-busting URL of the static file with the given name.
+func (a *Assets) URL(name string) string {
+	hash, found := a.Hash(name)
+	if !found || !a.bust {
+		return a.base + name
+	}
+	return a.base + name + "?v=" + hash
+}
+
+// VendorScript returns a <script> tag loading the vendored asset name.
+func (a *Assets) VendorScript(name string) string {
+	return `<script src="` + a.URL(path.Join("vendor", name)) + `"></script>`
+}
+
+// VendorStyle returns a <link> tag loading the vendored stylesheet name.
+func (a *Assets) VendorStyle(name string) string {
+	return `<link rel="stylesheet" href="` + a.URL(path.Join("vendor", name)) + `">`
+}
+
+// MissingVendored returns the names of the third-party assets listed in
+// Vendored that are not in the static files, see `go generate ./web`.
+func (a *Assets) MissingVendored() []string {
+	missing := make([]string, 0)
+	for _, asset := range Vendored {
+		if _, err := fs.Stat(a.fsys, path.Join("vendor", asset.Name)); err != nil {
+			missing = append(missing, asset.Name)
+		}
+	}
+	return missing
+}
+
+// TemplateFuncs returns the functions available to templates. Besides the
+// asset functions, "noteURL" returns the link to a note and "editable"
+// tells whether pages should offer editing, which a static export does not.
+func TemplateFuncs(assets *Assets, noteURL func(id string) string, editable bool) template.FuncMap {
+	return template.FuncMap{
+		"static":       assets.URL,
+		"vendorScript": assets.VendorScript,
+		"vendorStyle":  assets.VendorStyle,
+		"noteURL":      noteURL,
+		"editable":     func() bool { return editable },
+	}
+}
@@ -34,7 +34,8 @@ $vimCheckbox.addEventListener("change", () => {
 	editor.focus();
 });
 
-const [ , currentNoteId ] = window.location.pathname.split("/");
+// note IDs can contain slashes (journal/2024-05-31)
+const currentNoteId = window.location.pathname.slice(1).replace(/\/edit$/, "");
 
 // set the preview href
 $previewUrl.href = `/${currentNoteId}`;
@@ -61,6 +62,13 @@ let noteJson;
 	$editor.value = contents;
 	editor.getDoc().setValue(contents);
 	editor.save = saveNote;
+
+	// notes made from a template open with the cursor at its {{cursor}}
+	const cursor = window.location.hash.match(/^#cursor=(\d+)$/);
+	if (cursor) {
+		editor.getDoc().setCursor(editor.getDoc().posFromIndex(Number(cursor[1])));
+		editor.focus();
+	}
 })();
 
 async function saveNote() {
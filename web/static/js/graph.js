@@ -0,0 +1,136 @@
+// Draws the graph of the links between notes with a simple force layout:
+// linked notes attract each other, all notes repel each other. Notes are
+// coloured by cluster, isolated notes are grey. Clicking a note opens it.
+const params = new URLSearchParams(window.location.search);
+const $svg = document.getElementById("graph");
+const svgNS = "http://www.w3.org/2000/svg";
+
+// keep the filter shown in the form
+for (const name of ["tag", "note", "depth"]) {
+	document.querySelector(`#graph-filter [name=${name}]`).value = params.get(name) || "";
+}
+
+function clusterColor(node) {
+	if (node.degree === 0) {
+		return "#bbb";
+	}
+	// spread the hues of the clusters by the golden angle
+	return `hsl(${(node.cluster * 137.5) % 360}, 60%, 50%)`;
+}
+
+function layout(nodes, links, width, height) {
+	const byId = new Map(nodes.map((node) => [node.id, node]));
+	nodes.forEach((node, i) => {
+		// start on a spiral, so that no two notes start at the same place
+		const angle = i * 2.4;
+		const radius = 10 * Math.sqrt(i);
+		node.x = width / 2 + radius * Math.cos(angle);
+		node.y = height / 2 + radius * Math.sin(angle);
+	});
+	const ticks = 300;
+	for (let tick = 0; tick < ticks; tick++) {
+		const cooling = 1 - tick / ticks;
+		nodes.forEach((node) => { node.dx = 0; node.dy = 0; });
+		for (let i = 0; i < nodes.length; i++) {
+			for (let j = i + 1; j < nodes.length; j++) {
+				const a = nodes[i], b = nodes[j];
+				const dx = a.x - b.x, dy = a.y - b.y;
+				const distance2 = Math.max(dx * dx + dy * dy, 1);
+				const force = 800 / distance2;
+				a.dx += dx * force; a.dy += dy * force;
+				b.dx -= dx * force; b.dy -= dy * force;
+			}
+		}
+		links.forEach((link) => {
+			const a = byId.get(link.source), b = byId.get(link.target);
+			const dx = b.x - a.x, dy = b.y - a.y;
+			a.dx += dx * 0.05; a.dy += dy * 0.05;
+			b.dx -= dx * 0.05; b.dy -= dy * 0.05;
+		});
+		nodes.forEach((node) => {
+			// a weak pull to the centre keeps isolated notes and clusters in view
+			node.dx += (width / 2 - node.x) * 0.01;
+			node.dy += (height / 2 - node.y) * 0.01;
+			const step = Math.hypot(node.dx, node.dy);
+			const limit = 20 * cooling;
+			if (step > limit) {
+				node.dx *= limit / step;
+				node.dy *= limit / step;
+			}
+			node.x += node.dx;
+			node.y += node.dy;
+		});
+	}
+}
+
+function draw(graph) {
+	const width = $svg.clientWidth, height = $svg.clientHeight;
+	layout(graph.nodes, graph.links, width, height);
+	const byId = new Map(graph.nodes.map((node) => [node.id, node]));
+
+	// fit the drawing into the view
+	const xs = graph.nodes.map((node) => node.x), ys = graph.nodes.map((node) => node.y);
+	const margin = 40;
+	const minX = Math.min(...xs) - margin, minY = Math.min(...ys) - margin;
+	$svg.setAttribute("viewBox", `${minX} ${minY} ${Math.max(...xs) + margin - minX} ${Math.max(...ys) + margin - minY}`);
+
+	graph.links.forEach((link) => {
+		const a = byId.get(link.source), b = byId.get(link.target);
+		const $line = document.createElementNS(svgNS, "line");
+		$line.setAttribute("x1", a.x);
+		$line.setAttribute("y1", a.y);
+		$line.setAttribute("x2", b.x);
+		$line.setAttribute("y2", b.y);
+		$line.classList.add("graph-link");
+		$svg.appendChild($line);
+	});
+	graph.nodes.forEach((node) => {
+		const $node = document.createElementNS(svgNS, "a");
+		$node.setAttribute("href", `/${node.id}`);
+		$node.classList.add("graph-node");
+		if (node.id === params.get("note")) {
+			$node.classList.add("graph-center");
+		}
+		const $circle = document.createElementNS(svgNS, "circle");
+		$circle.setAttribute("cx", node.x);
+		$circle.setAttribute("cy", node.y);
+		$circle.setAttribute("r", 4 + 2 * Math.sqrt(node.degree));
+		$circle.setAttribute("fill", clusterColor(node));
+		const $title = document.createElementNS(svgNS, "title");
+		$title.textContent = node.tags.length > 0 ? `${node.id} (#${node.tags.join(", #")})` : node.id;
+		$circle.appendChild($title);
+		const $label = document.createElementNS(svgNS, "text");
+		$label.setAttribute("x", node.x + 8);
+		$label.setAttribute("y", node.y + 4);
+		$label.textContent = node.title || node.id;
+		$node.appendChild($circle);
+		$node.appendChild($label);
+		$svg.appendChild($node);
+	});
+}
+
+async function loadGraph() {
+	const query = new URLSearchParams();
+	for (const [name, value] of params) {
+		if (value !== "") {
+			query.set(name, value);
+		}
+	}
+	const response = await fetch(`/api/graph?${query}`);
+	if (response.status === 404) {
+		alert("No such note in the graph!");
+		return;
+	} else if (response.status !== 200) {
+		alert("Error loading the graph!");
+		alert(response.status);
+		return;
+	}
+	const graph = await response.json();
+	const clusters = new Set(graph.nodes.filter((node) => node.degree > 0).map((node) => node.cluster));
+	const isolated = graph.nodes.filter((node) => node.degree === 0).length;
+	document.getElementById("graph-summary").textContent =
+		`${graph.nodes.length} notes, ${graph.links.length} links, ${clusters.size} clusters, ${isolated} isolated`;
+	draw(graph);
+}
+
+loadGraph();
@@ -2,6 +2,19 @@ async function newNotePrompt() {
 	const newNoteId = window.prompt("Enter ID of the new note:\n(the note will live at /<note ID>)");
 	let formData = new FormData();
 	formData.append("suggested_id", newNoteId);
+
+	// offer the template notes, if there are any
+	const templatesResponse = await fetch(`/api/note?field=template`);
+	if (templatesResponse.status === 200) {
+		const templates = (await templatesResponse.json()).map((note) => note.id);
+		if (templates.length > 0) {
+			const template = window.prompt(`Template of the new note (leave empty for none):\n${templates.join(", ")}`);
+			if (template) {
+				formData.append("template", template);
+			}
+		}
+	}
+
 	const newNoteResponse = await fetch(`/api/note`,
 		{
 			method: "POST",
@@ -12,10 +25,15 @@ async function newNotePrompt() {
 	if (newNoteResponse.status === 409) {
 		alert("Note already exists!");
 		return;
+	} else if (newNoteResponse.status === 400) {
+		alert("No such template!");
+		return;
 	} else if (newNoteResponse.status !== 201) {
 		alert("Error creating note!");
 		alert(newNoteResponse.status);
 		return;
 	}
-	window.location.replace(`/${newNoteId}/edit`);
+	// the editor puts the cursor where the template has its {{cursor}}
+	const { cursor } = await newNoteResponse.json();
+	window.location.replace(`/${newNoteId}/edit` + (cursor !== undefined ? `#cursor=${cursor}` : ""));
 }
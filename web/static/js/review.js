@@ -0,0 +1,77 @@
+// Shows the flashcards that are due one after another: the question first,
+// then the answer along with the buttons grading the review.
+const tag = new URLSearchParams(window.location.search).get("tag") || "";
+const query = tag ? `?tag=${encodeURIComponent(tag)}` : "";
+let currentCard = null;
+
+document.getElementById("review-export").href = `/api/review/export${query}`;
+
+async function nextCard() {
+	const response = await fetch(`/api/review/next${query}`);
+	const $card = document.getElementById("review-card");
+	const $remaining = document.getElementById("review-remaining");
+	if (response.status === 204) {
+		currentCard = null;
+		$card.style.display = "none";
+		$remaining.textContent = "No cards are due, well done!";
+		return;
+	} else if (response.status !== 200) {
+		alert("Error loading the next card!");
+		alert(response.status);
+		return;
+	}
+
+	currentCard = await response.json();
+	$remaining.textContent = `${currentCard.remaining} card${currentCard.remaining === 1 ? "" : "s"} due` + (tag ? ` in #${tag}` : "");
+	document.getElementById("review-question").textContent = currentCard.question;
+	document.getElementById("review-answer").textContent = currentCard.answer;
+	document.getElementById("review-answer").style.display = "none";
+	document.getElementById("review-grades").style.display = "none";
+	document.getElementById("review-show").style.display = "";
+	const $source = document.getElementById("review-source");
+	$source.href = `/${currentCard.note_id}`;
+	$source.textContent = `from ${currentCard.note_id}`;
+	$card.style.display = "";
+}
+
+function showAnswer() {
+	document.getElementById("review-show").style.display = "none";
+	document.getElementById("review-answer").style.display = "";
+	document.getElementById("review-grades").style.display = "";
+}
+
+async function grade(value) {
+	if (currentCard === null) {
+		return;
+	}
+	let formData = new FormData();
+	formData.append("grade", value);
+	const response = await fetch(`/api/review/${currentCard.id}/grade`,
+		{
+			method: "POST",
+			body: formData,
+		},
+	);
+	if (response.status !== 200) {
+		alert("Error grading the card!");
+		alert(response.status);
+		return;
+	}
+	nextCard();
+}
+
+document.getElementById("review-show").addEventListener("click", showAnswer);
+document.querySelectorAll("#review-grades button").forEach(($button) => {
+	$button.addEventListener("click", () => grade($button.dataset.grade));
+});
+// space shows the answer, the digits 0 to 5 grade it
+document.addEventListener("keydown", (event) => {
+	if (event.key === " " && document.getElementById("review-show").style.display !== "none") {
+		event.preventDefault();
+		showAnswer();
+	} else if (/^[0-5]$/.test(event.key) && document.getElementById("review-grades").style.display !== "none") {
+		grade(event.key);
+	}
+});
+
+nextCard();
@@ -0,0 +1,23 @@
+// Task list checkboxes are rendered disabled, enable them and toggle
+// their task on the server when clicked. Checkboxes listed outside of
+// their note (in /lstodo) carry the ID of the note.
+const currentNoteId = window.location.pathname.slice(1);
+
+document.querySelectorAll("input.task").forEach(($checkbox) => {
+	$checkbox.disabled = false;
+	$checkbox.addEventListener("change", async () => {
+		const noteId = $checkbox.dataset.note || currentNoteId;
+		const response = await fetch(
+			`/api/note/${noteId}/task/${$checkbox.dataset.task}/toggle`,
+			{
+				method: "POST",
+			},
+		);
+
+		if (response.status !== 200) {
+			$checkbox.checked = !$checkbox.checked;
+			alert("Error toggling task!");
+			alert(response.status);
+		}
+	});
+});
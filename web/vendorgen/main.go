@@ -0,0 +1,64 @@
+// Command vendorgen downloads the third-party assets listed in web.Vendored
+// into web/static/vendor, verifying their subresource integrity hashes.
+// It is run through `go generate ./web`.
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/sbrki/snote/web"
+)
+
+func main() {
+	// go generate runs in the directory of the file containing the directive
+	vendorDir := filepath.Join("static", "vendor")
+
+	failed := false
+	for _, asset := range web.Vendored {
+		if err := fetch(asset, vendorDir); err != nil {
+			fmt.Fprintln(os.Stderr, asset.Name+":", err)
+			failed = true
+			continue
+		}
+		fmt.Println("vendored", asset.Name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func fetch(asset web.VendorAsset, vendorDir string) error {
+	resp, err := http.Get(asset.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", asset.URL, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	sum := sha512.Sum512(b)
+	integrity := "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+	if asset.Integrity == "" {
+		// unpinned asset, print the hash so that it can be pinned
+		fmt.Println("unpinned", asset.Name, integrity)
+	} else if asset.Integrity != integrity {
+		return fmt.Errorf("integrity mismatch: expected %s, got %s", asset.Integrity, integrity)
+	}
+
+	dst := filepath.Join(vendorDir, filepath.FromSlash(asset.Name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, b, 0644)
+}
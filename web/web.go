@@ -0,0 +1,101 @@
+// Package web holds the HTML templates and static assets of snote.
+// Both are embedded into the binary, so snote can be started from any
+// working directory and does not need network access to serve its UI.
+// Assets and TemplateFuncs are shared by the server and static exports.
+package web
+
+//go:generate go run ./vendorgen
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+//go:embed templates static
+var embedded embed.FS
+
+// RenderCSS is the stylesheet of rendered notes, static/render.css. Exports
+// use it too, so they look like notes in the browser.
+//
+//go:embed static/render.css
+var RenderCSS string
+
+// FS returns a filesystem containing the "templates" and "static" directories.
+// If dir is empty, the copy embedded into the binary is used. Otherwise files
+// are read from disk at dir, which is handy while working on the frontend.
+func FS(dir string) fs.FS {
+	if dir == "" {
+		return embedded
+	}
+	return os.DirFS(dir)
+}
+
+// VendorAsset is a third-party asset that is vendored into static/vendor.
+type VendorAsset struct {
+	// Name is the path of the asset relative to static/vendor.
+	Name string
+	// URL is the upstream location the asset is vendored from.
+	URL string
+	// Integrity is the subresource integrity hash of the upstream file.
+	Integrity string
+}
+
+// Vendored lists all third-party assets used by the templates. They are
+// served from static/vendor only, run `go generate ./web` to (re)download
+// them there and commit them.
+var Vendored = []VendorAsset{
+	{
+		Name:      "codemirror/codemirror.min.css",
+		URL:       "https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.59.0/codemirror.min.css",
+		Integrity: "sha512-MWdvo/Qqcf4pY1ecQUB1uBn0qLp19U/qJ1Rpp2BDZeuBA7YsFEwkvqR/+aG4BroPiAYDunKJ6X8R/Pmdt3p7oA==",
+	},
+	{
+		Name:      "codemirror/theme/rubyblue.min.css",
+		URL:       "https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.59.0/theme/rubyblue.min.css",
+		Integrity: "sha512-pt+OhZW7o2pmHEahNFroPWkGR89L0tmDqCzXK+7WM1vGLtUyxms1JxZsXgJbOdFwylRnEt0yHnU6y2uAs40FxQ==",
+	},
+	{
+		Name:      "codemirror/codemirror.min.js",
+		URL:       "https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.59.0/codemirror.min.js",
+		Integrity: "sha512-guAOPzMlYhWXne9TpfFRWD7iI0YnDTVqNN8fNgZGeqcmZFuUKWxD1/74Rsse81voD2uzxyBJkkp97G/tahKipg==",
+	},
+	{
+		Name:      "codemirror/keymap/vim.min.js",
+		URL:       "https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.59.0/keymap/vim.min.js",
+		Integrity: "sha512-g2nzBS/fBHxdSRXaDcYGHVg2Rjk7+3gITKVMv1q/ylh2izUiw1AZ50urelrhy7I6EYLQi5RM8FFj3rdATH5wIg==",
+	},
+	{
+		Name:      "codemirror/mode/markdown/markdown.min.js",
+		URL:       "https://cdnjs.cloudflare.com/ajax/libs/codemirror/5.59.0/mode/markdown/markdown.min.js",
+		Integrity: "sha512-pPkSf38IdZFkYSNeSKtKBG1ou5FuWwHYuDEiRJRs29igFixW47YY2iIKWhSfDLbpZlI5NO5b3M28KW/u3K2hJw==",
+	},
+	{
+		Name: "notyf/notyf.min.css",
+		URL:  "https://cdn.jsdelivr.net/npm/notyf@3.10.0/notyf.min.css",
+	},
+	{
+		Name: "notyf/notyf.min.js",
+		URL:  "https://cdn.jsdelivr.net/npm/notyf@3.10.0/notyf.min.js",
+	},
+	{
+		Name:      "pure/pure-min.css",
+		URL:       "https://cdnjs.cloudflare.com/ajax/libs/pure/2.0.3/pure-min.css",
+		Integrity: "sha512-FEioxlObRXIskNAQ1/L0byx0SEkfAY+5fO024p9kGEfUQnACGRfCG5Af4bp/7sPNSzKbMtvmcJOWZC7fPX1/FA==",
+	},
+	{
+		Name:      "animate/animate.min.css",
+		URL:       "https://cdnjs.cloudflare.com/ajax/libs/animate.css/4.1.1/animate.min.css",
+		Integrity: "sha512-c42qTSw/wPZ3/5LBzD+Bw5f7bSF2oxou6wEb+I/lqeaKV5FDIfMvvRp772y4jcJLKuGUOpbJMdg/BTl50fJYAw==",
+	},
+	{
+		Name:      "dropzone/basic.min.css",
+		URL:       "https://cdnjs.cloudflare.com/ajax/libs/dropzone/5.7.2/basic.min.css",
+		Integrity: "sha512-MeagJSJBgWB9n+Sggsr/vKMRFJWs+OUphiDV7TJiYu+TNQD9RtVJaPDYP8hA/PAjwRnkdvU+NsTncYTKlltgiw==",
+	},
+	{
+		Name:      "dropzone/dropzone.min.js",
+		URL:       "https://cdnjs.cloudflare.com/ajax/libs/dropzone/5.7.2/min/dropzone.min.js",
+		Integrity: "sha512-9WciDs0XP20sojTJ9E7mChDXy6pcO0qHpwbEJID1YVavz2H6QBz5eLoDD8lseZOb2yGT8xDNIV7HIe1ZbuiDWg==",
+	},
+}